@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/priyansh-dimri/argus/pkg/argus"
+	"github.com/priyansh-dimri/argus/pkg/tracing"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 func main() {
@@ -38,21 +44,74 @@ func main() {
 		log.Fatalf("Invalid TARGET_URL: %v", err)
 	}
 
-	waf, err := argus.NewWAF()
+	config, err := argus.ConfigFromEnv(argus.Config{})
+	if err != nil {
+		log.Fatalf("Invalid Argus configuration: %v", err)
+	}
+
+	traceConfig, err := tracing.ConfigFromEnv("argus-sidecar")
+	if err != nil {
+		log.Fatalf("Invalid tracing configuration: %v", err)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), traceConfig)
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	waf, err := argus.NewWAF(config)
 	if err != nil {
 		log.Fatalf("Error initializing WAF: %v", err)
 	}
+	go reloadOnSIGHUP(waf)
+
+	if dir := getEnv("ARGUS_RULES_WATCH_DIR", ""); dir != "" {
+		go func() {
+			err := waf.WatchRules(context.Background(), dir, 5*time.Second, logReload)
+			if err != nil {
+				log.Printf("Rule watcher stopped: %v", err)
+			}
+		}()
+	}
+
+	if adminAddr := getEnv("ARGUS_ADMIN_ADDR", ""); adminAddr != "" {
+		adminToken := getEnv("ARGUS_ADMIN_TOKEN", "")
+		if adminToken == "" {
+			log.Fatal("ARGUS_ADMIN_TOKEN is required with ARGUS_ADMIN_ADDR")
+		}
+		admin := http.NewServeMux()
+		admin.Handle("/reload", requireToken(adminToken, waf.ReloadHandler()))
+		go func() {
+			log.Printf("Admin listener on %s", adminAddr)
+			log.Fatal(http.ListenAndServe(adminAddr, admin))
+		}()
+	}
 	client := argus.NewClient(argusAPIURL, apiKey, 20*time.Second)
 
-	mwLatency := argus.NewMiddleware(client, waf, argus.Config{Mode: argus.LatencyFirst})
-	mwSmart := argus.NewMiddleware(client, waf, argus.Config{Mode: argus.SmartShield})
-	mwParanoid := argus.NewMiddleware(client, waf, argus.Config{Mode: argus.Paranoid})
+	if metricsAddr := getEnv("ARGUS_METRICS_ADDR", ""); metricsAddr != "" {
+		config.Metrics = argus.NewMetrics()
+		metrics := http.NewServeMux()
+		metrics.Handle("/metrics", config.Metrics)
+		go func() {
+			log.Printf("Metrics listener on %s", metricsAddr)
+			log.Fatal(http.ListenAndServe(metricsAddr, metrics))
+		}()
+	}
+	config.Breaker.OnStateChange = func(name string, from, to argus.BreakerState) {
+		log.Printf("Circuit breaker %s: %s -> %s", name, from, to)
+	}
+
+	mwLatency := argus.NewMiddleware(client, waf, withMode(config, argus.LatencyFirst))
+	mwSmart := argus.NewMiddleware(client, waf, withMode(config, argus.SmartShield))
+	mwParanoid := argus.NewMiddleware(client, waf, withMode(config, argus.Paranoid))
+	mwShadow := argus.NewMiddleware(client, waf, withMode(config, argus.Shadow))
 
 	proxy := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
 			req.URL.Scheme = target.Scheme
 			req.URL.Host = target.Host
 			req.Host = target.Host
+			tracing.Propagator.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
 		},
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
 			log.Printf("Proxy error: %v", err)
@@ -64,15 +123,51 @@ func main() {
 	mux.Handle("/latency-first/", stripAndProtect(mwLatency, "/latency-first", proxy))
 	mux.Handle("/smart-shield/", stripAndProtect(mwSmart, "/smart-shield", proxy))
 	mux.Handle("/paranoid/", stripAndProtect(mwParanoid, "/paranoid", proxy))
+	mux.Handle("/shadow/", stripAndProtect(mwShadow, "/shadow", proxy))
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "Argus Multi-Mode Sidecar Active.\nUse /latency-first/, /smart-shield/, or /paranoid/ as your entry point.")
+		fmt.Fprintf(w, "Argus Multi-Mode Sidecar Active.\nUse /latency-first/, /smart-shield/, /paranoid/ or /shadow/ as your entry point.")
 	})
 
 	fmt.Printf("Argus Sidecar Dynamic Routing Active\n")
 	fmt.Printf("Proxying to: %s\n", targetAddr)
 	fmt.Printf("Listening on :%s\n", listenPort)
 
-	log.Fatal(http.ListenAndServe(":"+listenPort, mux))
+	server := &http.Server{Addr: ":" + listenPort, Handler: mux}
+	drained := make(chan struct{})
+	go func() {
+		shutdownOnSignal(server, mwLatency, mwSmart, mwParanoid, mwShadow)
+		close(drained)
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-drained
+}
+
+// shutdownOnSignal stops the server on SIGINT or SIGTERM, then sends the
+// async events the middlewares still hold.
+func shutdownOnSignal(server *http.Server, middlewares ...*argus.Middleware) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown: %v", err)
+	}
+	for _, mw := range middlewares {
+		if err := mw.Close(ctx); err != nil {
+			log.Printf("Async events not drained: %v", err)
+		}
+	}
+}
+
+func withMode(config argus.Config, mode argus.SecurityMode) argus.Config {
+	config.Mode = mode
+	return config
 }
 
 func stripAndProtect(mw *argus.Middleware, prefix string, next http.Handler) http.Handler {
@@ -87,6 +182,36 @@ func stripAndProtect(mw *argus.Middleware, prefix string, next http.Handler) htt
 	})
 }
 
+// requireToken serves only requests with token as their bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func reloadOnSIGHUP(waf *argus.WAFWrapper) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	for range sighup {
+		logReload(waf.Reload())
+	}
+}
+
+func logReload(err error) {
+	if err != nil {
+		log.Printf("WAF rules reload failed, keeping current rules: %v", err)
+		return
+	}
+	log.Printf("WAF rules reloaded")
+}
+
 func getEnv(key, fallback string) string {
 	if value, ok := os.LookupEnv(key); ok {
 		return value
@@ -12,6 +12,7 @@ import (
 	"github.com/priyansh-dimri/argus/internal/api"
 	"github.com/priyansh-dimri/argus/internal/storage"
 	"github.com/priyansh-dimri/argus/pkg/logger"
+	"github.com/priyansh-dimri/argus/pkg/tracing"
 )
 
 func main() {
@@ -41,9 +42,29 @@ func main() {
 		"db_url_length", len(dbURL),
 	)
 
-	logger.Info("Initializing database connection", "component", "main")
 	ctx := context.Background()
 
+	traceConfig, err := tracing.ConfigFromEnv("argus-api")
+	if err != nil {
+		logger.Error("Invalid tracing configuration", err, "component", "main")
+		os.Exit(1)
+	}
+	shutdownTracing, err := tracing.Setup(ctx, traceConfig)
+	if err != nil {
+		logger.Error("Failed to set up tracing", err,
+			"component", "main",
+			"exporter", traceConfig.Exporter,
+		)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+	logger.Info("Tracing configured",
+		"component", "main",
+		"exporter", traceConfig.Exporter,
+	)
+
+	logger.Info("Initializing database connection", "component", "main")
+
 	config, err := pgxpool.ParseConfig(dbURL)
 	if err != nil {
 		logger.Error("Failed to parse DATABASE_URL", err,
@@ -108,8 +129,8 @@ func main() {
 	logger.Info("Setting up HTTP router", "component", "main")
 	router := api.NewRouter(handler, authMiddleware)
 
-	logger.Info("Wrapping router with CORS middleware", "component", "main")
-	corsHandler := authMiddleware.CORS(router)
+	logger.Info("Wrapping router with CORS and tracing middleware", "component", "main")
+	corsHandler := authMiddleware.Trace(authMiddleware.CORS(router))
 
 	initDuration := time.Since(startTime)
 	logger.Info("Initialization complete, starting HTTP server",
@@ -8,8 +8,14 @@ import (
 
 	"github.com/priyansh-dimri/argus/pkg/logger"
 	"github.com/priyansh-dimri/argus/pkg/protocol"
+	"github.com/priyansh-dimri/argus/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerName = "github.com/priyansh-dimri/argus/internal/analyzer"
+
 type AIClient interface {
 	Generate(ctx context.Context, prompt string) (string, error)
 	CountTokens(ctx context.Context, text string) (int, error)
@@ -29,7 +35,13 @@ func NewAnalyzer(c AIClient) *Analyzer {
 	}
 }
 
-func (analyzer *Analyzer) Analyze(ctx context.Context, req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+func (analyzer *Analyzer) Analyze(ctx context.Context, req protocol.AnalysisRequest) (_ protocol.AnalysisResponse, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "analyzer.Analyze", trace.WithAttributes(
+		attribute.String("argus.route", req.Route),
+		attribute.Int("argus.log.length", len(req.Log)),
+	))
+	defer func() { tracing.End(span, err) }()
+
 	logger.Info("Starting security analysis",
 		"component", "analyzer",
 		"log_length", len(req.Log),
@@ -44,6 +56,11 @@ func (analyzer *Analyzer) Analyze(ctx context.Context, req protocol.AnalysisRequ
 	originalLogLen := len(req.Log)
 	req.Log = TruncateLog(ctx, analyzer.client, req.Log, maxTokens)
 
+	span.SetAttributes(
+		attribute.Int("argus.log.truncated_length", len(req.Log)),
+		attribute.Bool("argus.log.truncated", len(req.Log) != originalLogLen),
+	)
+
 	logger.Info("Log truncation done",
 		"component", "analyzer",
 		"original_length", originalLogLen,
@@ -107,6 +124,11 @@ func (analyzer *Analyzer) Analyze(ctx context.Context, req protocol.AnalysisRequ
 		return protocol.AnalysisResponse{}, ErrMalformedAIResponse
 	}
 
+	span.SetAttributes(
+		attribute.Bool("argus.ai.is_threat", *response.IsThreat),
+		attribute.Float64("argus.ai.confidence", *response.Confidence),
+	)
+
 	logger.Info("Analysis completed successfully",
 		"component", "analyzer",
 		"is_threat", *response.IsThreat,
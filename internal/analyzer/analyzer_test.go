@@ -7,6 +7,10 @@ import (
 	"testing"
 
 	"github.com/priyansh-dimri/argus/pkg/protocol"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 type mockAIClient struct {
@@ -220,6 +224,48 @@ func TestAnalyzer(t *testing.T) {
 	})
 }
 
+func TestAnalyzerTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	mockClient := &mockAIClient{
+		Resp: `{"is_threat": true, "reason": "SQL injection", "confidence": 0.9}`,
+		CountTokensFunc: func(ctx context.Context, text string) (int, error) {
+			return 5000, nil
+		},
+	}
+
+	_, err := NewAnalyzer(mockClient).Analyze(context.Background(), newTestRequest(strings.Repeat("A", 10000)))
+	assertNoError(t, err)
+
+	attributes := map[string]map[attribute.Key]attribute.Value{}
+	for _, span := range recorder.Ended() {
+		attributes[span.Name()] = map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			attributes[span.Name()][kv.Key] = kv.Value
+		}
+	}
+
+	analyze, ok := attributes["analyzer.Analyze"]
+	if !ok {
+		t.Fatal("expected an analyzer.Analyze span")
+	}
+	if !analyze["argus.log.truncated"].AsBool() {
+		t.Error("expected argus.log.truncated=true")
+	}
+	if !analyze["argus.ai.is_threat"].AsBool() {
+		t.Error("expected argus.ai.is_threat=true")
+	}
+	if analyze["argus.ai.confidence"].AsFloat64() != 0.9 {
+		t.Errorf("expected argus.ai.confidence=0.9, got %v", analyze["argus.ai.confidence"].AsFloat64())
+	}
+	if got := attributes["analyzer.TruncateLog"]["argus.log.token_count"].AsInt64(); got != 5000 {
+		t.Errorf("expected argus.log.token_count=5000, got %d", got)
+	}
+}
+
 func assertError(t testing.TB, got, want error) {
 	t.Helper()
 
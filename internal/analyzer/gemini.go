@@ -5,6 +5,10 @@ import (
 	"fmt"
 
 	"github.com/priyansh-dimri/argus/pkg/logger"
+	"github.com/priyansh-dimri/argus/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/genai"
 )
 
@@ -42,7 +46,15 @@ func NewGeminiClient(ctx context.Context, apiKey string, modelName string) (*Gem
 	}, nil
 }
 
-func (g *GeminiClient) Generate(ctx context.Context, prompt string) (string, error) {
+func (g *GeminiClient) Generate(ctx context.Context, prompt string) (_ string, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "gemini.Generate", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gen_ai.system", "gemini"),
+			attribute.String("gen_ai.request.model", g.model),
+			attribute.Int("argus.prompt.length", len(prompt)),
+		))
+	defer func() { tracing.End(span, err) }()
+
 	logger.Info("Starting content generation request",
 		"component", "gemini",
 		"model", g.model,
@@ -63,6 +75,13 @@ func (g *GeminiClient) Generate(ctx context.Context, prompt string) (string, err
 		)
 		return "", fmt.Errorf("gemini response generation failed: %w", err)
 	}
+	if usage := response.UsageMetadata; usage != nil {
+		span.SetAttributes(
+			attribute.Int("gen_ai.usage.input_tokens", int(usage.PromptTokenCount)),
+			attribute.Int("gen_ai.usage.output_tokens", int(usage.CandidatesTokenCount)),
+		)
+	}
+
 	logger.Info("Received response from Gemini API",
 		"component", "gemini",
 		"candidates_count", len(response.Candidates),
@@ -105,7 +124,15 @@ func (g *GeminiClient) Generate(ctx context.Context, prompt string) (string, err
 	return sb, nil
 }
 
-func (g *GeminiClient) CountTokens(ctx context.Context, text string) (int, error) {
+func (g *GeminiClient) CountTokens(ctx context.Context, text string) (_ int, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "gemini.CountTokens", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gen_ai.system", "gemini"),
+			attribute.String("gen_ai.request.model", g.model),
+			attribute.Int("argus.text.length", len(text)),
+		))
+	defer func() { tracing.End(span, err) }()
+
 	logger.Info("Starting token count request",
 		"component", "gemini",
 		"model", g.model,
@@ -123,6 +150,7 @@ func (g *GeminiClient) CountTokens(ctx context.Context, text string) (int, error
 	}
 
 	tokenCount := int(resp.TotalTokens)
+	span.SetAttributes(attribute.Int("gen_ai.usage.input_tokens", tokenCount))
 	logger.Info("Token count completed",
 		"component", "gemini",
 		"text_length", len(text),
@@ -4,9 +4,24 @@ import (
 	"context"
 
 	"github.com/priyansh-dimri/argus/pkg/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func TruncateLog(ctx context.Context, client AIClient, log string, maxTokens int) string {
+func TruncateLog(ctx context.Context, client AIClient, log string, maxTokens int) (truncated string) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "analyzer.TruncateLog", trace.WithAttributes(
+		attribute.Int("argus.log.length", len(log)),
+		attribute.Int("argus.max_tokens", maxTokens),
+	))
+	defer func() {
+		span.SetAttributes(
+			attribute.Bool("argus.log.truncated", truncated != log),
+			attribute.Int("argus.log.truncated_length", len(truncated)),
+		)
+		span.End()
+	}()
+
 	logger.Info("Starting log truncation check",
 		"component", "truncator",
 		"log_length", len(log),
@@ -24,6 +39,8 @@ func TruncateLog(ctx context.Context, client AIClient, log string, maxTokens int
 
 	count, err := client.CountTokens(ctx, log)
 	if err != nil {
+		span.RecordError(err)
+		span.SetAttributes(attribute.Bool("argus.log.safe_mode", true))
 		logger.Warn("Failed to count tokens during truncation, falling back to safe mode",
 			"component", "truncator",
 			"error", err,
@@ -48,6 +65,8 @@ func TruncateLog(ctx context.Context, client AIClient, log string, maxTokens int
 		return log
 	}
 
+	span.SetAttributes(attribute.Int("argus.log.token_count", count))
+
 	logger.Info("Token count completed successfully",
 		"component", "truncator",
 		"token_count", count,
@@ -3,11 +3,17 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"mime"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/priyansh-dimri/argus/pkg/logger"
 	"github.com/priyansh-dimri/argus/pkg/protocol"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Analyzer interface {
@@ -29,6 +35,20 @@ type API struct {
 	Analyzer      Analyzer
 	Store         Store
 	ErrorReporter func(msg string, err error, args ...any)
+	// BatchWorkers analyze the events of accepted batches, BatchQueueSize
+	// batches may wait for them. Zero means 4 and 16.
+	BatchWorkers   int
+	BatchQueueSize int
+
+	batchesOnce sync.Once
+	batches     chan analysisBatch
+}
+
+// analysisBatch is an accepted /analyze/batch call waiting for a worker.
+type analysisBatch struct {
+	ctx       context.Context
+	projectID string
+	events    []protocol.AnalysisRequest
 }
 
 func NewAPI(analyzer Analyzer, store Store) *API {
@@ -76,6 +96,10 @@ func (api *API) HandleAnalyze(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "JSON decoding error", http.StatusBadRequest)
 		return
 	}
+	if !upgradeRequest(&req, requestVersion(r)) {
+		http.Error(w, "Unsupported protocol version", http.StatusBadRequest)
+		return
+	}
 
 	logger.Info("Analysis request decoded successfully",
 		"component", "handler",
@@ -83,7 +107,10 @@ func (api *API) HandleAnalyze(w http.ResponseWriter, r *http.Request) {
 		"log_length", len(req.Log),
 	)
 
-	aiCtx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("argus.project_id", projectID))
+
+	// The analysis outlives a client that hangs up, but stays in its trace
+	aiCtx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 60*time.Second)
 	defer cancel()
 
 	logger.Info("Starting AI analysis",
@@ -126,6 +153,7 @@ func (api *API) HandleAnalyze(w http.ResponseWriter, r *http.Request) {
 		)
 	}
 
+	settleShadow(&req, res)
 	go func() {
 		saveStart := time.Now()
 		logger.Info("Starting background threat save",
@@ -152,6 +180,156 @@ func (api *API) HandleAnalyze(w http.ResponseWriter, r *http.Request) {
 	}()
 }
 
+// maxAnalyzeBatch caps how many events one /analyze/batch call can carry,
+// maxAnalyzeBatchBytes how big its body can be.
+const (
+	maxAnalyzeBatch      = 500
+	maxAnalyzeBatchBytes = 64 << 20
+
+	defaultBatchWorkers   = 4
+	defaultBatchQueueSize = 16
+	batchRetryAfter       = "30"
+)
+
+// HandleAnalyzeBatch takes the async events the SDK log queue batches up. It
+// answers 202 right away and leaves the batch to the workers, which analyze
+// and save its events in the background since nobody waits on their
+// verdicts. With the queue full it answers 503.
+func (api *API) HandleAnalyzeBatch(w http.ResponseWriter, r *http.Request) {
+	projectID, ok := GetProjectID(r.Context())
+	if !ok || projectID == "" {
+		logger.Warn("Analyze batch request missing project context",
+			"component", "handler",
+			"remote_addr", r.RemoteAddr,
+		)
+		http.Error(w, "Unauthorized: Missing Project Context", http.StatusUnauthorized)
+		return
+	}
+
+	var batch []protocol.AnalysisRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnalyzeBatchBytes)).Decode(&batch); err != nil {
+		logger.Error("Failed to decode analysis batch JSON", err,
+			"component", "handler",
+			"project_id", projectID,
+			"remote_addr", r.RemoteAddr,
+		)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Batch too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "JSON decoding error", http.StatusBadRequest)
+		return
+	}
+	if len(batch) > maxAnalyzeBatch {
+		http.Error(w, "Batch too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	version := requestVersion(r)
+	for i := range batch {
+		if !upgradeRequest(&batch[i], version) {
+			http.Error(w, "Unsupported protocol version", http.StatusBadRequest)
+			return
+		}
+	}
+
+	job := analysisBatch{ctx: context.WithoutCancel(r.Context()), projectID: projectID, events: batch}
+	select {
+	case api.batchQueue() <- job:
+	default:
+		logger.Warn("Analysis batch queue full",
+			"component", "handler",
+			"project_id", projectID,
+			"events", len(batch),
+		)
+		w.Header().Set("Retry-After", batchRetryAfter)
+		http.Error(w, "Analysis queue full", http.StatusServiceUnavailable)
+		return
+	}
+
+	logger.Info("Analysis batch accepted",
+		"component", "handler",
+		"project_id", projectID,
+		"events", len(batch),
+	)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// batchQueue is the queue of accepted batches, started with its workers on
+// first use.
+func (api *API) batchQueue() chan<- analysisBatch {
+	api.batchesOnce.Do(func() {
+		workers, size := api.BatchWorkers, api.BatchQueueSize
+		if workers <= 0 {
+			workers = defaultBatchWorkers
+		}
+		if size <= 0 {
+			size = defaultBatchQueueSize
+		}
+		api.batches = make(chan analysisBatch, size)
+		for range workers {
+			go api.batchWorker()
+		}
+	})
+	return api.batches
+}
+
+func (api *API) batchWorker() {
+	for job := range api.batches {
+		for _, req := range job.events {
+			aiCtx, cancel := context.WithTimeout(job.ctx, 60*time.Second)
+			res, err := api.Analyzer.Analyze(aiCtx, req)
+			cancel()
+			if err != nil {
+				logger.Error("Batch event analysis failed", err,
+					"component", "handler",
+					"project_id", job.projectID,
+				)
+				continue
+			}
+
+			settleShadow(&req, res)
+			if err := api.Store.SaveThreat(job.ctx, job.projectID, req, res); err != nil && api.ErrorReporter != nil {
+				api.ErrorReporter("Failed to save threat log", err,
+					"component", "handler",
+					"project_id", job.projectID,
+				)
+			}
+		}
+	}
+}
+
+// settleShadow fills in the would_block of a Shadow report that left it to
+// the verdict of its analysis.
+func settleShadow(req *protocol.AnalysisRequest, res protocol.AnalysisResponse) {
+	if req.MetaData["would_block"] == protocol.WouldBlockIfThreat {
+		req.MetaData["would_block"] = strconv.FormatBool(res.IsThreat != nil && *res.IsThreat)
+	}
+}
+
+// requestVersion is the schema version of analysis requests that have no
+// version field: version 2 when they are sent as protocol.ContentTypeV2,
+// version 1 otherwise.
+func requestVersion(r *http.Request) int {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == protocol.ContentTypeV2 {
+		return protocol.Version2
+	}
+	return protocol.Version1
+}
+
+// upgradeRequest brings req to the current schema. It reports false for a
+// version the backend doesn't know.
+func upgradeRequest(req *protocol.AnalysisRequest, version int) bool {
+	if req.Version == 0 {
+		req.Version = version
+	}
+	if req.Version < protocol.Version1 || req.Version > protocol.Version2 {
+		return false
+	}
+	req.Upgrade()
+	return true
+}
+
 func (api *API) HandleCreateProject(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	logger.Info("HandleCreateProject started",
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -131,6 +132,60 @@ func TestAnalyzeHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("upgrade version 1 requests", func(t *testing.T) {
+		mock := newMockAnalyzer(sampleThreat(), nil)
+		api := &API{Analyzer: mock, Store: &mockStore{}}
+
+		request_body := map[string]any{
+			"log":      "test",
+			"headers":  map[string]string{"Method": "GET", "Content-Type": "text/plain"},
+			"metadata": map[string]string{"request_id": "req-1"},
+		}
+		req, recorder := newJSONRequest(t, http.MethodPost, "/analyze", request_body)
+		api.HandleAnalyze(recorder, req)
+
+		assertStatusCode(t, recorder.Code, http.StatusOK)
+		got := mock.PrevRequest
+		if got.Version != protocol.Version2 || got.Method != "GET" || got.ContentType != "text/plain" || got.RequestID != "req-1" {
+			t.Errorf("expected an upgraded GET request; got %+v", got)
+		}
+		if want := (protocol.Headers{"Content-Type": {"text/plain"}}); !reflect.DeepEqual(got.Headers, want) {
+			t.Errorf("expected headers %v; got %v", want, got.Headers)
+		}
+	})
+
+	t.Run("take versionless requests as version 2 by content type", func(t *testing.T) {
+		mock := newMockAnalyzer(sampleThreat(), nil)
+		api := &API{Analyzer: mock, Store: &mockStore{}}
+
+		request_body := map[string]any{
+			"method":  "POST",
+			"query":   "a=1",
+			"headers": map[string][]string{"Accept": {"text/html", "application/json"}, "Method": {"kept"}},
+		}
+		req, recorder := newJSONRequest(t, http.MethodPost, "/analyze", request_body)
+		req.Header.Set("Content-Type", protocol.ContentTypeV2)
+		api.HandleAnalyze(recorder, req)
+
+		assertStatusCode(t, recorder.Code, http.StatusOK)
+		got := mock.PrevRequest
+		if got.Version != protocol.Version2 || got.Method != "POST" || got.Query != "a=1" {
+			t.Errorf("expected a version 2 POST request; got %+v", got)
+		}
+		if len(got.Headers["Accept"]) != 2 || got.Headers.Get("Method") != "kept" {
+			t.Errorf("expected headers to be taken as they are; got %v", got.Headers)
+		}
+	})
+
+	t.Run("reject unknown versions", func(t *testing.T) {
+		api := &API{Analyzer: newMockAnalyzer(sampleThreat(), nil), Store: &mockStore{}}
+
+		req, recorder := newJSONRequest(t, http.MethodPost, "/analyze", map[string]any{"version": 3, "log": "test"})
+		api.HandleAnalyze(recorder, req)
+
+		assertStatusCode(t, recorder.Code, http.StatusBadRequest)
+	})
+
 	t.Run("return unauthorized when project context is missing", func(t *testing.T) {
 		api := &API{Analyzer: nil, Store: nil}
 
@@ -143,6 +198,125 @@ func TestAnalyzeHandler(t *testing.T) {
 	})
 }
 
+func TestAnalyzeBatchHandler(t *testing.T) {
+	t.Run("accept batch and save every event asynchronously", func(t *testing.T) {
+		mock := newMockAnalyzer(sampleThreat(), nil)
+		saveChan := make(chan struct{}, 2)
+		store := &mockStore{SaveSignal: saveChan}
+		api := &API{Analyzer: mock, Store: store}
+
+		batch := []map[string]string{{"log": "first"}, {"log": "second"}}
+		req, recorder := newJSONRequest(t, http.MethodPost, "/analyze/batch", batch)
+		api.HandleAnalyzeBatch(recorder, req)
+
+		assertStatusCode(t, recorder.Code, http.StatusAccepted)
+
+		for range 2 {
+			select {
+			case <-saveChan:
+			case <-time.After(100 * time.Millisecond):
+				t.Fatal("Timed out waiting for SaveThreat call")
+			}
+		}
+	})
+
+	t.Run("settle would_block of Shadow reports with the verdict", func(t *testing.T) {
+		mock := newMockAnalyzer(sampleThreat(), nil)
+		saveChan := make(chan struct{}, 1)
+		store := &mockStore{SaveSignal: saveChan}
+		api := &API{Analyzer: mock, Store: store}
+
+		batch := []map[string]any{{"log": "x", "metadata": map[string]string{"shadow_mode": "PARANOID", "would_block": protocol.WouldBlockIfThreat}}}
+		req, recorder := newJSONRequest(t, http.MethodPost, "/analyze/batch", batch)
+		api.HandleAnalyzeBatch(recorder, req)
+
+		select {
+		case <-saveChan:
+		case <-time.After(100 * time.Millisecond):
+			t.Fatal("Timed out waiting for SaveThreat call")
+		}
+		if got := store.Req.MetaData["would_block"]; got != "true" {
+			t.Errorf("Expected would_block true, got %q", got)
+		}
+	})
+
+	t.Run("answer 503 when the queue is full", func(t *testing.T) {
+		analyzer := &blockingAnalyzer{started: make(chan struct{}, 4), release: make(chan struct{})}
+		defer close(analyzer.release)
+		api := &API{Analyzer: analyzer, Store: &mockStore{}, BatchWorkers: 1, BatchQueueSize: 1}
+
+		send := func() *httptest.ResponseRecorder {
+			req, recorder := newJSONRequest(t, http.MethodPost, "/analyze/batch", []map[string]string{{"log": "x"}})
+			api.HandleAnalyzeBatch(recorder, req)
+			return recorder
+		}
+
+		assertStatusCode(t, send().Code, http.StatusAccepted)
+		select {
+		case <-analyzer.started:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for the worker")
+		}
+		assertStatusCode(t, send().Code, http.StatusAccepted)
+
+		recorder := send()
+		assertStatusCode(t, recorder.Code, http.StatusServiceUnavailable)
+		if recorder.Header().Get("Retry-After") == "" {
+			t.Error("Expected a Retry-After header")
+		}
+	})
+
+	t.Run("return error for invalid JSON", func(t *testing.T) {
+		api := &API{}
+
+		req, recorder := newJSONRequest(t, http.MethodPost, "/analyze/batch", map[string]string{"log": "not a list"})
+		api.HandleAnalyzeBatch(recorder, req)
+
+		assertStatusCode(t, recorder.Code, http.StatusBadRequest)
+	})
+
+	t.Run("reject batch with an unknown version", func(t *testing.T) {
+		api := &API{}
+
+		batch := []map[string]any{{"log": "first"}, {"version": 3, "log": "second"}}
+		req, recorder := newJSONRequest(t, http.MethodPost, "/analyze/batch", batch)
+		api.HandleAnalyzeBatch(recorder, req)
+
+		assertStatusCode(t, recorder.Code, http.StatusBadRequest)
+	})
+
+	t.Run("reject oversized batch body", func(t *testing.T) {
+		api := &API{}
+
+		batch := []protocol.AnalysisRequest{{Log: strings.Repeat("a", maxAnalyzeBatchBytes)}}
+		req, recorder := newJSONRequest(t, http.MethodPost, "/analyze/batch", batch)
+		api.HandleAnalyzeBatch(recorder, req)
+
+		assertStatusCode(t, recorder.Code, http.StatusRequestEntityTooLarge)
+	})
+
+	t.Run("reject oversized batch", func(t *testing.T) {
+		api := &API{}
+
+		batch := make([]protocol.AnalysisRequest, maxAnalyzeBatch+1)
+		req, recorder := newJSONRequest(t, http.MethodPost, "/analyze/batch", batch)
+		api.HandleAnalyzeBatch(recorder, req)
+
+		assertStatusCode(t, recorder.Code, http.StatusRequestEntityTooLarge)
+	})
+
+	t.Run("return unauthorized when project context is missing", func(t *testing.T) {
+		api := &API{}
+
+		req := httptest.NewRequest(http.MethodPost, "/analyze/batch", nil)
+		recorder := httptest.NewRecorder()
+
+		api.HandleAnalyzeBatch(recorder, req)
+
+		assertStatusCode(t, recorder.Code, http.StatusUnauthorized)
+	})
+}
+
 func TestHandleCreateProject(t *testing.T) {
 	t.Run("return unauthorized when user_id is missing", func(t *testing.T) {
 		api := &API{Store: &mockStore{}}
@@ -13,6 +13,11 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/priyansh-dimri/argus/internal/api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type mockAuthStore struct {
@@ -246,3 +251,51 @@ func TestCORS(t *testing.T) {
 		}
 	})
 }
+
+func TestTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	mw := api.NewMiddleware(&mockAuthStore{})
+
+	t.Run("Continues the trace of the traceparent header", func(t *testing.T) {
+		var handlerSpan trace.SpanContext
+		handler := mw.Trace(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerSpan = trace.SpanContextFromContext(r.Context())
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		req := httptest.NewRequest("POST", "/analyze", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got := handlerSpan.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("Expected the SDK trace ID, got %s", got)
+		}
+		spans := recorder.Ended()
+		span := spans[len(spans)-1]
+		if span.Name() != "POST /analyze" {
+			t.Errorf("Expected span POST /analyze, got %s", span.Name())
+		}
+		if span.Parent().SpanID().String() != "00f067aa0ba902b7" {
+			t.Errorf("Expected the SDK span as parent, got %s", span.Parent().SpanID())
+		}
+		if span.SpanKind() != trace.SpanKindServer {
+			t.Errorf("Expected a server span, got %s", span.SpanKind())
+		}
+	})
+
+	t.Run("Marks server errors", func(t *testing.T) {
+		handler := mw.Trace(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/projects", nil))
+
+		spans := recorder.Ended()
+		if got := spans[len(spans)-1].Status().Code; got != codes.Error {
+			t.Errorf("Expected error status, got %s", got)
+		}
+	})
+}
@@ -22,6 +22,19 @@ func newMockAnalyzer(response protocol.AnalysisResponse, err error) *mockAnalyze
 	return &mockAnalyzer{Response: response, Err: err}
 }
 
+// blockingAnalyzer signals started on each call and holds it until release
+// is closed.
+type blockingAnalyzer struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingAnalyzer) Analyze(ctx context.Context, req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return protocol.AnalysisResponse{}, nil
+}
+
 type mockStore struct {
 	Saved           bool
 	ProjectID       string
@@ -6,6 +6,7 @@ func NewRouter(api *API, mw *Middleware) *http.ServeMux {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("POST /analyze", mw.AuthSDK(api.HandleAnalyze))
+	mux.HandleFunc("POST /analyze/batch", mw.AuthSDK(api.HandleAnalyzeBatch))
 	mux.HandleFunc("POST /projects", mw.AuthDashboard(api.HandleCreateProject))
 	mux.HandleFunc("GET /projects", mw.AuthDashboard(api.HandleListProjects))
 	mux.HandleFunc("PATCH /projects", mw.AuthDashboard(api.HandleUpdateProject))
@@ -59,6 +59,20 @@ func TestRouter(t *testing.T) {
 			authHeader:     "Bearer argus_valid_key",
 			expectedStatus: http.StatusMethodNotAllowed,
 		},
+		{
+			name:           "Valid + Authenticated POST /analyze/batch",
+			method:         http.MethodPost,
+			path:           "/analyze/batch",
+			authHeader:     "Bearer argus_valid_key",
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:           "Valid + Unauthenticated POST /analyze/batch",
+			method:         http.MethodPost,
+			path:           "/analyze/batch",
+			authHeader:     "",
+			expectedStatus: http.StatusUnauthorized,
+		},
 		{
 			name:           "Unknown Route POST /random",
 			method:         http.MethodPost,
@@ -179,6 +193,8 @@ func TestRouter(t *testing.T) {
 			switch {
 			case tc.method == http.MethodPost && tc.path == "/analyze":
 				body = strings.NewReader(`{"log": "test"}`)
+			case tc.method == http.MethodPost && tc.path == "/analyze/batch":
+				body = strings.NewReader(`[{"log": "test"}]`)
 			case tc.method == http.MethodPost && tc.path == "/projects":
 				body = strings.NewReader(`{"name": "test project"}`)
 			case tc.method == http.MethodPatch && tc.path == "/projects":
@@ -45,6 +45,9 @@ func (s *SupabaseStore) SaveThreat(ctx context.Context, projectID string, req pr
 		"is_threat", res.IsThreat,
 	)
 
+	// version 1 requests carry the method in their headers
+	req.Upgrade()
+
 	headersJSON, err := json.Marshal(req.Headers)
 	if err != nil {
 		logger.Error("Failed to marshal headers", err,
@@ -55,6 +58,18 @@ func (s *SupabaseStore) SaveThreat(ctx context.Context, projectID string, req pr
 		headersJSON = []byte("{}")
 	}
 
+	cookiesJSON := []byte("{}")
+	if req.Cookies != nil {
+		if cookiesJSON, err = json.Marshal(req.Cookies); err != nil {
+			logger.Error("Failed to marshal cookies", err,
+				"component", "storage",
+				"operation", "SaveThreat",
+				"project_id", projectID,
+			)
+			return fmt.Errorf("error while marshaling cookies: %w", err)
+		}
+	}
+
 	metadataJSON, err := json.Marshal(req.MetaData)
 	if err != nil {
 		logger.Error("Failed to marshal metadata", err,
@@ -83,14 +98,24 @@ func (s *SupabaseStore) SaveThreat(ctx context.Context, projectID string, req pr
 			is_threat, 
 			reason, 
 			confidence, 
-			timestamp
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW())
+			timestamp,
+			protocol_version,
+			request_id,
+			query,
+			cookies,
+			content_type,
+			request_timestamp
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), $11, $12, $13, $14, $15, $16)
 	`
 
 	method := "UNKNOWN"
+	if req.Method != "" {
+		method = req.Method
+	}
 
-	if m, ok := req.Headers["Method"]; ok {
-		method = m
+	var requestTimestamp *time.Time
+	if !req.Timestamp.IsZero() {
+		requestTimestamp = &req.Timestamp
 	}
 
 	_, err = s.db.Exec(ctx, query,
@@ -104,6 +129,12 @@ func (s *SupabaseStore) SaveThreat(ctx context.Context, projectID string, req pr
 		*res.IsThreat,
 		*res.Reason,
 		*res.Confidence,
+		req.Version,
+		nullString(req.RequestID),
+		nullString(req.Query),
+		cookiesJSON,
+		nullString(req.ContentType),
+		requestTimestamp,
 	)
 
 	if err != nil {
@@ -495,3 +526,11 @@ func (s *SupabaseStore) generateAPIKey() (string, error) {
 
 	return apiKey, nil
 }
+
+// nullString stores an empty string as NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -26,8 +26,8 @@ func TestSupabase(t *testing.T) {
 	request := protocol.AnalysisRequest{
 		Log: "admin' --",
 		IP:  "11.1.2.3",
-		Headers: map[string]string{
-			"Method": "POST",
+		Headers: protocol.Headers{
+			"Method": {"POST"},
 		},
 		Route: "/api/login",
 		MetaData: map[string]string{
@@ -45,10 +45,12 @@ func TestSupabase(t *testing.T) {
 		Confidence: &confidence,
 	}
 
-	headersBytes, _ := json.Marshal(request.Headers)
+	headersBytes := []byte("{}")
 	metaDataBytes, _ := json.Marshal(request.MetaData)
 
 	projectID := "project_123"
+	var nilString *string
+	var nilTime *time.Time
 
 	t.Run("insert threat log into db", func(t *testing.T) {
 		mock.ExpectExec("INSERT INTO threat_logs").
@@ -63,6 +65,12 @@ func TestSupabase(t *testing.T) {
 				*response.IsThreat,
 				*response.Reason,
 				*response.Confidence,
+				protocol.Version2,
+				nilString,
+				nilString,
+				[]byte("{}"),
+				nilString,
+				nilTime,
 			).
 			WillReturnResult(pgxmock.NewResult("INSERT", 1))
 
@@ -90,6 +98,12 @@ func TestSupabase(t *testing.T) {
 				*response.IsThreat,
 				*response.Reason,
 				*response.Confidence,
+				protocol.Version2,
+				nilString,
+				nilString,
+				[]byte("{}"),
+				nilString,
+				nilTime,
 			).
 			WillReturnError(errors.New("db connection lost"))
 
@@ -106,7 +120,7 @@ func TestSupabase(t *testing.T) {
 
 	t.Run("use default UNKNOWN when method header is missing", func(t *testing.T) {
 		reqMissingMethod := request
-		reqMissingMethod.Headers = map[string]string{}
+		reqMissingMethod.Headers = protocol.Headers{}
 		missingMethodHeadersBytes, _ := json.Marshal(reqMissingMethod.Headers)
 		missingMethodMetadataBytes, _ := json.Marshal(reqMissingMethod.MetaData)
 
@@ -122,6 +136,12 @@ func TestSupabase(t *testing.T) {
 				*response.IsThreat,
 				*response.Reason,
 				*response.Confidence,
+				protocol.Version2,
+				nilString,
+				nilString,
+				[]byte("{}"),
+				nilString,
+				nilTime,
 			).
 			WillReturnResult(pgxmock.NewResult("INSERT", 1))
 
@@ -135,6 +155,56 @@ func TestSupabase(t *testing.T) {
 			t.Errorf("expectation not met: %s", err)
 		}
 	})
+
+	t.Run("store version 2 fields in their columns", func(t *testing.T) {
+		requestID := "req-1"
+		query := "q=1&q=2"
+		contentType := "application/json"
+		timestamp := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		reqV2 := protocol.AnalysisRequest{
+			Version:     protocol.Version2,
+			RequestID:   requestID,
+			Timestamp:   timestamp,
+			Method:      "PUT",
+			Route:       "/api/items",
+			Query:       query,
+			IP:          "11.1.2.3",
+			Headers:     protocol.Headers{"Accept": {"text/html", "application/json"}},
+			Cookies:     map[string]string{"theme": "dark"},
+			ContentType: contentType,
+			Log:         "{}",
+			MetaData:    map[string]string{},
+		}
+
+		mock.ExpectExec("INSERT INTO threat_logs").
+			WithArgs(
+				projectID,
+				reqV2.IP,
+				reqV2.Route,
+				"PUT",
+				[]byte(`{"Accept":["text/html","application/json"]}`),
+				[]byte("{}"),
+				reqV2.Log,
+				*response.IsThreat,
+				*response.Reason,
+				*response.Confidence,
+				protocol.Version2,
+				&requestID,
+				&query,
+				[]byte(`{"theme":"dark"}`),
+				&contentType,
+				&timestamp,
+			).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+
+		if err := store.SaveThreat(context.Background(), projectID, reqV2, response); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("expectation not met: %s", err)
+		}
+	})
 }
 
 func TestSupabaseStore_ProjectManagement(t *testing.T) {
@@ -0,0 +1,169 @@
+package argus
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BlockFormat is the body format of a block response.
+type BlockFormat string
+
+const (
+	BlockText BlockFormat = "TEXT"
+	// BlockProblemJSON answers with an RFC 7807 application/problem+json body.
+	BlockProblemJSON BlockFormat = "PROBLEM_JSON"
+	// BlockHTML renders BlockResponse.Template, or a minimal page without one.
+	BlockHTML BlockFormat = "HTML"
+)
+
+// BlockResponse shapes what a blocked client gets back. Every block response
+// carries the request ID in the X-Request-ID header, the same ID that is sent
+// to the backend with the request.
+type BlockResponse struct {
+	// StatusCode of blocked requests. Zero, or anything outside 100-599,
+	// means 403.
+	StatusCode int
+	// Format of the body. Empty means BlockText.
+	Format BlockFormat
+	// Template is executed with BlockPage for BlockHTML.
+	Template *template.Template
+	// ProblemType is the type URI of problem+json bodies. Empty means about:blank.
+	ProblemType string
+	// RedirectURL sends blocked clients elsewhere with a 303, the request ID
+	// added as the request_id query parameter. It is an absolute http(s) URL
+	// or a path, ConfigFromEnv rejects anything else.
+	RedirectURL string
+}
+
+// redirect parses RedirectURL.
+func (b BlockResponse) redirect() (*url.URL, error) {
+	target, err := url.Parse(b.RedirectURL)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case target.Scheme == "http" || target.Scheme == "https":
+		if target.Host == "" {
+			return nil, fmt.Errorf("%q has no host", b.RedirectURL)
+		}
+	case target.Scheme == "" && target.Host == "" && strings.HasPrefix(target.Path, "/"):
+	default:
+		return nil, fmt.Errorf("%q is neither an http(s) URL nor a path", b.RedirectURL)
+	}
+	return target, nil
+}
+
+// BlockPage is the data BlockResponse.Template is executed with.
+type BlockPage struct {
+	StatusCode int
+	Message    string
+	RequestID  string
+}
+
+var defaultBlockTemplate = template.Must(template.New("block").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.StatusCode}} Request blocked</title></head>
+<body>
+<h1>Request blocked</h1>
+<p>{{.Message}}</p>
+<p>Request ID: <code>{{.RequestID}}</code></p>
+</body>
+</html>
+`))
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID the middleware gave the request, or ""
+// outside of a protected handler.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func withRequestID(ctx context.Context) context.Context {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return context.WithValue(ctx, requestIDKey{}, hex.EncodeToString(b))
+}
+
+// block answers a blocked request as Config.BlockResponse says.
+func (m *Middleware) block(in inbound, message string) {
+	status := m.Config.BlockResponse.StatusCode
+	if !validStatus(status) {
+		status = http.StatusForbidden
+	}
+	m.writeBlock(in, status, message)
+}
+
+// validStatus reports whether WriteHeader accepts code.
+func validStatus(code int) bool {
+	return code >= 100 && code <= 599
+}
+
+func (m *Middleware) writeBlock(in inbound, status int, message string) {
+	trace.SpanFromContext(in.Context()).SetAttributes(
+		attribute.Bool("argus.blocked", true),
+		attribute.Int("http.response.status_code", status),
+		attribute.String("argus.block.message", message),
+	)
+	verdictOf(in.Context()).Blocked = true
+	fire(m.OnBlock, in)
+
+	br := m.Config.BlockResponse
+	id := RequestIDFromContext(in.Context())
+	header := in.header()
+	header.Set("X-Request-ID", id)
+
+	if br.RedirectURL != "" {
+		target, err := br.redirect()
+		if err == nil {
+			q := target.Query()
+			q.Set("request_id", id)
+			target.RawQuery = q.Encode()
+			header.Set("Location", target.String())
+			in.respond(http.StatusSeeOther, nil)
+			return
+		}
+	}
+
+	var body bytes.Buffer
+	switch br.Format {
+	case BlockProblemJSON:
+		problemType := br.ProblemType
+		if problemType == "" {
+			problemType = "about:blank"
+		}
+		header.Set("Content-Type", "application/problem+json")
+		header.Set("X-Content-Type-Options", "nosniff")
+		json.NewEncoder(&body).Encode(map[string]any{
+			"type":       problemType,
+			"title":      http.StatusText(status),
+			"status":     status,
+			"detail":     message,
+			"instance":   in.path(),
+			"request_id": id,
+		})
+	case BlockHTML:
+		tmpl := br.Template
+		if tmpl == nil {
+			tmpl = defaultBlockTemplate
+		}
+		header.Set("Content-Type", "text/html; charset=utf-8")
+		tmpl.Execute(&body, BlockPage{StatusCode: status, Message: message, RequestID: id})
+	default:
+		respondError(in, status, message+" (request ID: "+id+")")
+		return
+	}
+	in.respond(status, body.Bytes())
+}
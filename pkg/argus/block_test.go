@@ -0,0 +1,135 @@
+package argus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func blockedRecorder(t *testing.T, block BlockResponse) (*httptest.ResponseRecorder, *MockSender) {
+	t.Helper()
+
+	// Paranoid falls back to the WAF verdict when the API fails
+	sender := &MockSender{Err: errors.New("api down")}
+	mw := NewMiddleware(sender, &MockWAF{BlockRequest: true}, Config{Mode: Paranoid, BlockResponse: block})
+	handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should NOT be called")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/login", nil))
+	// Wait for the fallback report before the sender is inspected
+	mw.Close(context.Background())
+	return rec, sender
+}
+
+func TestBlockResponses(t *testing.T) {
+	t.Run("Text by default with request ID", func(t *testing.T) {
+		rec, sender := blockedRecorder(t, BlockResponse{})
+
+		id := rec.Header().Get("X-Request-ID")
+		if rec.Code != http.StatusForbidden || len(id) != 32 {
+			t.Fatalf("Expected 403 with a request ID, got %d %q", rec.Code, id)
+		}
+		if !strings.Contains(rec.Body.String(), "Blocked by Argus Paranoid Shield") || !strings.Contains(rec.Body.String(), id) {
+			t.Errorf("Expected message and request ID in body, got %q", rec.Body.String())
+		}
+		if sender.SentReq.MetaData["request_id"] != id {
+			t.Errorf("Expected request_id %s in payload, got %v", id, sender.SentReq.MetaData)
+		}
+	})
+
+	t.Run("Invalid status falls back to 403", func(t *testing.T) {
+		for _, status := range []int{-1, 1000} {
+			rec, _ := blockedRecorder(t, BlockResponse{StatusCode: status})
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("Expected 403 for status %d, got %d", status, rec.Code)
+			}
+		}
+	})
+
+	t.Run("Problem JSON with custom status", func(t *testing.T) {
+		rec, _ := blockedRecorder(t, BlockResponse{
+			StatusCode:  http.StatusUnavailableForLegalReasons,
+			Format:      BlockProblemJSON,
+			ProblemType: "https://example.com/probs/blocked",
+		})
+
+		if rec.Code != http.StatusUnavailableForLegalReasons {
+			t.Errorf("Expected 451, got %d", rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("Expected problem+json, got %s", ct)
+		}
+
+		var problem map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("Invalid problem body: %v", err)
+		}
+		if problem["type"] != "https://example.com/probs/blocked" || problem["status"] != float64(451) ||
+			problem["instance"] != "/login" || problem["request_id"] != rec.Header().Get("X-Request-ID") {
+			t.Errorf("Unexpected problem body: %v", problem)
+		}
+	})
+
+	t.Run("HTML template", func(t *testing.T) {
+		tmpl := template.Must(template.New("t").Parse(`<p>{{.StatusCode}} {{.RequestID}}</p>`))
+		rec, _ := blockedRecorder(t, BlockResponse{Format: BlockHTML, Template: tmpl})
+
+		want := "<p>403 " + rec.Header().Get("X-Request-ID") + "</p>"
+		if rec.Body.String() != want {
+			t.Errorf("Expected %q, got %q", want, rec.Body.String())
+		}
+	})
+
+	t.Run("Default HTML page", func(t *testing.T) {
+		rec, _ := blockedRecorder(t, BlockResponse{Format: BlockHTML})
+
+		if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") || !strings.Contains(rec.Body.String(), rec.Header().Get("X-Request-ID")) {
+			t.Errorf("Expected an HTML page with the request ID, got %q", rec.Body.String())
+		}
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		rec, _ := blockedRecorder(t, BlockResponse{RedirectURL: "https://example.com/blocked?lang=en"})
+
+		if rec.Code != http.StatusSeeOther {
+			t.Errorf("Expected 303, got %d", rec.Code)
+		}
+		location, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Invalid Location: %v", err)
+		}
+		if location.Query().Get("request_id") != rec.Header().Get("X-Request-ID") || location.Query().Get("lang") != "en" {
+			t.Errorf("Expected request ID added to the redirect, got %s", location)
+		}
+	})
+
+	t.Run("Redirect to a path", func(t *testing.T) {
+		rec, _ := blockedRecorder(t, BlockResponse{RedirectURL: "/blocked"})
+
+		if location := rec.Header().Get("Location"); rec.Code != http.StatusSeeOther || !strings.HasPrefix(location, "/blocked?request_id=") {
+			t.Errorf("Expected a 303 to the path, got %d %q", rec.Code, location)
+		}
+	})
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	mw := NewMiddleware(&MockSender{}, &MockWAF{}, Config{Mode: LatencyFirst})
+
+	var id string
+	handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = RequestIDFromContext(r.Context())
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if len(id) != 32 {
+		t.Errorf("Expected a request ID in the handler context, got %q", id)
+	}
+}
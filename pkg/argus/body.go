@@ -0,0 +1,103 @@
+package argus
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+)
+
+const (
+	// Same as SecRequestBodyLimit and SecRequestBodyInMemoryLimit in coraza.conf.
+	defaultMaxBodySize   = 13107200
+	defaultMaxBodyMemory = 131072
+)
+
+// requestBody is what the middleware read of a request body: up to
+// MaxBodyMemory bytes in memory, the rest of the inspected part in a temp
+// file, and the unread remainder still on the connection.
+type requestBody struct {
+	head     []byte
+	file     *os.File
+	fileSize int64
+	// limit is the inspected size, overflow tells the body goes past it.
+	limit    int64
+	overflow bool
+	rest     io.ReadCloser
+}
+
+// readRequestBody reads at most limit+1 bytes of body, one more than is
+// inspected so an oversized body is noticed without reading it all.
+func readRequestBody(body io.ReadCloser, limit, memory int64) (*requestBody, error) {
+	b := &requestBody{limit: limit, rest: body}
+	src := io.LimitReader(body, limit+1)
+
+	var head bytes.Buffer
+	n, err := io.CopyN(&head, src, memory)
+	b.head = head.Bytes()
+	if errors.Is(err, io.EOF) {
+		b.overflow = n > limit
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b.file, err = os.CreateTemp("", "argus-body-*")
+	if err != nil {
+		return nil, err
+	}
+	b.fileSize, err = io.Copy(b.file, src)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	b.overflow = n+b.fileSize > limit
+	return b, nil
+}
+
+// bufferedBody is a body the server has already read whole, inspected up to
+// limit without being copied.
+func bufferedBody(body []byte, limit int64) *requestBody {
+	return &requestBody{head: body, limit: limit, overflow: int64(len(body)) > limit, rest: http.NoBody}
+}
+
+func (b *requestBody) stored() io.Reader {
+	if b.file == nil {
+		return bytes.NewReader(b.head)
+	}
+	return io.MultiReader(bytes.NewReader(b.head), io.NewSectionReader(b.file, 0, b.fileSize))
+}
+
+// Inspected returns a new reader over the part of the body the WAF sees.
+func (b *requestBody) Inspected() io.ReadCloser {
+	return io.NopCloser(io.LimitReader(b.stored(), b.limit))
+}
+
+// Payload is the part of the body sent for analysis, which is never more
+// than what is held in memory.
+func (b *requestBody) Payload() []byte {
+	if int64(len(b.head)) > b.limit {
+		return b.head[:b.limit]
+	}
+	return b.head
+}
+
+// Replay returns the whole body for the upstream: what was read followed by
+// what is still on the connection.
+func (b *requestBody) Replay() io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(b.stored(), b.rest), b.rest}
+}
+
+// Close removes the temp file, if the body needed one.
+func (b *requestBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
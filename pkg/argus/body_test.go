@@ -0,0 +1,163 @@
+package argus
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordingWAF keeps the body it was given.
+type recordingWAF struct {
+	Body        string
+	URI         string
+	ContentType string
+}
+
+func (m *recordingWAF) Check(r *http.Request) (WAFResult, error) {
+	data, err := io.ReadAll(r.Body)
+	m.Body, m.URI, m.ContentType = string(data), r.URL.RequestURI(), r.Header.Get("Content-Type")
+	return WAFResult{}, err
+}
+
+func TestReadRequestBody(t *testing.T) {
+	t.Run("Small body stays in memory", func(t *testing.T) {
+		body, err := readRequestBody(io.NopCloser(strings.NewReader("hello")), 100, 10)
+		if err != nil {
+			t.Fatalf("readRequestBody failed: %v", err)
+		}
+		defer body.Close()
+
+		if body.file != nil || body.overflow {
+			t.Errorf("Expected in-memory body without overflow, got file=%v overflow=%v", body.file, body.overflow)
+		}
+		if got := string(body.Payload()); got != "hello" {
+			t.Errorf("Expected payload 'hello', got %q", got)
+		}
+	})
+
+	t.Run("Large body spills to disk and replays in full", func(t *testing.T) {
+		t.Setenv("TMPDIR", t.TempDir())
+		full := strings.Repeat("a", 50) + strings.Repeat("b", 50)
+
+		body, err := readRequestBody(io.NopCloser(strings.NewReader(full)), 60, 10)
+		if err != nil {
+			t.Fatalf("readRequestBody failed: %v", err)
+		}
+		if body.file == nil {
+			t.Fatal("Expected the body to spill to a temp file")
+		}
+		if !body.overflow {
+			t.Error("Expected overflow for a body over the limit")
+		}
+		if got := string(body.Payload()); got != full[:10] {
+			t.Errorf("Expected only the in-memory part as payload, got %q", got)
+		}
+
+		inspected, _ := io.ReadAll(body.Inspected())
+		if string(inspected) != full[:60] {
+			t.Errorf("Expected the first 60 bytes inspected, got %q", inspected)
+		}
+		replayed, _ := io.ReadAll(body.Replay())
+		if string(replayed) != full {
+			t.Errorf("Expected the full body replayed, got %d bytes", len(replayed))
+		}
+
+		name := body.file.Name()
+		body.Close()
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("Expected temp file %s removed, got %v", filepath.Base(name), err)
+		}
+	})
+}
+
+func TestBodyLimits(t *testing.T) {
+	full := strings.Repeat("x", 64)
+
+	tests := []struct {
+		name          string
+		action        BodyLimitAction
+		body          string
+		wantCode      int
+		wantWAFBody   string
+		wantUpstream  bool
+		unknownLength bool
+	}{
+		{"Inspect prefix", BodyLimitInspectPrefix, full, http.StatusOK, full[:16], true, false},
+		{"Default action inspects prefix", "", full, http.StatusOK, full[:16], true, false},
+		{"Pass through skips body inspection", BodyLimitPassThrough, full, http.StatusOK, "", true, false},
+		{"Reject by Content-Length", BodyLimitReject, full, http.StatusRequestEntityTooLarge, "", false, false},
+		{"Reject while reading", BodyLimitReject, full, http.StatusRequestEntityTooLarge, "", false, true},
+		{"Body under limit", BodyLimitReject, "small", http.StatusOK, "small", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waf := &recordingWAF{}
+			config := Config{Mode: LatencyFirst, MaxBodySize: 16, MaxBodyMemory: 8, BodyLimitAction: tt.action}
+			mw := NewMiddleware(&MockSender{}, waf, config)
+
+			var upstream string
+			handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				upstream = string(data)
+			}))
+
+			req := httptest.NewRequest("POST", "/upload", strings.NewReader(tt.body))
+			if tt.unknownLength {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("Expected %d, got %d", tt.wantCode, rec.Code)
+			}
+			if waf.Body != tt.wantWAFBody {
+				t.Errorf("Expected WAF to see %q, got %q", tt.wantWAFBody, waf.Body)
+			}
+			if tt.wantUpstream && upstream != tt.body {
+				t.Errorf("Expected upstream to get the full body, got %q", upstream)
+			}
+			if !tt.wantUpstream && upstream != "" {
+				t.Errorf("Expected no upstream call, got body %q", upstream)
+			}
+		})
+	}
+}
+
+func TestBodyLimitsWithCoraza(t *testing.T) {
+	waf, err := NewWAF(Config{})
+	if err != nil {
+		t.Fatalf("Failed to create WAF: %v", err)
+	}
+
+	sender := &MockSender{}
+	mw := NewMiddleware(sender, waf, Config{Mode: Paranoid, MaxBodySize: 32})
+
+	var upstream string
+	handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		upstream = string(data)
+	}))
+
+	// The attack sits past the inspected prefix
+	body := "q=" + strings.Repeat("a", 40) + "&x=<script>alert(1)</script>"
+	req := httptest.NewRequest("POST", "/search", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected the attack past the prefix to go uninspected, got %d", rec.Code)
+	}
+	if upstream != body {
+		t.Errorf("Expected upstream to get the full body, got %q", upstream)
+	}
+	if sender.SentReq.MetaData["waf_result"] != "PASS" {
+		t.Errorf("Expected WAF to pass the prefix, got %q", sender.SentReq.MetaData["waf_result"])
+	}
+}
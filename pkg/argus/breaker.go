@@ -1,25 +1,100 @@
 package argus
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/sony/gobreaker/v2"
 )
 
+// BreakerState is the state of a Breaker.
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "CLOSED"
+	BreakerHalfOpen BreakerState = "HALF_OPEN"
+	BreakerOpen     BreakerState = "OPEN"
+)
+
+const (
+	defaultBreakerMaxRequests = 1
+	defaultBreakerInterval    = 60 * time.Second
+	defaultBreakerTimeout     = 30 * time.Second
+	defaultBreakerMaxFailures = 3
+)
+
+// BreakerConfig tunes a Breaker. Zero values take the defaults.
+type BreakerConfig struct {
+	// MaxRequests is how many trial requests pass while half open. Default 1.
+	MaxRequests int
+	// Interval clears the failure counts of a closed breaker. Default 60s.
+	Interval time.Duration
+	// Timeout is how long the breaker stays open before going half open.
+	// Default 30s.
+	Timeout time.Duration
+	// MaxConsecutiveFailures trips the breaker once more calls than this
+	// fail in a row. Default 3.
+	MaxConsecutiveFailures int
+	// OnStateChange is called with the breaker name on every transition.
+	OnStateChange func(name string, from, to BreakerState)
+}
+
+// withDefaults fills the zero fields of c from fallback.
+func (c BreakerConfig) withDefaults(fallback BreakerConfig) BreakerConfig {
+	if c.MaxRequests == 0 {
+		c.MaxRequests = fallback.MaxRequests
+	}
+	if c.Interval == 0 {
+		c.Interval = fallback.Interval
+	}
+	if c.Timeout == 0 {
+		c.Timeout = fallback.Timeout
+	}
+	if c.MaxConsecutiveFailures == 0 {
+		c.MaxConsecutiveFailures = fallback.MaxConsecutiveFailures
+	}
+	if c.OnStateChange == nil {
+		c.OnStateChange = fallback.OnStateChange
+	}
+	return c
+}
+
 type Breaker struct {
 	cb *gobreaker.CircuitBreaker[any]
 }
 
 func NewBreaker(name string) *Breaker {
+	return NewBreakerWithConfig(name, BreakerConfig{})
+}
+
+func NewBreakerWithConfig(name string, config BreakerConfig) *Breaker {
+	config = config.withDefaults(BreakerConfig{
+		MaxRequests:            defaultBreakerMaxRequests,
+		Interval:               defaultBreakerInterval,
+		Timeout:                defaultBreakerTimeout,
+		MaxConsecutiveFailures: defaultBreakerMaxFailures,
+	})
+
 	settings := gobreaker.Settings{
 		Name:        name,
-		MaxRequests: 1,                // Allowing 1 request to pass in Half Open state
-		Interval:    60 * time.Second, // Clear failure counts every 60s if it is not tripped again
-		Timeout:     30 * time.Second, // Half Open for 30s before retrying again
+		MaxRequests: uint32(config.MaxRequests), // Requests allowed to pass in Half Open state
+		Interval:    config.Interval,            // Clear failure counts if it is not tripped again
+		Timeout:     config.Timeout,             // Half Open after this long before retrying again
 
 		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			return counts.ConsecutiveFailures > 3
+			return counts.ConsecutiveFailures > uint32(config.MaxConsecutiveFailures)
 		},
+		// A client that disconnects says nothing about the API's health, nor
+		// does a request running out of the latency budget of its mode
+		IsSuccessful: func(err error) bool {
+			return err == nil || errors.Is(err, context.Canceled) || errors.Is(err, errLatencyBudget)
+		},
+	}
+	if config.OnStateChange != nil {
+		settings.OnStateChange = func(name string, from, to gobreaker.State) {
+			config.OnStateChange(name, breakerState(from), breakerState(to))
+		}
 	}
 
 	return &Breaker{
@@ -30,3 +105,24 @@ func NewBreaker(name string) *Breaker {
 func (b *Breaker) Execute(req func() (any, error)) (any, error) {
 	return b.cb.Execute(req)
 }
+
+// Name returns the name the breaker was created with.
+func (b *Breaker) Name() string {
+	return b.cb.Name()
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() BreakerState {
+	return breakerState(b.cb.State())
+}
+
+func breakerState(s gobreaker.State) BreakerState {
+	switch s {
+	case gobreaker.StateOpen:
+		return BreakerOpen
+	case gobreaker.StateHalfOpen:
+		return BreakerHalfOpen
+	default:
+		return BreakerClosed
+	}
+}
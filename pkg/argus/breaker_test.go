@@ -2,7 +2,11 @@ package argus
 
 import (
 	"errors"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
 )
 
 func TestBreaker_FailsOpen(t *testing.T) {
@@ -50,3 +54,64 @@ func TestBreaker_SuccessReset(t *testing.T) {
 		t.Errorf("Expected 'success', got %v", res)
 	}
 }
+
+func TestBreakerConfig(t *testing.T) {
+	var transitions []string
+	breaker := NewBreakerWithConfig("custom-breaker", BreakerConfig{
+		MaxConsecutiveFailures: 1,
+		Timeout:                20 * time.Millisecond,
+		OnStateChange: func(name string, from, to BreakerState) {
+			transitions = append(transitions, name+":"+string(from)+"->"+string(to))
+		},
+	})
+
+	failFunc := func() (any, error) {
+		return nil, errors.New("ai service unavailable")
+	}
+
+	breaker.Execute(failFunc)
+	if state := breaker.State(); state != BreakerClosed {
+		t.Fatalf("Expected closed after 1 failure, got %s", state)
+	}
+	breaker.Execute(failFunc)
+	if state := breaker.State(); state != BreakerOpen {
+		t.Fatalf("Expected open after 2 failures, got %s", state)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if state := breaker.State(); state != BreakerHalfOpen {
+		t.Fatalf("Expected half open after the timeout, got %s", state)
+	}
+	breaker.Execute(func() (any, error) { return nil, nil })
+
+	want := []string{
+		"custom-breaker:CLOSED->OPEN",
+		"custom-breaker:OPEN->HALF_OPEN",
+		"custom-breaker:HALF_OPEN->CLOSED",
+	}
+	if strings.Join(transitions, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected transitions %v, got %v", want, transitions)
+	}
+}
+
+func TestSeparateLogBreaker(t *testing.T) {
+	sender := &MockSender{Err: errors.New("api down")}
+	mw := NewMiddleware(sender, &MockWAF{}, Config{
+		Mode:       Paranoid,
+		LogBreaker: BreakerConfig{MaxConsecutiveFailures: 1},
+	})
+
+	for range 5 {
+		mw.sendBatch([]protocol.AnalysisRequest{{Log: "event"}})
+	}
+
+	if state := mw.LogBreaker.State(); state != BreakerOpen {
+		t.Errorf("Expected failing logs to open the log breaker, got %s", state)
+	}
+	if state := mw.Breaker.State(); state != BreakerClosed {
+		t.Errorf("Expected the sync breaker to stay closed, got %s", state)
+	}
+	if mw.Breaker.Name() == mw.LogBreaker.Name() {
+		t.Errorf("Expected distinct breaker names, got %s", mw.Breaker.Name())
+	}
+}
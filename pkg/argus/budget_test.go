@@ -0,0 +1,136 @@
+package argus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+// slowSender answers "threat" after Delay, or gives up when ctx ends.
+type slowSender struct {
+	Delay    time.Duration
+	Canceled chan struct{}
+}
+
+func (s *slowSender) SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	return s.SendAnalysisContext(context.Background(), req)
+}
+
+func (s *slowSender) SendAnalysisContext(ctx context.Context, req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	select {
+	case <-time.After(s.Delay):
+		isThreat := true
+		return protocol.AnalysisResponse{IsThreat: &isThreat}, nil
+	case <-ctx.Done():
+		if s.Canceled != nil {
+			close(s.Canceled)
+		}
+		return protocol.AnalysisResponse{}, ctx.Err()
+	}
+}
+
+func TestLatencyBudgets(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		wafBlock bool
+		wantCode int
+	}{
+		{"SmartShield falls back to the WAF block", Config{Mode: SmartShield, LatencyBudgets: map[SecurityMode]time.Duration{SmartShield: 20 * time.Millisecond}}, true, http.StatusForbidden},
+		{"Paranoid falls back to the WAF pass", Config{Mode: Paranoid, LatencyBudgets: map[SecurityMode]time.Duration{Paranoid: 20 * time.Millisecond}}, false, http.StatusOK},
+		{"Fail open wins over the budget", Config{Mode: Paranoid, FailurePolicy: FailOpen, LatencyBudgets: map[SecurityMode]time.Duration{Paranoid: 20 * time.Millisecond}}, true, http.StatusOK},
+		{"Fail closed wins over the budget", Config{Mode: Paranoid, FailurePolicies: map[SecurityMode]FailurePolicy{Paranoid: FailClosed}, LatencyBudgets: map[SecurityMode]time.Duration{Paranoid: 20 * time.Millisecond}}, false, http.StatusForbidden},
+		{"Degraded wins over the budget", Config{Mode: Paranoid, FailurePolicy: FailDegraded, LatencyBudgets: map[SecurityMode]time.Duration{Paranoid: 20 * time.Millisecond}}, false, http.StatusServiceUnavailable},
+		{"Route failure policy wins over the budget", Config{Mode: LatencyFirst, Policies: []RoutePolicy{{Path: "/api", Mode: Paranoid, FailurePolicy: FailClosed, LatencyBudget: 20 * time.Millisecond}}}, false, http.StatusForbidden},
+		{"Empty mode takes the SmartShield budget", Config{LatencyBudgets: map[SecurityMode]time.Duration{SmartShield: 20 * time.Millisecond}}, true, http.StatusForbidden},
+		{"Route policy sets the budget of the empty mode", Config{Policies: []RoutePolicy{{Path: "/api", LatencyBudget: 20 * time.Millisecond}}}, true, http.StatusForbidden},
+		{"Route policy sets the budget", Config{Mode: LatencyFirst, Policies: []RoutePolicy{{Path: "/api", Mode: Paranoid, LatencyBudget: 20 * time.Millisecond}}}, false, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw := NewMiddleware(&slowSender{Delay: time.Second}, &MockWAF{BlockRequest: tt.wafBlock}, tt.config)
+			handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			start := time.Now()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api", nil))
+
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("Expected the budget to cut the analysis short, took %s", elapsed)
+			}
+			if rec.Code != tt.wantCode {
+				t.Errorf("Expected %d, got %d", tt.wantCode, rec.Code)
+			}
+		})
+	}
+
+	t.Run("Plain senders are abandoned at the budget", func(t *testing.T) {
+		mw := NewMiddleware(&plainSlowSender{Delay: time.Second}, &MockWAF{BlockRequest: true},
+			Config{Mode: SmartShield, LatencyBudgets: map[SecurityMode]time.Duration{SmartShield: 20 * time.Millisecond}})
+
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest("POST", "/api", nil))
+
+		if rec.Code != http.StatusForbidden || time.Since(start) > 500*time.Millisecond {
+			t.Errorf("Expected WAF verdict right after the budget, got %d after %s", rec.Code, time.Since(start))
+		}
+	})
+}
+
+// plainSlowSender has no context support and answers "safe" after Delay.
+type plainSlowSender struct {
+	Delay time.Duration
+}
+
+func (s *plainSlowSender) SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	time.Sleep(s.Delay)
+	isThreat := false
+	return protocol.AnalysisResponse{IsThreat: &isThreat}, nil
+}
+
+func TestClientDisconnect(t *testing.T) {
+	sender := &slowSender{Delay: time.Second, Canceled: make(chan struct{})}
+	mw := NewMiddleware(sender, &MockWAF{}, Config{Mode: Paranoid})
+	handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handler should NOT be called for a gone client")
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/api", nil).WithContext(ctx)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case <-sender.Canceled:
+	default:
+		t.Error("Expected the analysis to be canceled with the request")
+	}
+	if state := mw.Breaker.State(); state != BreakerClosed {
+		t.Errorf("Expected a disconnect not to count against the breaker, got %s", state)
+	}
+}
+
+func TestLatencyBudgetBreaker(t *testing.T) {
+	mw := NewMiddleware(&slowSender{Delay: time.Second}, &MockWAF{BlockRequest: true},
+		Config{Mode: SmartShield, LatencyBudgets: map[SecurityMode]time.Duration{SmartShield: 10 * time.Millisecond}})
+	mw.Breaker = NewBreakerWithConfig("budget", BreakerConfig{MaxConsecutiveFailures: 1})
+	handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for range 3 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api", nil))
+	}
+
+	if state := mw.Breaker.State(); state != BreakerClosed {
+		t.Errorf("Expected budget timeouts not to count against the breaker, got %s", state)
+	}
+}
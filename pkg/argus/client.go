@@ -2,26 +2,47 @@ package argus
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/priyansh-dimri/argus/pkg/protocol"
+	"github.com/priyansh-dimri/argus/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type AnalysisSender interface {
 	SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisResponse, error)
 }
 
+// ContextSender is an AnalysisSender whose calls end with ctx. The middleware
+// uses it so a sync analysis stops when the client disconnects or the mode's
+// latency budget runs out. Other senders are abandoned instead of canceled.
+type ContextSender interface {
+	AnalysisSender
+	SendAnalysisContext(ctx context.Context, req protocol.AnalysisRequest) (protocol.AnalysisResponse, error)
+}
+
 type Client struct {
 	baseURL    string
 	apiKey     string
 	httpClient *http.Client
 	marshal    func(v any) ([]byte, error)
+	// unbatched is set once the backend answered /analyze/batch with a 404
+	unbatched atomic.Bool
 }
 
-var _ AnalysisSender = (*Client)(nil) // compile time check
+// compile time checks
+var (
+	_ BatchSender   = (*Client)(nil)
+	_ ContextSender = (*Client)(nil)
+)
 
 func NewClient(baseURL, apiKey string, timeout time.Duration) *Client {
 	return &Client{
@@ -35,24 +56,35 @@ func NewClient(baseURL, apiKey string, timeout time.Duration) *Client {
 }
 
 func (c *Client) SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	return c.SendAnalysisContext(context.Background(), req)
+}
+
+// SendAnalysisContext is SendAnalysis bounded by ctx as well as by the client
+// timeout.
+func (c *Client) SendAnalysisContext(ctx context.Context, req protocol.AnalysisRequest) (_ protocol.AnalysisResponse, err error) {
+	ctx, span := tracer().Start(ctx, "argus.client.SendAnalysis", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { tracing.End(span, err) }()
+
 	bodyBytes, err := c.marshal(req)
 	if err != nil {
 		return protocol.AnalysisResponse{}, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	apiURL := fmt.Sprintf("%s/analyze", c.baseURL)
-	httpReq, err := http.NewRequest(http.MethodPost, apiURL, bytes.NewBuffer(bodyBytes))
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, bytes.NewBuffer(bodyBytes))
 	if err != nil {
 		return protocol.AnalysisResponse{}, fmt.Errorf("failed to create http request: %w", err)
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Content-Type", protocol.ContentTypeV2)
 	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	tracing.Propagator.Inject(ctx, propagation.HeaderCarrier(httpReq.Header))
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
 		return protocol.AnalysisResponse{}, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	if resp.StatusCode != http.StatusOK {
 		return protocol.AnalysisResponse{}, fmt.Errorf("api returned status: %d", resp.StatusCode)
@@ -62,6 +94,62 @@ func (c *Client) SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisRe
 	if err := json.NewDecoder(resp.Body).Decode(&analysisResp); err != nil {
 		return protocol.AnalysisResponse{}, fmt.Errorf("failed to decode response: %w", err)
 	}
+	if analysisResp.IsThreat != nil {
+		span.SetAttributes(attribute.Bool("argus.ai.is_threat", *analysisResp.IsThreat))
+	}
 
 	return analysisResp, nil
 }
+
+// SendAnalysisBatch posts async events to /analyze/batch. The backend only
+// acknowledges them, verdicts are not returned. A backend without the batch
+// endpoint answers 404, from then on the events go one by one to /analyze.
+func (c *Client) SendAnalysisBatch(reqs []protocol.AnalysisRequest) (err error) {
+	ctx, span := tracer().Start(context.Background(), "argus.client.SendAnalysisBatch",
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.Int("argus.batch.size", len(reqs))))
+	defer func() { tracing.End(span, err) }()
+
+	if c.unbatched.Load() {
+		return c.sendEach(ctx, reqs)
+	}
+
+	bodyBytes, err := c.marshal(reqs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch: %w", err)
+	}
+
+	apiURL := fmt.Sprintf("%s/analyze/batch", c.baseURL)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create http request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", protocol.ContentTypeV2)
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	tracing.Propagator.Inject(ctx, propagation.HeaderCarrier(httpReq.Header))
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		c.unbatched.Store(true)
+		return c.sendEach(ctx, reqs)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("api returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sendEach posts the events of a batch one by one to /analyze.
+func (c *Client) sendEach(ctx context.Context, reqs []protocol.AnalysisRequest) error {
+	var errs []error
+	for _, req := range reqs {
+		if _, err := c.SendAnalysisContext(ctx, req); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
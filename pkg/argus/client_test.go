@@ -1,11 +1,13 @@
 package argus
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,6 +28,9 @@ func TestClient_SendAnalysis(t *testing.T) {
 			if r.URL.Path != "/analyze" {
 				t.Errorf("Expected /analyze URL path, got %s", r.URL.Path)
 			}
+			if ct := r.Header.Get("Content-Type"); ct != protocol.ContentTypeV2 {
+				t.Errorf("Expected content type %s, got %s", protocol.ContentTypeV2, ct)
+			}
 
 			var received protocol.AnalysisRequest
 			if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
@@ -107,6 +112,30 @@ func TestClient_SendAnalysis(t *testing.T) {
 		}
 	})
 
+	t.Run("stop when the context ends", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer server.Close()
+		defer close(release)
+
+		client := NewClient(server.URL, "fake-api-key", 2*time.Second)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := client.SendAnalysisContext(ctx, reqPayload)
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected deadline exceeded, got %v", err)
+		}
+		if time.Since(start) > time.Second {
+			t.Error("Expected the context to end the call before the client timeout")
+		}
+	})
+
 	t.Run("detect json marshalling error", func(t *testing.T) {
 		client := NewClient("http://localhost", "key", time.Second)
 
@@ -159,3 +188,76 @@ func TestClient_SendAnalysis(t *testing.T) {
 		}
 	})
 }
+
+func TestClient_SendAnalysisBatch(t *testing.T) {
+	batch := []protocol.AnalysisRequest{{Log: "first"}, {Log: "second"}}
+
+	t.Run("send batch successfully", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/analyze/batch" {
+				t.Errorf("Expected /analyze/batch URL path, got %s", r.URL.Path)
+			}
+			if r.Header.Get("Authorization") != "Bearer fake-api-key" {
+				t.Errorf("Expected API key, got %q", r.Header.Get("Authorization"))
+			}
+
+			var received []protocol.AnalysisRequest
+			if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+				t.Fatalf("Failed to decode batch on server: %v", err)
+			}
+			if len(received) != 2 || received[1].Log != "second" {
+				t.Errorf("Expected the 2 events, got %+v", received)
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, "fake-api-key", 2*time.Second)
+
+		if err := client.SendAnalysisBatch(batch); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	})
+
+	t.Run("fall back to /analyze without the batch endpoint", func(t *testing.T) {
+		var batchCalls, eventCalls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/analyze/batch":
+				batchCalls.Add(1)
+				http.Error(w, "not found", http.StatusNotFound)
+			case "/analyze":
+				eventCalls.Add(1)
+				json.NewEncoder(w).Encode(protocol.AnalysisResponse{})
+			}
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, "fake-api-key", 2*time.Second)
+
+		for range 2 {
+			if err := client.SendAnalysisBatch(batch); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		if batchCalls.Load() != 1 {
+			t.Errorf("Expected the batch endpoint to be tried once, got %d calls", batchCalls.Load())
+		}
+		if eventCalls.Load() != 4 {
+			t.Errorf("Expected 4 events sent to /analyze, got %d", eventCalls.Load())
+		}
+	})
+
+	t.Run("detect server error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "not found", http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, "fake-api-key", 2*time.Second)
+
+		if err := client.SendAnalysisBatch(batch); err == nil {
+			t.Fatal("Expected error for 404 status, got nil")
+		}
+	})
+}
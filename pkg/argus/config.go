@@ -1,13 +1,513 @@
 package argus
 
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type SecurityMode string
 
 const (
 	LatencyFirst SecurityMode = "LATENCY_FIRST"
 	SmartShield  SecurityMode = "SMART_SHIELD"
 	Paranoid     SecurityMode = "PARANOID"
+	// Shadow runs the pipeline of Config.ShadowMode but never blocks. What
+	// that mode would have done is reported in the would_block metadata.
+	Shadow SecurityMode = "SHADOW"
+)
+
+// EngineMode is the Coraza SecRuleEngine setting.
+type EngineMode string
+
+const (
+	EngineOn            EngineMode = "On"
+	EngineDetectionOnly EngineMode = "DetectionOnly"
+)
+
+// BodyLimitAction is what the middleware does with a request body larger
+// than MaxBodySize.
+type BodyLimitAction string
+
+const (
+	// BodyLimitInspectPrefix inspects the first MaxBodySize bytes and forwards
+	// the whole body.
+	BodyLimitInspectPrefix BodyLimitAction = "INSPECT_PREFIX"
+	// BodyLimitReject answers 413 Request Entity Too Large.
+	BodyLimitReject BodyLimitAction = "REJECT"
+	// BodyLimitPassThrough forwards the body without inspecting any of it.
+	BodyLimitPassThrough BodyLimitAction = "PASS_THROUGH"
+)
+
+// FailurePolicy is what the middleware does when the WAF or the analysis
+// API fails. Empty keeps the default of the mode: LatencyFirst and
+// SmartShield fail open, Paranoid falls back to the WAF verdict.
+type FailurePolicy string
+
+const (
+	// FailOpen serves the request.
+	FailOpen FailurePolicy = "FAIL_OPEN"
+	// FailClosed blocks the request.
+	FailClosed FailurePolicy = "FAIL_CLOSED"
+	// FailWAFVerdict decides by the WAF verdict alone. When the WAF itself
+	// failed there is no verdict, and the request goes on to the analysis.
+	FailWAFVerdict FailurePolicy = "WAF_VERDICT"
+	// FailDegraded answers 503 Service Unavailable with a Retry-After header.
+	FailDegraded FailurePolicy = "DEGRADED"
 )
 
 type Config struct {
 	Mode SecurityMode
+	// ShadowMode is the mode Shadow measures. Empty means SmartShield.
+	ShadowMode SecurityMode
+
+	// FailurePolicy applies to every mode without one in FailurePolicies.
+	// Shadow uses the policy of ShadowMode, a Shadow key is never read.
+	FailurePolicy   FailurePolicy
+	FailurePolicies map[SecurityMode]FailurePolicy
+	// RetryAfter is the Retry-After of FailDegraded responses. Zero means 30s.
+	RetryAfter time.Duration
+
+	// LatencyBudgets caps how long each mode waits for a sync analysis, e.g.
+	// {SmartShield: 300 * time.Millisecond}. Past it the WAF verdict decides,
+	// unless FailurePolicies or FailurePolicy set a policy for the mode.
+	// Modes without a budget wait as long as the client timeout allows.
+	// Shadow never waits for one.
+	LatencyBudgets map[SecurityMode]time.Duration
+
+	// Breaker tunes the breaker of sync analyses. LogBreaker tunes the one
+	// of async events, its zero fields taking the values of Breaker. Both
+	// are read once, by NewMiddleware.
+	Breaker    BreakerConfig
+	LogBreaker BreakerConfig
+
+	// Metrics, when set, counts what the middleware does. It is read once,
+	// by NewMiddleware.
+	Metrics *Metrics
+
+	// BlockResponse shapes the responses of blocked requests.
+	BlockResponse BlockResponse
+
+	// LogQueue bounds the async events waiting to be sent. It is read once,
+	// by NewMiddleware.
+	LogQueue LogQueueConfig
+
+	// Policies override these settings per route, matched by PolicyMatch.
+	// Empty PolicyMatch means PolicyFirstMatch.
+	Policies    []RoutePolicy
+	PolicyMatch PolicyMatch
+
+	// InspectResponses buffers responses and runs the CRS data leakage rules on
+	// them. It needs a WAF that implements ResponseRuleEngine.
+	InspectResponses bool
+	// MaxResponseInspectSize is how many response body bytes are buffered for
+	// inspection. The rest streams through uninspected. Zero means 512KB.
+	MaxResponseInspectSize int
+	// BlockResponseLeaks replaces a response the WAF blocks with a generic
+	// error. Otherwise the leak is only reported.
+	BlockResponseLeaks bool
+
+	// WebSocket inspects the messages of upgraded connections.
+	WebSocket WebSocketConfig
+
+	// GraphQL parses the operations sent to GraphQL endpoints.
+	GraphQL GraphQLConfig
+
+	// Redaction masks secrets and personal data before payloads are sent.
+	Redaction RedactionConfig
+
+	// MaxBodySize is how many request body bytes the WAF inspects. Zero means
+	// 12.5MB, the SecRequestBodyLimit of the embedded coraza.conf.
+	MaxBodySize int64
+	// BodyLimitAction applies to bodies over MaxBodySize. Empty means
+	// BodyLimitInspectPrefix.
+	BodyLimitAction BodyLimitAction
+	// MaxBodyMemory is how much of a request body is held in memory. The rest
+	// of the inspected part spills to a temp file and only the in-memory part
+	// is sent for analysis. Zero means 128KB.
+	MaxBodyMemory int64
+	// MaxDecompressionRatio rejects a gzip, deflate or br encoded body that
+	// decodes to more than this many times its size once it outgrows
+	// MaxBodyMemory. Zero means 100.
+	MaxDecompressionRatio int
+
+	// RuleFamilies selects the CRS families NewWAF loads. Empty loads all of them.
+	RuleFamilies []RuleFamily
+
+	// ParanoiaLevel is the CRS blocking paranoia level from 1 to 4. Zero means 1.
+	ParanoiaLevel int
+
+	// Anomaly scores at which the CRS blocks. Zero keeps the CRS defaults of
+	// 5 inbound and 4 outbound.
+	InboundAnomalyThreshold  int
+	OutboundAnomalyThreshold int
+
+	// EngineMode DetectionOnly makes the WAF score and report matches without
+	// ever blocking. Empty means On.
+	EngineMode EngineMode
+
+	// Extra SecLang directives loaded after the embedded CRS, where
+	// SecRuleRemoveById and SecRuleUpdateTargetById take effect. RuleFiles are
+	// read from RulesFS when it is set, from disk otherwise.
+	RuleFiles      []string
+	RulesFS        fs.FS
+	RuleDirectives []string
+
+	// RuleExclusions turn off rules or variables on specific routes.
+	RuleExclusions []RuleExclusion
+}
+
+// mode is Mode, SmartShield when it is empty.
+func (c Config) mode() SecurityMode {
+	if c.Mode == "" {
+		return SmartShield
+	}
+	return c.Mode
+}
+
+// decidingMode is the mode whose pipeline decides requests: ShadowMode under
+// Shadow, SmartShield when that is empty, Mode otherwise. Failure policies
+// and latency budgets are looked up by it.
+func (c Config) decidingMode() SecurityMode {
+	if c.mode() != Shadow {
+		return c.mode()
+	}
+	switch c.ShadowMode {
+	case LatencyFirst, Paranoid:
+		return c.ShadowMode
+	}
+	return SmartShield
+}
+
+// ConfigFromEnv overrides the settings of config with the ones set in the
+// environment: ARGUS_RULE_FAMILIES, ARGUS_PARANOIA_LEVEL,
+// ARGUS_INBOUND_ANOMALY_THRESHOLD, ARGUS_OUTBOUND_ANOMALY_THRESHOLD,
+// ARGUS_ENGINE_MODE, ARGUS_RULE_FILES (comma separated paths),
+// ARGUS_INSPECT_RESPONSES, ARGUS_MAX_RESPONSE_INSPECT_SIZE,
+// ARGUS_BLOCK_RESPONSE_LEAKS, ARGUS_WEBSOCKET_INSPECT,
+// ARGUS_WEBSOCKET_MAX_CLIENT_MESSAGE_SIZE,
+// ARGUS_WEBSOCKET_MAX_SERVER_MESSAGE_SIZE, ARGUS_GRAPHQL_PATHS (comma
+// separated), ARGUS_GRAPHQL_MAX_DEPTH, ARGUS_GRAPHQL_MAX_ALIASES,
+// ARGUS_GRAPHQL_MAX_COMPLEXITY, ARGUS_GRAPHQL_BLOCK_INTROSPECTION,
+// ARGUS_REDACT_HEADERS, ARGUS_REDACT_JSON_PATHS, ARGUS_REDACT_DETECTORS
+// (comma separated card, email and token), ARGUS_REDACT_KEEP_SHAPE,
+// ARGUS_MAX_BODY_SIZE, ARGUS_MAX_BODY_MEMORY,
+// ARGUS_BODY_LIMIT_ACTION, ARGUS_MAX_DECOMPRESSION_RATIO, ARGUS_SHADOW_MODE,
+// ARGUS_FAILURE_POLICY, ARGUS_FAILURE_POLICIES (e.g.
+// SMART_SHIELD=WAF_VERDICT,PARANOID=DEGRADED), ARGUS_RETRY_AFTER,
+// ARGUS_BREAKER_MAX_REQUESTS, ARGUS_BREAKER_MAX_FAILURES,
+// ARGUS_BREAKER_INTERVAL, ARGUS_BREAKER_TIMEOUT,
+// ARGUS_LOG_BREAKER_MAX_FAILURES, ARGUS_LOG_BREAKER_TIMEOUT,
+// ARGUS_BLOCK_STATUS, ARGUS_BLOCK_FORMAT,
+// ARGUS_BLOCK_TEMPLATE (path to an html/template file),
+// ARGUS_BLOCK_REDIRECT_URL, ARGUS_LOG_QUEUE_SIZE, ARGUS_LOG_WORKERS,
+// ARGUS_LOG_BATCH_SIZE, ARGUS_LOG_DROP_POLICY and ARGUS_LATENCY_BUDGETS
+// (e.g. SMART_SHIELD=300ms,PARANOID=2s).
+func ConfigFromEnv(config Config) (Config, error) {
+	if v, ok := os.LookupEnv("ARGUS_RULE_FAMILIES"); ok {
+		families, err := ParseRuleFamilies(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid ARGUS_RULE_FAMILIES: %w", err)
+		}
+		config.RuleFamilies = families
+	}
+
+	ints := []struct {
+		key string
+		dst *int
+	}{
+		{"ARGUS_PARANOIA_LEVEL", &config.ParanoiaLevel},
+		{"ARGUS_INBOUND_ANOMALY_THRESHOLD", &config.InboundAnomalyThreshold},
+		{"ARGUS_OUTBOUND_ANOMALY_THRESHOLD", &config.OutboundAnomalyThreshold},
+		{"ARGUS_MAX_RESPONSE_INSPECT_SIZE", &config.MaxResponseInspectSize},
+		{"ARGUS_MAX_DECOMPRESSION_RATIO", &config.MaxDecompressionRatio},
+		{"ARGUS_BLOCK_STATUS", &config.BlockResponse.StatusCode},
+		{"ARGUS_LOG_QUEUE_SIZE", &config.LogQueue.Size},
+		{"ARGUS_LOG_WORKERS", &config.LogQueue.Workers},
+		{"ARGUS_LOG_BATCH_SIZE", &config.LogQueue.BatchSize},
+		{"ARGUS_BREAKER_MAX_REQUESTS", &config.Breaker.MaxRequests},
+		{"ARGUS_BREAKER_MAX_FAILURES", &config.Breaker.MaxConsecutiveFailures},
+		{"ARGUS_LOG_BREAKER_MAX_FAILURES", &config.LogBreaker.MaxConsecutiveFailures},
+		{"ARGUS_GRAPHQL_MAX_DEPTH", &config.GraphQL.MaxDepth},
+		{"ARGUS_GRAPHQL_MAX_ALIASES", &config.GraphQL.MaxAliases},
+		{"ARGUS_GRAPHQL_MAX_COMPLEXITY", &config.GraphQL.MaxComplexity},
+	}
+	for _, i := range ints {
+		v, ok := os.LookupEnv(i.key)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %w", i.key, err)
+		}
+		*i.dst = n
+	}
+	if _, ok := os.LookupEnv("ARGUS_BLOCK_STATUS"); ok && !validStatus(config.BlockResponse.StatusCode) {
+		return config, fmt.Errorf("invalid ARGUS_BLOCK_STATUS: %d is not an HTTP status code", config.BlockResponse.StatusCode)
+	}
+
+	sizes := []struct {
+		key string
+		dst *int64
+	}{
+		{"ARGUS_MAX_BODY_SIZE", &config.MaxBodySize},
+		{"ARGUS_MAX_BODY_MEMORY", &config.MaxBodyMemory},
+		{"ARGUS_WEBSOCKET_MAX_CLIENT_MESSAGE_SIZE", &config.WebSocket.MaxClientMessageSize},
+		{"ARGUS_WEBSOCKET_MAX_SERVER_MESSAGE_SIZE", &config.WebSocket.MaxServerMessageSize},
+	}
+	for _, i := range sizes {
+		v, ok := os.LookupEnv(i.key)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %w", i.key, err)
+		}
+		*i.dst = n
+	}
+
+	durations := []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"ARGUS_RETRY_AFTER", &config.RetryAfter},
+		{"ARGUS_BREAKER_INTERVAL", &config.Breaker.Interval},
+		{"ARGUS_BREAKER_TIMEOUT", &config.Breaker.Timeout},
+		{"ARGUS_LOG_BREAKER_TIMEOUT", &config.LogBreaker.Timeout},
+	}
+	for _, d := range durations {
+		v, ok := os.LookupEnv(d.key)
+		if !ok {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %w", d.key, err)
+		}
+		*d.dst = parsed
+	}
+
+	bools := []struct {
+		key string
+		dst *bool
+	}{
+		{"ARGUS_INSPECT_RESPONSES", &config.InspectResponses},
+		{"ARGUS_BLOCK_RESPONSE_LEAKS", &config.BlockResponseLeaks},
+		{"ARGUS_WEBSOCKET_INSPECT", &config.WebSocket.Inspect},
+		{"ARGUS_GRAPHQL_BLOCK_INTROSPECTION", &config.GraphQL.BlockIntrospection},
+		{"ARGUS_REDACT_KEEP_SHAPE", &config.Redaction.KeepShape},
+	}
+	for _, b := range bools {
+		v, ok := os.LookupEnv(b.key)
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid %s: %w", b.key, err)
+		}
+		*b.dst = parsed
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_SHADOW_MODE"); ok {
+		switch mode := SecurityMode(v); mode {
+		case LatencyFirst, SmartShield, Paranoid:
+			config.ShadowMode = mode
+		default:
+			return config, fmt.Errorf("invalid ARGUS_SHADOW_MODE %q", v)
+		}
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_FAILURE_POLICY"); ok {
+		policy, err := parseFailurePolicy(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid ARGUS_FAILURE_POLICY: %w", err)
+		}
+		config.FailurePolicy = policy
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_FAILURE_POLICIES"); ok {
+		policies, err := parseFailurePolicies(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid ARGUS_FAILURE_POLICIES: %w", err)
+		}
+		config.FailurePolicies = policies
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_BLOCK_FORMAT"); ok {
+		switch format := BlockFormat(v); format {
+		case BlockText, BlockProblemJSON, BlockHTML:
+			config.BlockResponse.Format = format
+		default:
+			return config, fmt.Errorf("invalid ARGUS_BLOCK_FORMAT %q", v)
+		}
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_BLOCK_TEMPLATE"); ok {
+		tmpl, err := template.ParseFiles(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid ARGUS_BLOCK_TEMPLATE: %w", err)
+		}
+		config.BlockResponse.Template = tmpl
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_BLOCK_REDIRECT_URL"); ok {
+		config.BlockResponse.RedirectURL = v
+		if v != "" {
+			if _, err := config.BlockResponse.redirect(); err != nil {
+				return config, fmt.Errorf("invalid ARGUS_BLOCK_REDIRECT_URL: %w", err)
+			}
+		}
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_LOG_DROP_POLICY"); ok {
+		switch policy := DropPolicy(v); policy {
+		case DropOldest, DropNewest, DropBlock:
+			config.LogQueue.DropPolicy = policy
+		default:
+			return config, fmt.Errorf("invalid ARGUS_LOG_DROP_POLICY %q", v)
+		}
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_LATENCY_BUDGETS"); ok {
+		budgets, err := parseLatencyBudgets(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid ARGUS_LATENCY_BUDGETS: %w", err)
+		}
+		config.LatencyBudgets = budgets
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_ENGINE_MODE"); ok {
+		config.EngineMode = EngineMode(v)
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_BODY_LIMIT_ACTION"); ok {
+		switch action := BodyLimitAction(v); action {
+		case BodyLimitInspectPrefix, BodyLimitReject, BodyLimitPassThrough:
+			config.BodyLimitAction = action
+		default:
+			return config, fmt.Errorf("invalid ARGUS_BODY_LIMIT_ACTION %q", v)
+		}
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_RULE_FILES"); ok {
+		for _, file := range strings.Split(v, ",") {
+			if file = strings.TrimSpace(file); file != "" {
+				config.RuleFiles = append(config.RuleFiles, file)
+			}
+		}
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_REDACT_HEADERS"); ok {
+		config.Redaction.Headers = []string{}
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				config.Redaction.Headers = append(config.Redaction.Headers, name)
+			}
+		}
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_REDACT_JSON_PATHS"); ok {
+		config.Redaction.JSONPaths = nil
+		for _, path := range strings.Split(v, ",") {
+			if path = strings.TrimSpace(path); path == "" {
+				continue
+			}
+			if _, err := parseJSONPath(path); err != nil {
+				return config, fmt.Errorf("invalid ARGUS_REDACT_JSON_PATHS: %q: %w", path, err)
+			}
+			config.Redaction.JSONPaths = append(config.Redaction.JSONPaths, path)
+		}
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_REDACT_DETECTORS"); ok {
+		config.Redaction.Detectors = nil
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			d, ok := Detectors[strings.ToLower(name)]
+			if !ok {
+				return config, fmt.Errorf("invalid ARGUS_REDACT_DETECTORS: unknown detector %q", name)
+			}
+			config.Redaction.Detectors = append(config.Redaction.Detectors, d)
+		}
+	}
+
+	if v, ok := os.LookupEnv("ARGUS_GRAPHQL_PATHS"); ok {
+		config.GraphQL.Paths = nil
+		for _, path := range strings.Split(v, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				config.GraphQL.Paths = append(config.GraphQL.Paths, path)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+func parseLatencyBudgets(s string) (map[SecurityMode]time.Duration, error) {
+	budgets := make(map[SecurityMode]time.Duration)
+	err := parseModeValues(s, func(mode SecurityMode, value string) error {
+		budget, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		budgets[mode] = budget
+		return nil
+	})
+	return budgets, err
+}
+
+func parseFailurePolicies(s string) (map[SecurityMode]FailurePolicy, error) {
+	policies := make(map[SecurityMode]FailurePolicy)
+	err := parseModeValues(s, func(mode SecurityMode, value string) error {
+		policy, err := parseFailurePolicy(value)
+		if err != nil {
+			return err
+		}
+		policies[mode] = policy
+		return nil
+	})
+	return policies, err
+}
+
+func parseFailurePolicy(s string) (FailurePolicy, error) {
+	switch policy := FailurePolicy(s); policy {
+	case FailOpen, FailClosed, FailWAFVerdict, FailDegraded:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown failure policy %q", s)
+	}
+}
+
+// parseModeValues calls set for each MODE=value pair of a comma separated list.
+// Shadow has no values of its own, it takes those of the mode it shadows.
+func parseModeValues(s string, set func(mode SecurityMode, value string) error) error {
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		mode, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("expected MODE=value, got %q", part)
+		}
+		switch SecurityMode(mode) {
+		case LatencyFirst, SmartShield, Paranoid:
+		case Shadow:
+			return fmt.Errorf("%s has no values of its own, set them for the mode of ARGUS_SHADOW_MODE", mode)
+		default:
+			return fmt.Errorf("unknown mode %q", mode)
+		}
+		if err := set(SecurityMode(mode), value); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,231 @@
+package argus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Run("override WAF settings from env", func(t *testing.T) {
+		t.Setenv("ARGUS_RULE_FAMILIES", "930,942")
+		t.Setenv("ARGUS_PARANOIA_LEVEL", "2")
+		t.Setenv("ARGUS_INBOUND_ANOMALY_THRESHOLD", "10")
+		t.Setenv("ARGUS_OUTBOUND_ANOMALY_THRESHOLD", "8")
+		t.Setenv("ARGUS_ENGINE_MODE", "DetectionOnly")
+		t.Setenv("ARGUS_RULE_FILES", "/etc/argus/custom.conf, /etc/argus/tuning.conf")
+		t.Setenv("ARGUS_INSPECT_RESPONSES", "true")
+		t.Setenv("ARGUS_MAX_RESPONSE_INSPECT_SIZE", "65536")
+		t.Setenv("ARGUS_BLOCK_RESPONSE_LEAKS", "1")
+		t.Setenv("ARGUS_WEBSOCKET_INSPECT", "true")
+		t.Setenv("ARGUS_WEBSOCKET_MAX_CLIENT_MESSAGE_SIZE", "4096")
+		t.Setenv("ARGUS_WEBSOCKET_MAX_SERVER_MESSAGE_SIZE", "8192")
+		t.Setenv("ARGUS_GRAPHQL_PATHS", "/graphql, /api/graphql/*")
+		t.Setenv("ARGUS_GRAPHQL_MAX_DEPTH", "8")
+		t.Setenv("ARGUS_GRAPHQL_MAX_ALIASES", "5")
+		t.Setenv("ARGUS_GRAPHQL_MAX_COMPLEXITY", "200")
+		t.Setenv("ARGUS_GRAPHQL_BLOCK_INTROSPECTION", "true")
+		t.Setenv("ARGUS_REDACT_HEADERS", "Authorization, X-Session")
+		t.Setenv("ARGUS_REDACT_JSON_PATHS", "$.password, $.card.number")
+		t.Setenv("ARGUS_REDACT_DETECTORS", "card,EMAIL")
+		t.Setenv("ARGUS_REDACT_KEEP_SHAPE", "true")
+		t.Setenv("ARGUS_MAX_BODY_SIZE", "1048576")
+		t.Setenv("ARGUS_MAX_BODY_MEMORY", "65536")
+		t.Setenv("ARGUS_BODY_LIMIT_ACTION", "REJECT")
+		t.Setenv("ARGUS_SHADOW_MODE", "PARANOID")
+		t.Setenv("ARGUS_FAILURE_POLICY", "FAIL_CLOSED")
+		t.Setenv("ARGUS_FAILURE_POLICIES", "SMART_SHIELD=WAF_VERDICT,PARANOID=DEGRADED")
+		t.Setenv("ARGUS_RETRY_AFTER", "1m")
+		t.Setenv("ARGUS_BREAKER_MAX_FAILURES", "5")
+		t.Setenv("ARGUS_BREAKER_TIMEOUT", "10s")
+		t.Setenv("ARGUS_LOG_BREAKER_MAX_FAILURES", "20")
+		t.Setenv("ARGUS_BLOCK_STATUS", "451")
+		t.Setenv("ARGUS_BLOCK_FORMAT", "PROBLEM_JSON")
+		t.Setenv("ARGUS_BLOCK_REDIRECT_URL", "https://example.com/blocked")
+		t.Setenv("ARGUS_LOG_QUEUE_SIZE", "256")
+		t.Setenv("ARGUS_LOG_WORKERS", "2")
+		t.Setenv("ARGUS_LOG_BATCH_SIZE", "20")
+		t.Setenv("ARGUS_LOG_DROP_POLICY", "DROP_NEWEST")
+		t.Setenv("ARGUS_LATENCY_BUDGETS", "SMART_SHIELD=300ms, PARANOID=2s")
+
+		config, err := ConfigFromEnv(Config{Mode: Paranoid})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if config.Mode != Paranoid {
+			t.Errorf("Expected mode to be kept, got %s", config.Mode)
+		}
+		if len(config.RuleFamilies) != 2 {
+			t.Errorf("Expected 2 rule families, got %v", config.RuleFamilies)
+		}
+		if config.ParanoiaLevel != 2 || config.InboundAnomalyThreshold != 10 || config.OutboundAnomalyThreshold != 8 {
+			t.Errorf("Expected CRS settings from env, got %+v", config)
+		}
+		if config.EngineMode != EngineDetectionOnly {
+			t.Errorf("Expected DetectionOnly engine, got %s", config.EngineMode)
+		}
+		if len(config.RuleFiles) != 2 || config.RuleFiles[1] != "/etc/argus/tuning.conf" {
+			t.Errorf("Expected 2 trimmed rule files, got %q", config.RuleFiles)
+		}
+		if !config.InspectResponses || !config.BlockResponseLeaks || config.MaxResponseInspectSize != 65536 {
+			t.Errorf("Expected response inspection settings from env, got %+v", config)
+		}
+		if want := (WebSocketConfig{Inspect: true, MaxClientMessageSize: 4096, MaxServerMessageSize: 8192}); config.WebSocket != want {
+			t.Errorf("Expected WebSocket settings %+v, got %+v", want, config.WebSocket)
+		}
+		if g := config.GraphQL; len(g.Paths) != 2 || g.Paths[1] != "/api/graphql/*" || g.MaxDepth != 8 || g.MaxAliases != 5 || g.MaxComplexity != 200 || !g.BlockIntrospection {
+			t.Errorf("Expected GraphQL settings from env, got %+v", g)
+		}
+		if r := config.Redaction; len(r.Headers) != 2 || r.Headers[1] != "X-Session" || len(r.JSONPaths) != 2 || r.JSONPaths[1] != "$.card.number" || !r.KeepShape {
+			t.Errorf("Expected redaction settings from env, got %+v", r)
+		}
+		if d := config.Redaction.Detectors; len(d) != 2 || d[0].Name != "card_number" || d[1].Name != "email" {
+			t.Errorf("Expected the card and email detectors, got %v", d)
+		}
+		if config.MaxBodySize != 1048576 || config.MaxBodyMemory != 65536 || config.BodyLimitAction != BodyLimitReject {
+			t.Errorf("Expected body limits from env, got %+v", config)
+		}
+		if config.ShadowMode != Paranoid {
+			t.Errorf("Expected shadow mode PARANOID, got %s", config.ShadowMode)
+		}
+		if config.FailurePolicy != FailClosed {
+			t.Errorf("Expected failure policy FAIL_CLOSED, got %s", config.FailurePolicy)
+		}
+		if config.FailurePolicies[SmartShield] != FailWAFVerdict || config.FailurePolicies[Paranoid] != FailDegraded || config.RetryAfter != time.Minute {
+			t.Errorf("Expected per mode failure policies from env, got %v %s", config.FailurePolicies, config.RetryAfter)
+		}
+		if config.Breaker.MaxConsecutiveFailures != 5 || config.Breaker.Timeout != 10*time.Second || config.LogBreaker.MaxConsecutiveFailures != 20 {
+			t.Errorf("Expected breaker settings from env, got %+v %+v", config.Breaker, config.LogBreaker)
+		}
+		block := config.BlockResponse
+		if block.StatusCode != 451 || block.Format != BlockProblemJSON || block.RedirectURL != "https://example.com/blocked" {
+			t.Errorf("Expected block response from env, got %+v", block)
+		}
+		if config.LogQueue != (LogQueueConfig{Size: 256, Workers: 2, BatchSize: 20, DropPolicy: DropNewest}) {
+			t.Errorf("Expected log queue settings from env, got %+v", config.LogQueue)
+		}
+		if config.LatencyBudgets[SmartShield] != 300*time.Millisecond || config.LatencyBudgets[Paranoid] != 2*time.Second {
+			t.Errorf("Expected latency budgets from env, got %v", config.LatencyBudgets)
+		}
+	})
+
+	t.Run("keep values when env is unset", func(t *testing.T) {
+		config, err := ConfigFromEnv(Config{ParanoiaLevel: 3})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if config.ParanoiaLevel != 3 {
+			t.Errorf("Expected paranoia level 3, got %d", config.ParanoiaLevel)
+		}
+	})
+
+	t.Run("reject malformed numbers", func(t *testing.T) {
+		t.Setenv("ARGUS_PARANOIA_LEVEL", "high")
+
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for non numeric paranoia level, got nil")
+		}
+	})
+
+	t.Run("reject malformed booleans", func(t *testing.T) {
+		t.Setenv("ARGUS_INSPECT_RESPONSES", "maybe")
+
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for non boolean flag, got nil")
+		}
+	})
+
+	t.Run("reject unknown body limit action", func(t *testing.T) {
+		t.Setenv("ARGUS_BODY_LIMIT_ACTION", "DROP")
+
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for unknown body limit action, got nil")
+		}
+	})
+
+	t.Run("reject missing block template", func(t *testing.T) {
+		t.Setenv("ARGUS_BLOCK_TEMPLATE", "/nonexistent/block.html")
+
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for missing block template, got nil")
+		}
+	})
+
+	t.Run("reject invalid redirect URLs", func(t *testing.T) {
+		for _, target := range []string{"%zz", "blocked.html", "//example.com/blocked", "https:///blocked", "javascript:alert(1)"} {
+			t.Setenv("ARGUS_BLOCK_REDIRECT_URL", target)
+
+			if _, err := ConfigFromEnv(Config{}); err == nil {
+				t.Errorf("Expected error for redirect URL %q, got nil", target)
+			}
+		}
+	})
+
+	t.Run("reject block status outside 100-599", func(t *testing.T) {
+		for _, status := range []string{"-1", "0", "99", "600", "1000"} {
+			t.Setenv("ARGUS_BLOCK_STATUS", status)
+
+			if _, err := ConfigFromEnv(Config{}); err == nil {
+				t.Errorf("Expected error for block status %s, got nil", status)
+			}
+		}
+	})
+
+	t.Run("reject malformed durations", func(t *testing.T) {
+		t.Setenv("ARGUS_BREAKER_TIMEOUT", "30")
+
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for a duration without unit, got nil")
+		}
+	})
+
+	t.Run("reject malformed latency budgets", func(t *testing.T) {
+		t.Setenv("ARGUS_LATENCY_BUDGETS", "SMART_SHIELD:300ms")
+
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for malformed latency budget, got nil")
+		}
+	})
+
+	t.Run("reject unknown failure policy", func(t *testing.T) {
+		t.Setenv("ARGUS_FAILURE_POLICIES", "PARANOID=RETRY")
+
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for unknown failure policy, got nil")
+		}
+	})
+
+	t.Run("reject Shadow keys", func(t *testing.T) {
+		t.Setenv("ARGUS_LATENCY_BUDGETS", "SHADOW=300ms")
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for a Shadow latency budget, got nil")
+		}
+
+		t.Setenv("ARGUS_LATENCY_BUDGETS", "")
+		t.Setenv("ARGUS_FAILURE_POLICIES", "SHADOW=FAIL_CLOSED")
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for a Shadow failure policy, got nil")
+		}
+	})
+
+	t.Run("reject malformed redaction settings", func(t *testing.T) {
+		t.Setenv("ARGUS_REDACT_JSON_PATHS", "password")
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for a JSON path without $, got nil")
+		}
+
+		t.Setenv("ARGUS_REDACT_JSON_PATHS", "$.password")
+		t.Setenv("ARGUS_REDACT_DETECTORS", "ssn")
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for unknown detector, got nil")
+		}
+	})
+	t.Run("reject unknown rule family", func(t *testing.T) {
+		t.Setenv("ARGUS_RULE_FAMILIES", "930,nope")
+
+		if _, err := ConfigFromEnv(Config{}); err == nil {
+			t.Error("Expected error for unknown rule family, got nil")
+		}
+	})
+}
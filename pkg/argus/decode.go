@@ -0,0 +1,126 @@
+package argus
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const defaultMaxDecompressionRatio = 100
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	errDecompressionBomb   = errors.New("decompression ratio limit exceeded")
+)
+
+// contentEncodings lists the encodings of the Content-Encoding header in
+// the order they were applied, without identity.
+func contentEncodings(header http.Header) []string {
+	var encodings []string
+	for _, v := range header.Values("Content-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			enc = strings.ToLower(strings.TrimSpace(enc))
+			if enc != "" && enc != "identity" {
+				encodings = append(encodings, enc)
+			}
+		}
+	}
+	return encodings
+}
+
+// decodeRequestBody undoes encodings on what was read of body so the WAF and
+// the analysis see plain bytes. The result has the same inspection limit as
+// body. Decoding stops with errDecompressionBomb once the output outgrows
+// memory and is more than ratio times the input read so far.
+func decodeRequestBody(body *requestBody, encodings []string, ratio int, memory int64) (*requestBody, error) {
+	if ratio <= 0 {
+		ratio = defaultMaxDecompressionRatio
+	}
+
+	compressed := &countingReader{r: body.stored()}
+	var r io.Reader = compressed
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		if r, err = decoder(encodings[i], r); err != nil {
+			if body.overflow && errors.Is(err, io.ErrUnexpectedEOF) {
+				// The inspected prefix ends inside the encoding header
+				return readRequestBody(http.NoBody, body.limit, memory)
+			}
+			return nil, err
+		}
+	}
+
+	decoded := &ratioReader{
+		r:          r,
+		compressed: compressed,
+		ratio:      int64(ratio),
+		min:        memory,
+		truncated:  body.overflow,
+	}
+	return readRequestBody(io.NopCloser(decoded), body.limit, memory)
+}
+
+func decoder(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// deflate is meant to be zlib wrapped, but some clients send it raw
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return brotli.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("%w %q", errUnsupportedEncoding, encoding)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ratioReader guards against decompression bombs. When the compressed input
+// was cut at the inspection limit, the unexpected end of the stream is the
+// end of the inspected part rather than an error.
+type ratioReader struct {
+	r          io.Reader
+	compressed *countingReader
+	ratio      int64
+	min        int64
+	n          int64
+	truncated  bool
+}
+
+func (d *ratioReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.n += int64(n)
+	if d.n > d.min && d.n > d.ratio*d.compressed.n {
+		return n, errDecompressionBomb
+	}
+	if d.truncated && errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,181 @@
+package argus
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func compress(t *testing.T, encoding string, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown encoding %s", encoding)
+	}
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestDecodeRequestBody(t *testing.T) {
+	plain := `{"query":"hello world"}`
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"gzip", "gzip", compress(t, "gzip", []byte(plain))},
+		{"zlib deflate", "deflate", compress(t, "deflate", []byte(plain))},
+		{"raw deflate", "deflate", compress(t, "raw-deflate", []byte(plain))},
+		{"brotli", "br", compress(t, "br", []byte(plain))},
+		{"stacked", "deflate, gzip", compress(t, "gzip", compress(t, "deflate", []byte(plain)))},
+		{"identity is skipped", "identity", []byte(plain)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waf := &recordingWAF{}
+			sender := &MockSender{}
+			mw := NewMiddleware(sender, waf, Config{Mode: Paranoid})
+
+			var upstream []byte
+			handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstream, _ = io.ReadAll(r.Body)
+			}))
+
+			req := httptest.NewRequest("POST", "/api", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Encoding", tt.encoding)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", rec.Code)
+			}
+			if waf.Body != plain {
+				t.Errorf("Expected WAF to see the decoded body, got %q", waf.Body)
+			}
+			if sender.SentReq.Log != plain {
+				t.Errorf("Expected the decoded body in the payload, got %q", sender.SentReq.Log)
+			}
+			if !bytes.Equal(upstream, tt.body) {
+				t.Error("Expected upstream to get the original encoded bytes")
+			}
+		})
+	}
+}
+
+func TestDecodeRequestBodyErrors(t *testing.T) {
+	bomb := compress(t, "gzip", bytes.Repeat([]byte("a"), 4<<20))
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		config   Config
+		wantCode int
+	}{
+		{"Unsupported encoding", "zstd", []byte("data"), Config{}, http.StatusUnsupportedMediaType},
+		{"Corrupt gzip", "gzip", []byte("not gzip at all"), Config{}, http.StatusBadRequest},
+		{"Decompression bomb", "gzip", bomb, Config{}, http.StatusRequestEntityTooLarge},
+		{"Decoded body over limit", "gzip", compress(t, "gzip", bytes.Repeat([]byte("a"), 1000)),
+			Config{MaxBodySize: 100, BodyLimitAction: BodyLimitReject}, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waf := &recordingWAF{}
+			tt.config.Mode = LatencyFirst
+			mw := NewMiddleware(&MockSender{}, waf, tt.config)
+
+			handlerCalled := false
+			handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerCalled = true
+			}))
+
+			req := httptest.NewRequest("POST", "/api", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Encoding", tt.encoding)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("Expected %d, got %d", tt.wantCode, rec.Code)
+			}
+			if handlerCalled {
+				t.Error("Expected the request to stop before the handler")
+			}
+		})
+	}
+}
+
+func TestDecodeTruncatedPrefix(t *testing.T) {
+	plain := strings.Repeat("0123456789", 200)
+	encoded := compress(t, "gzip", []byte(plain))
+
+	waf := &recordingWAF{}
+	mw := NewMiddleware(&MockSender{}, waf, Config{Mode: LatencyFirst, MaxBodySize: int64(len(encoded) / 2)})
+
+	var upstream []byte
+	handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream, _ = io.ReadAll(r.Body)
+	}))
+
+	req := httptest.NewRequest("POST", "/api", bytes.NewReader(encoded))
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a truncated encoded prefix to be inspected, got %d", rec.Code)
+	}
+	if !strings.HasPrefix(plain, waf.Body) {
+		t.Errorf("Expected WAF to see a prefix of the decoded body, got %q", waf.Body)
+	}
+	if !bytes.Equal(upstream, encoded) {
+		t.Error("Expected upstream to get the original encoded bytes")
+	}
+}
+
+func TestDecodeWithCoraza(t *testing.T) {
+	waf, err := NewWAF(Config{})
+	if err != nil {
+		t.Fatalf("Failed to create WAF: %v", err)
+	}
+
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		t.Run(encoding, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/search", bytes.NewReader(
+				compress(t, encoding, []byte("q=<script>alert(1)</script>"))))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Content-Encoding", encoding)
+
+			mw := NewMiddleware(&MockSender{}, waf, Config{Mode: LatencyFirst})
+			handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("Expected encoded XSS to be blocked, got %d", rec.Code)
+			}
+		})
+	}
+}
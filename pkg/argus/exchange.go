@@ -0,0 +1,186 @@
+package argus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Exchange is a request and its response on a server that is not net/http.
+// Adapters implement it so ServeExchange can run the pipeline of Protect on
+// the server's own request type, without building an *http.Request.
+type Exchange interface {
+	Context() context.Context
+	Method() string
+	// RequestURI is the path and query as the client sent them.
+	RequestURI() string
+	Path() string
+	Proto() string
+	RemoteAddr() string
+	// VisitHeaders calls fn for every request header value, Host included.
+	VisitHeaders(fn func(name, value string))
+	// Body is the whole request body. It must still be there for the
+	// protected handler.
+	Body() []byte
+	// Next serves the request with the protected handler. ctx is Context
+	// with the request ID and Verdict added.
+	Next(ctx context.Context)
+	// Respond answers the request in place of the protected handler.
+	Respond(status int, header http.Header, body []byte)
+	// Request converts the exchange to an *http.Request. It is only called
+	// for hooks, and for a WAF that is not an ExchangeRuleEngine.
+	Request(ctx context.Context) *http.Request
+}
+
+// ExchangeRuleEngine is a RuleEngine that checks an Exchange as it is. Other
+// engines check the *http.Request it converts to.
+type ExchangeRuleEngine interface {
+	RuleEngine
+	CheckExchange(x Exchange, body io.Reader) (WAFResult, error)
+}
+
+// ServeExchange is Protect for servers that are not net/http. Responses are
+// not inspected, InspectResponses only applies to Protect.
+func (m *Middleware) ServeExchange(x Exchange) {
+	ctx, span, mw := m.start(x.Context(), x.Method(), x.Path())
+	defer span.End()
+	mw.serveExchange(&exchangeInbound{ctx: ctx, x: x})
+}
+
+func (m *Middleware) serveExchange(in *exchangeInbound) {
+	m.Config.Metrics.observeRequest(m.mode(), m.routeLabel())
+
+	limit, memory, action := m.bodyLimits()
+	shadow := m.Config.Mode == Shadow
+	raw := in.x.Body()
+
+	if action == BodyLimitReject && int64(len(raw)) > limit {
+		respondError(in, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+
+	var inspected *requestBody
+	if len(raw) > 0 {
+		inspected = bufferedBody(raw, limit)
+
+		var encodings []string
+		in.x.VisitHeaders(func(name, value string) {
+			if http.CanonicalHeaderKey(name) == "Content-Encoding" {
+				encodings = append(encodings, value)
+			}
+		})
+		if encodings = contentEncodings(http.Header{"Content-Encoding": encodings}); len(encodings) > 0 {
+			decoded, err := decodeRequestBody(inspected, encodings, m.Config.MaxDecompressionRatio, memory)
+			switch {
+			case err != nil && shadow:
+				decoded = nil
+			case errors.Is(err, errUnsupportedEncoding):
+				respondError(in, http.StatusUnsupportedMediaType, "Unsupported Content-Encoding")
+				return
+			case errors.Is(err, errDecompressionBomb):
+				respondError(in, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			case err != nil:
+				respondError(in, http.StatusBadRequest, "Malformed request body encoding")
+				return
+			}
+			if decoded != nil {
+				defer decoded.Close()
+
+				if decoded.overflow && action == BodyLimitReject {
+					respondError(in, http.StatusRequestEntityTooLarge, "Request body too large")
+					return
+				}
+			}
+			inspected = decoded
+		}
+
+		if inspected != nil && inspected.overflow && action == BodyLimitPassThrough {
+			inspected = nil
+		}
+	}
+
+	var payload []byte
+	var wafBody io.Reader = http.NoBody
+	if inspected != nil {
+		payload = inspected.Payload()
+		if int64(len(payload)) > memory {
+			payload = payload[:memory]
+		}
+		wafBody = inspected.Inspected()
+	}
+
+	wafX := in.x
+	var contentType string
+	in.x.VisitHeaders(func(name, value string) {
+		if contentType == "" && http.CanonicalHeaderKey(name) == "Content-Type" {
+			contentType = value
+		}
+	})
+	_, rawQuery, _ := strings.Cut(in.x.RequestURI(), "?")
+	query, _ := url.ParseQuery(rawQuery)
+	if g := m.Config.GraphQL.detect(in.x.Method(), in.x.Path(), contentType, query, payload); g != nil {
+		g.annotate(in.ctx)
+		gx := g.exchange(in.x)
+		wafX, wafBody = gx, bytes.NewReader(gx.body)
+	}
+
+	_, wafSpan := tracer().Start(in.ctx, "argus.waf.Check")
+	var wafResult WAFResult
+	var wafErr error
+	if engine, ok := m.WAF.(ExchangeRuleEngine); ok {
+		wafResult, wafErr = engine.CheckExchange(wafX, wafBody)
+	} else {
+		r := wafX.Request(in.ctx)
+		r.Body = io.NopCloser(wafBody)
+		wafResult, wafErr = m.WAF.Check(r)
+	}
+	endWAFSpan(wafSpan, wafResult, wafErr)
+
+	m.decide(in, wafResult, wafErr, payload)
+}
+
+type exchangeInbound struct {
+	ctx context.Context
+	x   Exchange
+	// r is the converted request, built for the first hook
+	r        *http.Request
+	response http.Header
+}
+
+func (in *exchangeInbound) Context() context.Context { return in.ctx }
+func (in *exchangeInbound) method() string           { return in.x.Method() }
+func (in *exchangeInbound) path() string             { return in.x.Path() }
+func (in *exchangeInbound) remoteAddr() string       { return in.x.RemoteAddr() }
+func (in *exchangeInbound) pass()                    { in.x.Next(in.ctx) }
+
+func (in *exchangeInbound) query() string {
+	_, query, _ := strings.Cut(in.x.RequestURI(), "?")
+	return query
+}
+
+func (in *exchangeInbound) visitHeaders(fn func(name, value string)) {
+	in.x.VisitHeaders(fn)
+}
+
+func (in *exchangeInbound) request() *http.Request {
+	if in.r == nil {
+		in.r = in.x.Request(in.ctx)
+	}
+	return in.r
+}
+
+func (in *exchangeInbound) header() http.Header {
+	if in.response == nil {
+		in.response = make(http.Header)
+	}
+	return in.response
+}
+
+func (in *exchangeInbound) respond(status int, body []byte) {
+	in.x.Respond(status, in.header(), body)
+}
@@ -0,0 +1,165 @@
+package argus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+// testExchange is an Exchange over plain fields. It counts conversions to
+// *http.Request.
+type testExchange struct {
+	method, uri string
+	headers     [][2]string
+	body        []byte
+
+	nextCtx     context.Context
+	status      int
+	header      http.Header
+	respBody    []byte
+	conversions int
+}
+
+func (x *testExchange) Context() context.Context { return context.Background() }
+func (x *testExchange) Method() string           { return x.method }
+func (x *testExchange) RequestURI() string       { return x.uri }
+func (x *testExchange) Proto() string            { return "HTTP/1.1" }
+func (x *testExchange) RemoteAddr() string       { return "10.0.0.1:4321" }
+func (x *testExchange) Body() []byte             { return x.body }
+func (x *testExchange) Next(ctx context.Context) { x.nextCtx = ctx }
+
+func (x *testExchange) Path() string {
+	path, _, _ := strings.Cut(x.uri, "?")
+	return path
+}
+
+func (x *testExchange) VisitHeaders(fn func(name, value string)) {
+	fn("Host", "example.com")
+	for _, h := range x.headers {
+		fn(h[0], h[1])
+	}
+}
+
+func (x *testExchange) Respond(status int, header http.Header, body []byte) {
+	x.status, x.header, x.respBody = status, header, body
+}
+
+func (x *testExchange) Request(ctx context.Context) *http.Request {
+	x.conversions++
+	r := httptest.NewRequest(x.method, x.uri, nil)
+	for _, h := range x.headers {
+		r.Header.Add(h[0], h[1])
+	}
+	return r.WithContext(ctx)
+}
+
+func TestServeExchange(t *testing.T) {
+	waf, err := NewWAF(Config{})
+	if err != nil {
+		t.Fatalf("Failed to create WAF: %v", err)
+	}
+
+	t.Run("WAF checks the exchange without converting it", func(t *testing.T) {
+		mw := NewMiddleware(&MockBatchSender{}, waf, Config{Mode: LatencyFirst})
+		defer mw.Close(context.Background())
+
+		attack := &testExchange{method: "GET", uri: "/search?q=" + url.QueryEscape("1' OR '1'='1' --")}
+		mw.ServeExchange(attack)
+		if attack.status != http.StatusForbidden || attack.nextCtx != nil {
+			t.Errorf("Expected a 403 without serving the request, got %d", attack.status)
+		}
+		if attack.header.Get("X-Request-ID") == "" {
+			t.Error("Expected the request ID on the block response")
+		}
+
+		clean := &testExchange{method: "GET", uri: "/search?q=shoes"}
+		mw.ServeExchange(clean)
+		if clean.nextCtx == nil {
+			t.Fatal("Expected the clean request to be served")
+		}
+		if RequestIDFromContext(clean.nextCtx) == "" {
+			t.Error("Expected a request ID in the handler context")
+		}
+		if v, ok := VerdictFromContext(clean.nextCtx); !ok || v.Mode != LatencyFirst {
+			t.Errorf("Expected the verdict in the handler context, got %+v", v)
+		}
+
+		if attack.conversions+clean.conversions != 0 {
+			t.Errorf("Expected no *http.Request conversion, got %d", attack.conversions+clean.conversions)
+		}
+	})
+
+	t.Run("Encoded bodies are decoded for the WAF and the analysis", func(t *testing.T) {
+		isThreat := false
+		sender := &MockSender{Response: protocol.AnalysisResponse{IsThreat: &isThreat}}
+		mw := NewMiddleware(sender, waf, Config{Mode: SmartShield})
+
+		x := &testExchange{
+			method: "POST",
+			uri:    "/search",
+			headers: [][2]string{
+				{"Content-Type", "application/x-www-form-urlencoded"},
+				{"Content-Encoding", "gzip"},
+			},
+			body: compress(t, "gzip", []byte("q=<script>alert(1)</script>")),
+		}
+		mw.ServeExchange(x)
+		mw.Close(context.Background())
+
+		if sender.SentReq.Log != "q=<script>alert(1)</script>" {
+			t.Errorf("Expected the decoded body to be analyzed, got %q", sender.SentReq.Log)
+		}
+		if x.nextCtx == nil {
+			t.Error("Expected the request to be served on a safe verdict")
+		}
+	})
+
+	t.Run("Body limits", func(t *testing.T) {
+		mw := NewMiddleware(&MockBatchSender{}, &MockWAF{}, Config{
+			Mode:            LatencyFirst,
+			MaxBodySize:     4,
+			BodyLimitAction: BodyLimitReject,
+		})
+		defer mw.Close(context.Background())
+
+		x := &testExchange{method: "POST", uri: "/upload", body: []byte("too large")}
+		mw.ServeExchange(x)
+		if x.status != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected 413, got %d", x.status)
+		}
+	})
+
+	t.Run("Other rule engines and hooks get the converted request", func(t *testing.T) {
+		mw := NewMiddleware(&MockBatchSender{}, &MockWAF{BlockRequest: true}, Config{
+			Mode:          LatencyFirst,
+			BlockResponse: BlockResponse{Format: BlockProblemJSON},
+		})
+		defer mw.Close(context.Background())
+
+		var hooked *http.Request
+		mw.OnBlock = func(r *http.Request, waf WAFResult, analysis protocol.AnalysisResponse) {
+			hooked = r
+		}
+
+		x := &testExchange{method: "DELETE", uri: "/users/1"}
+		mw.ServeExchange(x)
+
+		if x.status != http.StatusForbidden || x.header.Get("Content-Type") != "application/problem+json" {
+			t.Errorf("Expected a problem+json 403, got %d %s", x.status, x.header.Get("Content-Type"))
+		}
+		if !strings.Contains(string(x.respBody), `"instance":"/users/1"`) {
+			t.Errorf("Expected the path as instance, got %s", x.respBody)
+		}
+		if hooked == nil || hooked.Method != "DELETE" || RequestIDFromContext(hooked.Context()) == "" {
+			t.Errorf("Expected OnBlock with the converted request, got %v", hooked)
+		}
+		if x.conversions != 2 {
+			t.Errorf("Expected one conversion for the WAF and one for the hook, got %d", x.conversions)
+		}
+	})
+}
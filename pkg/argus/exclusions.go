@@ -0,0 +1,87 @@
+package argus
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RuleExclusion turns off CRS rules, or single variables, on matching routes.
+// It is the declarative form of a CRS false positive exclusion, e.g.
+//
+//	{Method: "POST", Path: "/api/cms/*", RuleIDs: []int{942100}}
+//	{Path: "/blog/editor", Targets: []string{"ARGS:content"}}
+type RuleExclusion struct {
+	// Method matches the request method. Empty matches every method.
+	Method string
+	// Path matches the request path exactly, or as a prefix when it ends in *.
+	Path string
+	// RuleIDs are the rules removed on the route. When Targets is set too, only
+	// those targets are removed from these rules.
+	RuleIDs []int
+	// Targets are variables the rules stop inspecting, e.g. ARGS:content or
+	// REQUEST_COOKIES:session. Without RuleIDs they are removed from every CRS rule.
+	Targets []string
+}
+
+// Generated exclusion rules take IDs from here up, away from the CRS 9xxxxx
+// range and from the low IDs custom rules usually pick.
+const exclusionRuleBaseID = 1000000
+
+var exclusionTarget = regexp.MustCompile(`^[A-Z_]+(:[^,;"' ]+)?$`)
+
+// exclusionDirectives renders the exclusions as runtime ctl rules. They have
+// to be loaded before the rule families they remove rules from.
+func exclusionDirectives(exclusions []RuleExclusion) (string, error) {
+	var sb strings.Builder
+
+	for i, ex := range exclusions {
+		if !strings.HasPrefix(ex.Path, "/") {
+			return "", fmt.Errorf("rule exclusion %d: path must start with /, got %q", i, ex.Path)
+		}
+		if len(ex.RuleIDs) == 0 && len(ex.Targets) == 0 {
+			return "", fmt.Errorf("rule exclusion %d: needs rule ids or targets", i)
+		}
+		if strings.ContainsAny(ex.Path, `"' `) || strings.ContainsAny(ex.Method, `"' `) {
+			return "", fmt.Errorf("rule exclusion %d: method and path must not contain quotes or spaces", i)
+		}
+
+		var ctls []string
+		for _, target := range ex.Targets {
+			if !exclusionTarget.MatchString(target) {
+				return "", fmt.Errorf("rule exclusion %d: invalid target %q", i, target)
+			}
+			if len(ex.RuleIDs) == 0 {
+				ctls = append(ctls, "ctl:ruleRemoveTargetByTag=OWASP_CRS;"+target)
+				continue
+			}
+			for _, id := range ex.RuleIDs {
+				ctls = append(ctls, fmt.Sprintf("ctl:ruleRemoveTargetById=%d;%s", id, target))
+			}
+		}
+		if len(ex.Targets) == 0 {
+			for _, id := range ex.RuleIDs {
+				ctls = append(ctls, "ctl:ruleRemoveById="+strconv.Itoa(id))
+			}
+		}
+
+		pathOp := "@streq " + ex.Path
+		if prefix, ok := strings.CutSuffix(ex.Path, "*"); ok {
+			pathOp = "@beginsWith " + prefix
+		}
+
+		id := exclusionRuleBaseID + i
+		if ex.Method == "" {
+			fmt.Fprintf(&sb, "SecRule REQUEST_FILENAME \"%s\" \"id:%d,phase:1,pass,nolog,t:none,%s\"\n",
+				pathOp, id, strings.Join(ctls, ","))
+			continue
+		}
+		fmt.Fprintf(&sb, "SecRule REQUEST_METHOD \"@streq %s\" \"id:%d,phase:1,pass,nolog,t:none,chain\"\n",
+			strings.ToUpper(ex.Method), id)
+		fmt.Fprintf(&sb, "    SecRule REQUEST_FILENAME \"%s\" \"t:none,%s\"\n",
+			pathOp, strings.Join(ctls, ","))
+	}
+
+	return sb.String(), nil
+}
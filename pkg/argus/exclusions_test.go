@@ -0,0 +1,187 @@
+package argus
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestExclusionDirectives(t *testing.T) {
+	t.Run("render method and prefix path as chained rule", func(t *testing.T) {
+		out, err := exclusionDirectives([]RuleExclusion{
+			{Method: "post", Path: "/api/cms/*", RuleIDs: []int{942100}},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if !strings.Contains(out, `REQUEST_METHOD "@streq POST"`) {
+			t.Errorf("Expected upper cased method condition, got:\n%s", out)
+		}
+		if !strings.Contains(out, `REQUEST_FILENAME "@beginsWith /api/cms/"`) {
+			t.Errorf("Expected prefix path condition, got:\n%s", out)
+		}
+		if !strings.Contains(out, "ctl:ruleRemoveById=942100") {
+			t.Errorf("Expected rule removal, got:\n%s", out)
+		}
+	})
+
+	t.Run("render targets without rule ids against every CRS rule", func(t *testing.T) {
+		out, err := exclusionDirectives([]RuleExclusion{
+			{Path: "/blog/editor", Targets: []string{"ARGS:content"}},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if !strings.Contains(out, `"@streq /blog/editor"`) {
+			t.Errorf("Expected exact path condition, got:\n%s", out)
+		}
+		if !strings.Contains(out, "ctl:ruleRemoveTargetByTag=OWASP_CRS;ARGS:content") {
+			t.Errorf("Expected target removal by tag, got:\n%s", out)
+		}
+	})
+
+	t.Run("reject invalid exclusions", func(t *testing.T) {
+		invalid := []RuleExclusion{
+			{Path: "api", RuleIDs: []int{1}},
+			{Path: "/api"},
+			{Path: "/api", Targets: []string{"args:x"}},
+			{Path: `/api" "id:1`, RuleIDs: []int{1}},
+		}
+		for _, ex := range invalid {
+			if _, err := exclusionDirectives([]RuleExclusion{ex}); err == nil {
+				t.Errorf("Expected error for %+v, got nil", ex)
+			}
+		}
+	})
+}
+
+func TestCorazaRuleExclusions(t *testing.T) {
+	sqliPayload := url.Values{"content": {"' OR 1=1"}}.Encode()
+
+	defaultWAF, err := NewWAF(Config{})
+	if err != nil {
+		t.Fatalf("Failed to init WAF: %v", err)
+	}
+
+	baseline, err := defaultWAF.Check(httptest.NewRequest("GET", "/api/cms/page?"+sqliPayload, nil))
+	if err != nil {
+		t.Fatalf("WAF check failed: %v", err)
+	}
+	var sqliRules []int
+	for _, id := range baseline.RuleIDs() {
+		if id >= 942000 && id < 943000 {
+			sqliRules = append(sqliRules, id)
+		}
+	}
+
+	t.Run("disable rules on matching method and path only", func(t *testing.T) {
+		waf, err := NewWAF(Config{RuleExclusions: []RuleExclusion{
+			{Method: "POST", Path: "/api/cms/*", RuleIDs: sqliRules},
+		}})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		excluded := httptest.NewRequest("POST", "/api/cms/page?"+sqliPayload, nil)
+		if result, _ := waf.Check(excluded); result.Blocked {
+			t.Errorf("Expected excluded rules to let request pass, fired %v", result.RuleIDs())
+		}
+
+		otherMethod := httptest.NewRequest("GET", "/api/cms/page?"+sqliPayload, nil)
+		if result, _ := waf.Check(otherMethod); !result.Blocked {
+			t.Error("Expected GET on same path to still be blocked")
+		}
+
+		otherPath := httptest.NewRequest("POST", "/api/users?"+sqliPayload, nil)
+		if result, _ := waf.Check(otherPath); !result.Blocked {
+			t.Error("Expected POST on other path to still be blocked")
+		}
+	})
+
+	t.Run("ignore a variable on a route", func(t *testing.T) {
+		waf, err := NewWAF(Config{RuleExclusions: []RuleExclusion{
+			{Path: "/blog/editor", Targets: []string{"ARGS:content"}},
+		}})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		excluded := httptest.NewRequest("GET", "/blog/editor?"+sqliPayload, nil)
+		if result, _ := waf.Check(excluded); result.Blocked {
+			t.Errorf("Expected ARGS:content to be ignored, fired %v", result.RuleIDs())
+		}
+
+		otherArg := httptest.NewRequest("GET", "/blog/editor?"+url.Values{"title": {"' OR 1=1"}}.Encode(), nil)
+		if result, _ := waf.Check(otherArg); !result.Blocked {
+			t.Error("Expected other arguments to still be inspected")
+		}
+	})
+}
+
+func TestCorazaCustomRules(t *testing.T) {
+	t.Run("load inline directives", func(t *testing.T) {
+		waf, err := NewWAF(Config{RuleDirectives: []string{
+			`SecRule ARGS:promo "@streq internal-only" "id:10001,phase:1,deny,status:403,msg:'Internal promo code'"`,
+		}})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		result, err := waf.Check(httptest.NewRequest("GET", "/checkout?promo=internal-only", nil))
+		if err != nil {
+			t.Fatalf("WAF check failed: %v", err)
+		}
+		if !result.Blocked {
+			t.Error("Expected custom rule to block request")
+		}
+		if ids := result.RuleIDs(); len(ids) == 0 || ids[len(ids)-1] != 10001 {
+			t.Errorf("Expected custom rule 10001 to match, got %v", ids)
+		}
+	})
+
+	t.Run("load rule files from fs", func(t *testing.T) {
+		rules := fstest.MapFS{
+			"custom.conf": {Data: []byte(`SecRule REQUEST_HEADERS:X-Debug "@streq 1" "id:10002,phase:1,deny,status:403,msg:'Debug header'"`)},
+		}
+		waf, err := NewWAF(Config{RulesFS: rules, RuleFiles: []string{"custom.conf"}})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Debug", "1")
+		if result, _ := waf.Check(req); !result.Blocked {
+			t.Error("Expected rule from fs to block request")
+		}
+	})
+
+	t.Run("remove CRS rule after load", func(t *testing.T) {
+		waf, err := NewWAF(Config{RuleDirectives: []string{
+			"SecRuleRemoveByTag attack-sqli",
+		}})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/search?q="+url.QueryEscape("' OR 1=1"), nil)
+		if result, _ := waf.Check(req); result.Blocked {
+			t.Errorf("Expected SQLi rules to be removed, fired %v", result.RuleIDs())
+		}
+	})
+
+	t.Run("report missing rule file", func(t *testing.T) {
+		if _, err := NewWAF(Config{RuleFiles: []string{"/nonexistent/argus.conf"}}); err == nil {
+			t.Error("Expected error for missing rule file, got nil")
+		}
+	})
+
+	t.Run("report invalid directive", func(t *testing.T) {
+		if _, err := NewWAF(Config{RuleDirectives: []string{"SecNotADirective On"}}); err == nil {
+			t.Error("Expected error for invalid directive, got nil")
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package argus
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sony/gobreaker/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultRetryAfter = 30 * time.Second
+
+// errWAFCheck marks a failed WAF check handed to the failure policy.
+var errWAFCheck = errors.New("waf check failed")
+
+// failurePolicy is the policy of the deciding mode: the one configured for
+// it, else the default of the mode.
+func (c Config) failurePolicy() FailurePolicy {
+	if policy := c.configuredFailurePolicy(); policy != "" {
+		return policy
+	}
+	if c.decidingMode() == Paranoid {
+		return FailWAFVerdict
+	}
+	return FailOpen
+}
+
+// configuredFailurePolicy is the policy set for the deciding mode in
+// FailurePolicies, then FailurePolicy. It is empty when neither sets one.
+func (c Config) configuredFailurePolicy() FailurePolicy {
+	if policy := c.FailurePolicies[c.decidingMode()]; policy != "" {
+		return policy
+	}
+	return c.FailurePolicy
+}
+
+// wafFallback is what the failure policy decides for a failed WAF check:
+// block, degraded, or continue without a WAF verdict.
+func (c Config) wafFallback() (FailurePolicy, string) {
+	switch policy := c.failurePolicy(); policy {
+	case FailClosed:
+		return policy, "block"
+	case FailDegraded:
+		return policy, "degraded"
+	default:
+		return policy, "continue"
+	}
+}
+
+// analysisFallback is what the failure policy decides for a failed sync
+// analysis: allow, block or degraded. A request that ran out of latency
+// budget gets the WAF verdict, unless a policy is configured for its mode.
+func (c Config) analysisFallback(wafResult WAFResult, err error) (FailurePolicy, string) {
+	policy := c.failurePolicy()
+	if errors.Is(err, errLatencyBudget) && c.configuredFailurePolicy() == "" {
+		policy = FailWAFVerdict
+	}
+
+	switch {
+	case policy == FailDegraded:
+		return policy, "degraded"
+	case policy == FailClosed, policy == FailWAFVerdict && wafResult.Blocked:
+		return policy, "block"
+	default:
+		return policy, "allow"
+	}
+}
+
+// handleWAFFailure applies the failure policy to a failed WAF check. It
+// reports whether the request was answered. Otherwise the request goes on
+// through the mode without a WAF verdict.
+func (m *Middleware) handleWAFFailure(in inbound, body []byte, wafErr error) bool {
+	err := errors.Join(errWAFCheck, wafErr)
+
+	policy, decision := m.Config.wafFallback()
+	m.reportFallback(in, body, WAFResult{}, err, policy, decision)
+	switch decision {
+	case "block":
+		m.block(in, "Blocked by Argus Shield")
+	case "degraded":
+		m.degraded(in)
+	default:
+		return false
+	}
+	return true
+}
+
+// handleAnalysisFailure decides a request the sync analysis failed on by the
+// failure policy.
+func (m *Middleware) handleAnalysisFailure(in inbound, wafResult WAFResult, body []byte, err error, message string) {
+	policy, decision := m.Config.analysisFallback(wafResult, err)
+	m.reportFallback(in, body, wafResult, err, policy, decision)
+	switch decision {
+	case "degraded":
+		m.degraded(in)
+	case "block":
+		m.block(in, message)
+	default:
+		in.pass()
+	}
+}
+
+// reportFallback sends the backend an async event saying why the request
+// fell back, under which policy, and what was decided.
+func (m *Middleware) reportFallback(in inbound, body []byte, wafResult WAFResult, err error, policy FailurePolicy, decision string) {
+	req := m.buildPayload(in, body, wafResult)
+	m.noteFallback(in, req.MetaData, err, policy, decision)
+	m.sendAsyncPayload(req)
+}
+
+// noteFallback records a fallback in the metrics, the span, the Verdict and
+// meta, and fires OnFallback.
+func (m *Middleware) noteFallback(in inbound, meta map[string]string, err error, policy FailurePolicy, decision string) {
+	reason := fallbackReason(err)
+	m.Config.Metrics.observeFallback(m.mode(), m.routeLabel(), reason, decision)
+	trace.SpanFromContext(in.Context()).AddEvent("argus.fallback", trace.WithAttributes(
+		attribute.String("argus.fallback.reason", reason),
+		attribute.String("argus.fallback.policy", string(policy)),
+		attribute.String("argus.fallback.decision", decision),
+	))
+	verdictOf(in.Context()).Fallback = &Fallback{Reason: reason, Policy: policy, Decision: decision, Err: err}
+	fire(m.OnFallback, in)
+
+	meta["fallback_reason"] = reason
+	meta["fallback_error"] = err.Error()
+	meta["failure_policy"] = string(policy)
+	meta["fallback_decision"] = decision
+}
+
+func fallbackReason(err error) string {
+	switch {
+	case errors.Is(err, errWAFCheck):
+		return "waf_error"
+	case errors.Is(err, errLatencyBudget):
+		return "latency_budget"
+	case errors.Is(err, gobreaker.ErrOpenState), errors.Is(err, gobreaker.ErrTooManyRequests):
+		return "breaker_open"
+	default:
+		return "analysis_error"
+	}
+}
+
+// degraded answers 503 with Retry-After, in the format of block responses.
+func (m *Middleware) degraded(in inbound) {
+	retryAfter := m.Config.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	in.header().Set("Retry-After", strconv.Itoa(seconds))
+	m.writeBlock(in, http.StatusServiceUnavailable, "Argus protection is degraded, retry later")
+}
@@ -0,0 +1,203 @@
+package argus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+// fallbackReports serves one request with an analysis API that is down and
+// returns the response with the fallback events the middleware reported.
+func fallbackReports(t *testing.T, waf *MockWAF, config Config, path string) (*httptest.ResponseRecorder, bool, []protocol.AnalysisRequest) {
+	t.Helper()
+
+	sender := &MockBatchSender{MockSender: MockSender{Err: errors.New("api down")}}
+	mw := NewMiddleware(sender, waf, config)
+
+	handlerCalled := false
+	handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", path, nil))
+	mw.Close(context.Background())
+
+	var reports []protocol.AnalysisRequest
+	for _, batch := range sender.Batches {
+		for _, req := range batch {
+			if req.MetaData["fallback_reason"] != "" {
+				reports = append(reports, req)
+			}
+		}
+	}
+	return rec, handlerCalled, reports
+}
+
+func TestAnalysisFailurePolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       Config
+		wafBlocks    bool
+		wantCode     int
+		wantCalled   bool
+		wantDecision string
+	}{
+		{"SmartShield fails open by default", Config{Mode: SmartShield}, true, http.StatusOK, true, "allow"},
+		{"Paranoid takes the WAF pass by default", Config{Mode: Paranoid}, false, http.StatusOK, true, "allow"},
+		{"Paranoid takes the WAF block by default", Config{Mode: Paranoid}, true, http.StatusForbidden, false, "block"},
+		{"Fail closed", Config{Mode: Paranoid, FailurePolicy: FailClosed}, false, http.StatusForbidden, false, "block"},
+		{"Fail open", Config{Mode: Paranoid, FailurePolicy: FailOpen}, true, http.StatusOK, true, "allow"},
+		{"SmartShield with WAF verdict", Config{Mode: SmartShield, FailurePolicy: FailWAFVerdict}, true, http.StatusForbidden, false, "block"},
+		{"Degraded", Config{Mode: SmartShield, FailurePolicy: FailDegraded}, true, http.StatusServiceUnavailable, false, "degraded"},
+		{"Per mode policy wins over the global one",
+			Config{Mode: Paranoid, FailurePolicy: FailClosed, FailurePolicies: map[SecurityMode]FailurePolicy{Paranoid: FailOpen}},
+			true, http.StatusOK, true, "allow"},
+		{"Empty mode takes the SmartShield policy",
+			Config{FailurePolicies: map[SecurityMode]FailurePolicy{SmartShield: FailClosed}},
+			true, http.StatusForbidden, false, "block"},
+		{"Route policy sets the policy of the empty mode",
+			Config{Policies: []RoutePolicy{{Path: "/", FailurePolicy: FailClosed}}},
+			true, http.StatusForbidden, false, "block"},
+		{"Other modes keep the global policy",
+			Config{Mode: Paranoid, FailurePolicy: FailClosed, FailurePolicies: map[SecurityMode]FailurePolicy{SmartShield: FailOpen}},
+			false, http.StatusForbidden, false, "block"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, called, reports := fallbackReports(t, &MockWAF{BlockRequest: tt.wafBlocks}, tt.config, "/")
+
+			if rec.Code != tt.wantCode || called != tt.wantCalled {
+				t.Errorf("Expected %d (handler called: %v), got %d (%v)", tt.wantCode, tt.wantCalled, rec.Code, called)
+			}
+			if len(reports) != 1 {
+				t.Fatalf("Expected 1 fallback report, got %d", len(reports))
+			}
+			meta := reports[0].MetaData
+			if meta["fallback_reason"] != "analysis_error" || meta["fallback_decision"] != tt.wantDecision ||
+				meta["fallback_error"] != "api down" || meta["request_id"] == "" {
+				t.Errorf("Unexpected fallback report %v", meta)
+			}
+		})
+	}
+}
+
+func TestDegradedResponse(t *testing.T) {
+	t.Run("Retry-After rounds up to seconds", func(t *testing.T) {
+		rec, _, _ := fallbackReports(t, &MockWAF{}, Config{Mode: Paranoid, FailurePolicy: FailDegraded, RetryAfter: 1500 * time.Millisecond}, "/")
+
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "2" {
+			t.Errorf("Expected 503 with Retry-After 2, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+		}
+		if rec.Header().Get("X-Request-ID") == "" {
+			t.Error("Expected a request ID on the degraded response")
+		}
+	})
+
+	t.Run("Default Retry-After", func(t *testing.T) {
+		rec, _, _ := fallbackReports(t, &MockWAF{}, Config{Mode: Paranoid, FailurePolicy: FailDegraded}, "/")
+
+		if rec.Header().Get("Retry-After") != "30" {
+			t.Errorf("Expected Retry-After 30, got %q", rec.Header().Get("Retry-After"))
+		}
+	})
+}
+
+func TestWAFFailurePolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       FailurePolicy
+		wantCode     int
+		wantDecision string
+	}{
+		{"Fail open continues without a verdict", FailOpen, http.StatusOK, "continue"},
+		{"WAF verdict continues without a verdict", FailWAFVerdict, http.StatusOK, "continue"},
+		{"Fail closed", FailClosed, http.StatusForbidden, "block"},
+		{"Degraded", FailDegraded, http.StatusServiceUnavailable, "degraded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, _, reports := fallbackReports(t, &MockWAF{Err: errors.New("waf broken")}, Config{Mode: LatencyFirst, FailurePolicy: tt.policy}, "/")
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("Expected %d, got %d", tt.wantCode, rec.Code)
+			}
+			if len(reports) != 1 {
+				t.Fatalf("Expected 1 fallback report, got %d", len(reports))
+			}
+			meta := reports[0].MetaData
+			if meta["fallback_reason"] != "waf_error" || meta["fallback_decision"] != tt.wantDecision || meta["failure_policy"] != string(tt.policy) {
+				t.Errorf("Unexpected fallback report %v", meta)
+			}
+		})
+	}
+}
+
+func TestFailurePolicyReporting(t *testing.T) {
+	t.Run("Route policy overrides the mode policy", func(t *testing.T) {
+		config := Config{
+			Mode:            SmartShield,
+			FailurePolicies: map[SecurityMode]FailurePolicy{SmartShield: FailOpen},
+			Policies:        []RoutePolicy{{Path: "/pay", FailurePolicy: FailDegraded}},
+		}
+
+		rec, _, reports := fallbackReports(t, &MockWAF{BlockRequest: true}, config, "/pay")
+		if rec.Code != http.StatusServiceUnavailable || reports[0].MetaData["failure_policy"] != string(FailDegraded) {
+			t.Errorf("Expected the route policy, got %d %v", rec.Code, reports[0].MetaData)
+		}
+
+		rec, _, _ = fallbackReports(t, &MockWAF{BlockRequest: true}, config, "/other")
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected the mode policy elsewhere, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Latency budget reports the WAF verdict", func(t *testing.T) {
+		sender := &MockBatchSender{}
+		mw := NewMiddleware(&slowBatchSender{sender}, &MockWAF{BlockRequest: true}, Config{
+			Mode:           SmartShield,
+			LatencyBudgets: map[SecurityMode]time.Duration{SmartShield: 10 * time.Millisecond},
+		})
+
+		rec := httptest.NewRecorder()
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+		mw.Close(context.Background())
+
+		events := sender.Batches
+		if rec.Code != http.StatusForbidden || len(events) != 1 {
+			t.Fatalf("Expected a WAF block and 1 report, got %d and %d batches", rec.Code, len(events))
+		}
+		if meta := events[0][0].MetaData; meta["fallback_reason"] != "latency_budget" || meta["fallback_decision"] != "block" {
+			t.Errorf("Unexpected fallback report %v", meta)
+		}
+	})
+
+	t.Run("Open breaker", func(t *testing.T) {
+		sender := &MockSender{Err: errors.New("api down")}
+		mw := NewMiddleware(sender, &MockWAF{}, Config{Mode: Paranoid})
+		for range 5 {
+			mw.Breaker.Execute(func() (any, error) { return nil, sender.Err })
+		}
+
+		_, err := mw.sendSyncAnalysis(&httpInbound{r: httptest.NewRequest("GET", "/", nil)}, nil, WAFResult{})
+		if reason := fallbackReason(err); reason != "breaker_open" {
+			t.Errorf("Expected breaker_open, got %s (%v)", reason, err)
+		}
+	})
+}
+
+// slowBatchSender answers sync analyses too late for any budget.
+type slowBatchSender struct {
+	*MockBatchSender
+}
+
+func (s *slowBatchSender) SendAnalysisContext(ctx context.Context, req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	<-ctx.Done()
+	return protocol.AnalysisResponse{}, ctx.Err()
+}
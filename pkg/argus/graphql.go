@@ -0,0 +1,591 @@
+package argus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultGraphQLMaxDepth      = 15
+	defaultGraphQLMaxAliases    = 30
+	defaultGraphQLMaxComplexity = 1000
+
+	// graphqlTokenLimit bounds the work of parsing an operation
+	graphqlTokenLimit = 15000
+	// graphqlFieldBudget bounds the fields visited collecting arguments, which
+	// fragments spread many times could otherwise multiply
+	graphqlFieldBudget  = 10000
+	maxGraphQLArguments = 1000
+	// maxGraphQLCost saturates complexity instead of overflowing
+	maxGraphQLCost = 1 << 31
+)
+
+// GraphQLConfig turns on the GraphQL detector for the endpoints in Paths. The
+// detector parses the operations of a request and checks them against the
+// limits. The WAF then inspects each argument value, keyed by its field path,
+// as well as the rest of the request, and the values go to the backend in the
+// graphql_arguments metadata.
+type GraphQLConfig struct {
+	// Paths of the GraphQL endpoints, matched like RoutePolicy paths. The
+	// detector is off without them.
+	Paths []string
+	// MaxDepth limits how deep fields nest. Zero means 15.
+	MaxDepth int
+	// MaxAliases limits the aliased fields of a request. Zero means 30.
+	MaxAliases int
+	// MaxComplexity limits the cost of a request. Every field costs 1, times
+	// the first, last or limit argument of the list fields it is under. Zero
+	// means 1000.
+	MaxComplexity int
+	// BlockIntrospection refuses operations that query __schema or __type.
+	BlockIntrospection bool
+}
+
+// GraphQLResult is what the detector found in a request. Batched operations
+// are measured together.
+type GraphQLResult struct {
+	// Operations are the type and name of each operation, "query GetUser".
+	Operations    []string
+	Depth         int
+	Aliases       int
+	Complexity    int
+	Introspection bool
+	Arguments     []GraphQLArgument
+	// Violation is the limit the request broke, empty when it broke none. An
+	// operation that doesn't parse, or has more fields or argument values
+	// than the detector walks, breaks one too.
+	Violation string
+
+	// literals are where the literal argument values sit in the operation
+	// text, for redaction to mask them there too
+	literals []graphqlLiteral
+}
+
+// GraphQLArgument is an argument value and its field path, e.g.
+// "user.posts.filter.title" for posts(filter: {title: ...}) under user.
+type GraphQLArgument struct {
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+func (c GraphQLConfig) matches(path string) bool {
+	for _, p := range c.Paths {
+		if (RoutePolicy{Path: p}).matches("", path) {
+			return true
+		}
+	}
+	return false
+}
+
+// graphqlLiteral is an argument value written in the text of an operation,
+// from rune start to end, quotes included.
+type graphqlLiteral struct {
+	query      string
+	path       string
+	raw        string
+	start, end int
+}
+
+// graphqlParams is one operation of a request, as GraphQL over HTTP sends it.
+type graphqlParams struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// graphqlCheck is a request the detector parsed.
+type graphqlCheck struct {
+	result *GraphQLResult
+	// form holds the argument values the WAF inspects
+	form url.Values
+	// body replaces the body of a POST for the WAF: the JSON without the
+	// operation text, or the argument values as a form when the body is only
+	// the operation, as application/graphql sends it
+	body     []byte
+	formBody bool
+}
+
+// detect parses a request to a GraphQL endpoint. It returns nil for
+// other requests, and for ones that aren't GraphQL over HTTP or carry no
+// operation text, which the WAF inspects as they are.
+func (c GraphQLConfig) detect(method, path, contentType string, query url.Values, body []byte) *graphqlCheck {
+	if !c.matches(path) {
+		return nil
+	}
+
+	var ops []graphqlParams
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case method == http.MethodGet:
+		op := graphqlParams{Query: query.Get("query"), OperationName: query.Get("operationName")}
+		if v := query.Get("variables"); v != "" && json.Unmarshal([]byte(v), &op.Variables) != nil {
+			return nil
+		}
+		ops = append(ops, op)
+	case mediaType == "application/graphql":
+		ops = append(ops, graphqlParams{Query: string(body)})
+	case mediaType == "application/json":
+		trimmed := bytes.TrimSpace(body)
+		if len(trimmed) > 0 && trimmed[0] == '[' {
+			if json.Unmarshal(trimmed, &ops) != nil {
+				return nil
+			}
+		} else {
+			var op graphqlParams
+			if json.Unmarshal(trimmed, &op) != nil {
+				return nil
+			}
+			ops = append(ops, op)
+		}
+	default:
+		return nil
+	}
+
+	check := &graphqlCheck{result: &GraphQLResult{}, form: make(url.Values)}
+	parsed := false
+	for _, op := range ops {
+		if op.OperationName != "" {
+			check.form.Add("operationName", op.OperationName)
+		}
+		if op.Query == "" {
+			// persisted queries carry a hash, nothing to parse, but their
+			// variables still reach the server
+			check.walker(op.Variables).variable("", op.Variables)
+			continue
+		}
+		parsed = true
+		doc, err := parser.ParseQueryWithTokenLimit(&ast.Source{Input: op.Query}, graphqlTokenLimit)
+		if err != nil {
+			check.exceed("invalid query: " + parseError(err))
+			break
+		}
+		check.walk(doc, op.Variables)
+	}
+	if !parsed {
+		return nil
+	}
+	check.exceed(c.violation(check.result))
+	switch {
+	case method == http.MethodGet:
+	case mediaType == "application/graphql":
+		check.body, check.formBody = []byte(check.form.Encode()), true
+	default:
+		check.body = withoutOperations(body)
+	}
+	return check
+}
+
+// withoutOperations is a JSON body without the operation text of its
+// operations. The WAF inspects their argument values instead, the text as a
+// whole reads like an injection to the CRS.
+func withoutOperations(body []byte) []byte {
+	trimmed := bytes.TrimSpace(body)
+	var ops []map[string]json.RawMessage
+	batch := len(trimmed) > 0 && trimmed[0] == '['
+	if batch {
+		if json.Unmarshal(trimmed, &ops) != nil {
+			return body
+		}
+	} else {
+		var op map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &op) != nil {
+			return body
+		}
+		ops = append(ops, op)
+	}
+	for _, op := range ops {
+		delete(op, "query")
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	var err error
+	if batch {
+		err = enc.Encode(ops)
+	} else {
+		err = enc.Encode(ops[0])
+	}
+	if err != nil {
+		return body
+	}
+	return bytes.TrimSpace(out.Bytes())
+}
+
+// parseError is the message of a parser error, without its location.
+func parseError(err error) string {
+	var gqlErr *gqlerror.Error
+	if errors.As(err, &gqlErr) {
+		return gqlErr.Message
+	}
+	return err.Error()
+}
+
+// exceed records violation unless the request already broke a limit.
+func (g *graphqlCheck) exceed(violation string) {
+	if g.result.Violation == "" {
+		g.result.Violation = violation
+	}
+}
+
+// violation returns the first limit r breaks.
+func (c GraphQLConfig) violation(r *GraphQLResult) string {
+	maxDepth, maxAliases, maxComplexity := c.MaxDepth, c.MaxAliases, c.MaxComplexity
+	if maxDepth <= 0 {
+		maxDepth = defaultGraphQLMaxDepth
+	}
+	if maxAliases <= 0 {
+		maxAliases = defaultGraphQLMaxAliases
+	}
+	if maxComplexity <= 0 {
+		maxComplexity = defaultGraphQLMaxComplexity
+	}
+
+	switch {
+	case c.BlockIntrospection && r.Introspection:
+		return "introspection is disabled"
+	case r.Depth > maxDepth:
+		return fmt.Sprintf("query depth %d exceeds %d", r.Depth, maxDepth)
+	case r.Aliases > maxAliases:
+		return fmt.Sprintf("%d aliases exceed %d", r.Aliases, maxAliases)
+	case r.Complexity > maxComplexity:
+		return fmt.Sprintf("query complexity %d exceeds %d", r.Complexity, maxComplexity)
+	}
+	return ""
+}
+
+func (g *graphqlCheck) walker(variables map[string]any) *graphqlWalker {
+	return &graphqlWalker{
+		check:     g,
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: variables,
+		measured:  make(map[string][2]int),
+		visiting:  make(map[string]bool),
+		budget:    graphqlFieldBudget,
+	}
+}
+
+func (g *graphqlCheck) walk(doc *ast.QueryDocument, variables map[string]any) {
+	w := g.walker(variables)
+	for _, f := range doc.Fragments {
+		w.fragments[f.Name] = f
+	}
+
+	r := g.result
+	for _, op := range doc.Operations {
+		name := string(op.Operation)
+		if op.Name != "" {
+			name += " " + op.Name
+		}
+		r.Operations = append(r.Operations, name)
+
+		depth, cost := w.measure(op.SelectionSet)
+		r.Depth = max(r.Depth, depth)
+		r.Complexity = min(r.Complexity+cost, maxGraphQLCost)
+		w.directives("", op.Directives)
+		w.collect(op.SelectionSet, "", make(map[string]bool))
+	}
+}
+
+// graphqlWalker measures a document, each fragment once, and collects its
+// argument values.
+type graphqlWalker struct {
+	check     *graphqlCheck
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]any
+	// measured holds the depth and cost of the fragments measured so far
+	measured map[string][2]int
+	visiting map[string]bool
+	budget   int
+}
+
+func (w *graphqlWalker) measure(set ast.SelectionSet) (depth, cost int) {
+	for _, sel := range set {
+		var d, c int
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if sel.Name == "__schema" || sel.Name == "__type" {
+				w.check.result.Introspection = true
+			}
+			if sel.Alias != "" && sel.Alias != sel.Name {
+				w.check.result.Aliases++
+			}
+			d, c = w.measure(sel.SelectionSet)
+			d, c = d+1, min((c+1)*w.multiplier(sel), maxGraphQLCost)
+		case *ast.InlineFragment:
+			d, c = w.measure(sel.SelectionSet)
+		case *ast.FragmentSpread:
+			d, c = w.measureFragment(sel.Name)
+		}
+		depth = max(depth, d)
+		cost = min(cost+c, maxGraphQLCost)
+	}
+	return depth, cost
+}
+
+func (w *graphqlWalker) measureFragment(name string) (depth, cost int) {
+	if m, ok := w.measured[name]; ok {
+		return m[0], m[1]
+	}
+	f, ok := w.fragments[name]
+	if !ok || w.visiting[name] {
+		// the server refuses unknown and cyclic fragments
+		return 0, 0
+	}
+	w.visiting[name] = true
+	depth, cost = w.measure(f.SelectionSet)
+	delete(w.visiting, name)
+	w.measured[name] = [2]int{depth, cost}
+	return depth, cost
+}
+
+// multiplier is how many items a list field asks for, 1 for other fields.
+func (w *graphqlWalker) multiplier(f *ast.Field) int {
+	for _, arg := range f.Arguments {
+		if arg.Name != "first" && arg.Name != "last" && arg.Name != "limit" {
+			continue
+		}
+		var n int
+		switch arg.Value.Kind {
+		case ast.IntValue:
+			n, _ = strconv.Atoi(arg.Value.Raw)
+			n = min(n, maxGraphQLCost)
+		case ast.Variable:
+			if v, ok := w.variables[arg.Value.Raw].(float64); ok && v < maxGraphQLCost {
+				n = int(v)
+			}
+		}
+		if n > 1 {
+			return n
+		}
+	}
+	return 1
+}
+
+func (w *graphqlWalker) collect(set ast.SelectionSet, path string, spread map[string]bool) {
+	for _, sel := range set {
+		if w.budget <= 0 {
+			w.check.exceed(fmt.Sprintf("more than %d fields", graphqlFieldBudget))
+			return
+		}
+		switch sel := sel.(type) {
+		case *ast.Field:
+			w.budget--
+			key := sel.Alias
+			if key == "" {
+				key = sel.Name
+			}
+			fieldPath := joinPath(path, key)
+			for _, arg := range sel.Arguments {
+				w.value(joinPath(fieldPath, arg.Name), arg.Value)
+			}
+			w.directives(fieldPath, sel.Directives)
+			w.collect(sel.SelectionSet, fieldPath, spread)
+		case *ast.InlineFragment:
+			w.directives(path, sel.Directives)
+			w.collect(sel.SelectionSet, path, spread)
+		case *ast.FragmentSpread:
+			w.directives(path, sel.Directives)
+			f, ok := w.fragments[sel.Name]
+			if !ok || spread[sel.Name] {
+				continue
+			}
+			spread[sel.Name] = true
+			w.directives(path, f.Directives)
+			w.collect(f.SelectionSet, path, spread)
+			delete(spread, sel.Name)
+		}
+	}
+}
+
+// directives adds the argument values of directives, keyed by the path they
+// apply to, e.g. "user.@include.if".
+func (w *graphqlWalker) directives(path string, list ast.DirectiveList) {
+	for _, d := range list {
+		for _, arg := range d.Arguments {
+			w.value(joinPath(joinPath(path, "@"+d.Name), arg.Name), arg.Value)
+		}
+	}
+}
+
+func (w *graphqlWalker) value(path string, v *ast.Value) {
+	switch v.Kind {
+	case ast.Variable:
+		w.variable(path, w.variables[v.Raw])
+	case ast.IntValue, ast.FloatValue, ast.StringValue, ast.BlockValue, ast.EnumValue:
+		if w.add(path, v.Raw) && v.Position != nil && v.Position.Src != nil {
+			r := w.check.result
+			r.literals = append(r.literals, graphqlLiteral{
+				query: v.Position.Src.Input,
+				path:  path,
+				raw:   v.Raw,
+				start: v.Position.Start,
+				end:   v.Position.End,
+			})
+		}
+	case ast.ListValue:
+		for _, child := range v.Children {
+			w.value(path, child.Value)
+		}
+	case ast.ObjectValue:
+		for _, child := range v.Children {
+			w.value(joinPath(path, child.Name), child.Value)
+		}
+	}
+}
+
+// variable adds the value of a variable, decoded from JSON.
+func (w *graphqlWalker) variable(path string, v any) {
+	switch v := v.(type) {
+	case string:
+		w.add(path, v)
+	case float64:
+		w.add(path, strconv.FormatFloat(v, 'f', -1, 64))
+	case []any:
+		for _, item := range v {
+			w.variable(path, item)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			w.variable(joinPath(path, key), v[key])
+		}
+	}
+}
+
+// add collects an argument value, and reports whether there was room for it.
+func (w *graphqlWalker) add(path, value string) bool {
+	r := w.check.result
+	if len(r.Arguments) >= maxGraphQLArguments {
+		w.check.exceed(fmt.Sprintf("more than %d argument values", maxGraphQLArguments))
+		return false
+	}
+	r.Arguments = append(r.Arguments, GraphQLArgument{Path: path, Value: value})
+	w.check.form.Add(path, value)
+	return true
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// annotate records the result on the span of the request.
+func (g *graphqlCheck) annotate(ctx context.Context) {
+	r := g.result
+	verdictOf(ctx).GraphQL = r
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.StringSlice("argus.graphql.operations", r.Operations),
+		attribute.Int("argus.graphql.depth", r.Depth),
+		attribute.Int("argus.graphql.aliases", r.Aliases),
+		attribute.Int("argus.graphql.complexity", r.Complexity),
+		attribute.String("argus.graphql.violation", r.Violation),
+	)
+}
+
+// rawQuery is the query string the WAF inspects: the operation text of a GET
+// dropped, and the argument values added unless they make up the body.
+func (g *graphqlCheck) rawQuery(method string, query url.Values) string {
+	q := make(url.Values, len(query)+len(g.form))
+	for name, values := range query {
+		q[name] = values
+	}
+	if method == http.MethodGet {
+		q.Del("query")
+	}
+	if !g.formBody {
+		for name, values := range g.form {
+			q[name] = append(q[name], values...)
+		}
+	}
+	return q.Encode()
+}
+
+// wafRequest is r as the WAF checks it. Only the operation text is left out,
+// its argument values are added to the query string, or make up the form body
+// of an application/graphql request. Extensions, unused variables and other
+// keys are inspected as they were sent.
+func (g *graphqlCheck) wafRequest(r *http.Request) *http.Request {
+	wr := r.Clone(r.Context())
+	wr.URL.RawQuery = g.rawQuery(r.Method, r.URL.Query())
+	wr.RequestURI = wr.URL.RequestURI()
+	if r.Method == http.MethodGet {
+		return wr
+	}
+	wr.Body, wr.GetBody = io.NopCloser(bytes.NewReader(g.body)), nil
+	wr.ContentLength = int64(len(g.body))
+	if g.formBody {
+		wr.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	wr.Header.Del("Content-Encoding")
+	wr.Header.Set("Content-Length", strconv.Itoa(len(g.body)))
+	return wr
+}
+
+// graphqlExchange is an Exchange as the WAF checks it, like wafRequest.
+type graphqlExchange struct {
+	Exchange
+	check *graphqlCheck
+	uri   string
+	body  []byte
+}
+
+func (g *graphqlCheck) exchange(x Exchange) *graphqlExchange {
+	gx := &graphqlExchange{Exchange: x, check: g, uri: x.RequestURI(), body: g.body}
+	path, rawQuery, _ := strings.Cut(gx.uri, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	gx.uri = path + "?" + g.rawQuery(x.Method(), query)
+	return gx
+}
+
+func (x *graphqlExchange) RequestURI() string { return x.uri }
+func (x *graphqlExchange) Body() []byte       { return x.body }
+
+func (x *graphqlExchange) VisitHeaders(fn func(name, value string)) {
+	if x.Method() == http.MethodGet {
+		x.Exchange.VisitHeaders(fn)
+		return
+	}
+	form := x.check.formBody
+	if form {
+		fn("Content-Type", "application/x-www-form-urlencoded")
+	}
+	fn("Content-Length", strconv.Itoa(len(x.body)))
+	x.Exchange.VisitHeaders(func(name, value string) {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Type":
+			if form {
+				return
+			}
+		case "Content-Encoding", "Content-Length":
+			return
+		}
+		fn(name, value)
+	})
+}
+
+func (x *graphqlExchange) Request(ctx context.Context) *http.Request {
+	return x.check.wafRequest(x.Exchange.Request(ctx))
+}
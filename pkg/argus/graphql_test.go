@@ -0,0 +1,412 @@
+package argus
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+func TestGraphQLDetect(t *testing.T) {
+	config := GraphQLConfig{Paths: []string{"/graphql"}}
+
+	detect := func(t *testing.T, config GraphQLConfig, body string) *GraphQLResult {
+		t.Helper()
+		g := config.detect(http.MethodPost, "/graphql", "application/json", nil, []byte(body))
+		if g == nil {
+			t.Fatalf("Expected %s to be parsed", body)
+		}
+		return g.result
+	}
+	operation := func(query string, variables map[string]any) string {
+		b, _ := json.Marshal(map[string]any{"query": query, "variables": variables})
+		return string(b)
+	}
+
+	t.Run("measures depth, aliases and complexity", func(t *testing.T) {
+		r := detect(t, config, operation(`query Feed {
+			viewer { name }
+			a: posts(first: 10) { title comments(first: 5) { body } }
+			b: posts(first: 2) { ...PostFields }
+		}
+		fragment PostFields on Post { title author { name } }`, nil))
+
+		if want := []string{"query Feed"}; !reflect.DeepEqual(r.Operations, want) {
+			t.Errorf("Expected operations %v, got %v", want, r.Operations)
+		}
+		if r.Depth != 3 {
+			t.Errorf("Expected depth 3, got %d", r.Depth)
+		}
+		if r.Aliases != 2 {
+			t.Errorf("Expected 2 aliases, got %d", r.Aliases)
+		}
+		// viewer 2, a 10*(1+1+5*(1+1)) = 120, b 2*(1+1+2) = 8
+		if r.Complexity != 130 {
+			t.Errorf("Expected complexity 130, got %d", r.Complexity)
+		}
+		if r.Violation != "" {
+			t.Errorf("Expected no violation, got %q", r.Violation)
+		}
+	})
+
+	t.Run("collects argument values with their field paths", func(t *testing.T) {
+		r := detect(t, config, operation(`query($term: String!, $filter: PostFilter) {
+			user(id: 42) { posts(filter: $filter, tags: ["go", "waf"]) { ...on Post { title } } }
+			search(term: $term, in: {field: TITLE, exact: true})
+		}`, map[string]any{"term": "' OR 1=1 --", "filter": map[string]any{"title": "<script>", "min": 3}}))
+
+		want := []GraphQLArgument{
+			{"user.id", "42"},
+			{"user.posts.filter.min", "3"},
+			{"user.posts.filter.title", "<script>"},
+			{"user.posts.tags", "go"},
+			{"user.posts.tags", "waf"},
+			{"search.term", "' OR 1=1 --"},
+			{"search.in.field", "TITLE"},
+		}
+		if !reflect.DeepEqual(r.Arguments, want) {
+			t.Errorf("Expected arguments %v, got %v", want, r.Arguments)
+		}
+	})
+
+	t.Run("reports the first limit broken", func(t *testing.T) {
+		tests := []struct {
+			name, query, violation string
+			config                 GraphQLConfig
+		}{
+			{"depth", `{ a { b { c { d } } } }`, "query depth 4 exceeds 3", GraphQLConfig{MaxDepth: 3}},
+			{"aliases", `{ a: me { id } b: me { id } c: me { id } }`, "3 aliases exceed 2", GraphQLConfig{MaxAliases: 2}},
+			{"complexity", `{ users(first: 100) { friends(first: 100) { id } } }`, "query complexity 20100 exceeds 1000", GraphQLConfig{}},
+			{"introspection", `{ __schema { types { name } } }`, "introspection is disabled", GraphQLConfig{BlockIntrospection: true}},
+			{"introspection allowed", `{ __type(name: "User") { name } }`, "", GraphQLConfig{}},
+			{"typename", `{ me { __typename } }`, "", GraphQLConfig{BlockIntrospection: true}},
+			{"invalid query", `{ me { `, "invalid query: Expected Name, found <EOF>", GraphQLConfig{}},
+			{"token limit", "{ " + strings.Repeat("a ", graphqlTokenLimit) + "}", "invalid query: exceeded token limit of 15000", GraphQLConfig{}},
+			{"argument values", "{ a(ids: [" + strings.Repeat("1 ", maxGraphQLArguments+1) + "]) }", "more than 1000 argument values", GraphQLConfig{}},
+			{"fields", "{ " + strings.Repeat("...F ", 101) + "} fragment F on Query { " + strings.Repeat("a ", 100) + "}", "more than 10000 fields", GraphQLConfig{}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.config.Paths = config.Paths
+				r := detect(t, tt.config, operation(tt.query, nil))
+				if r.Violation != tt.violation {
+					t.Errorf("Expected violation %q, got %q", tt.violation, r.Violation)
+				}
+			})
+		}
+	})
+
+	t.Run("measures batches together", func(t *testing.T) {
+		r := detect(t, config, `[{"query":"query A { a: me { id } }"},{"query":"mutation B { b: logout }"}]`)
+		if want := []string{"query A", "mutation B"}; !reflect.DeepEqual(r.Operations, want) {
+			t.Errorf("Expected operations %v, got %v", want, r.Operations)
+		}
+		if r.Aliases != 2 || r.Complexity != 3 {
+			t.Errorf("Expected 2 aliases and complexity 3, got %d and %d", r.Aliases, r.Complexity)
+		}
+	})
+
+	t.Run("skips persisted operations of a batch", func(t *testing.T) {
+		r := detect(t, config, `[
+			{"query":"query A { me { id } }"},
+			{"operationName":"Cached","variables":{"id":"' OR 1=1"},"extensions":{"persistedQuery":{"sha256Hash":"abc"}}},
+			{"query":"query B { user(id: 1) { name } }"}
+		]`)
+		if want := []string{"query A", "query B"}; !reflect.DeepEqual(r.Operations, want) {
+			t.Errorf("Expected operations %v, got %v", want, r.Operations)
+		}
+		want := []GraphQLArgument{{"id", "' OR 1=1"}, {"user.id", "1"}}
+		if !reflect.DeepEqual(r.Arguments, want) {
+			t.Errorf("Expected arguments %v, got %v", want, r.Arguments)
+		}
+	})
+
+	t.Run("collects directive arguments", func(t *testing.T) {
+		r := detect(t, config, operation(`query @log(tag: "op") {
+			user(id: 1) @cached(key: "k1") {
+				name @mask(with: "m")
+				...F @track(label: "s")
+				... on User @inline(note: "i") { id }
+			}
+		}
+		fragment F on User @frag(v: "f") { email }`, nil))
+
+		want := []GraphQLArgument{
+			{"@log.tag", "op"},
+			{"user.id", "1"},
+			{"user.@cached.key", "k1"},
+			{"user.name.@mask.with", "m"},
+			{"user.@track.label", "s"},
+			{"user.@frag.v", "f"},
+			{"user.@inline.note", "i"},
+		}
+		if !reflect.DeepEqual(r.Arguments, want) {
+			t.Errorf("Expected arguments %v, got %v", want, r.Arguments)
+		}
+	})
+
+	t.Run("survives cyclic and repeated fragments", func(t *testing.T) {
+		r := detect(t, config, operation(`{ me { ...A } }
+			fragment A on User { friends { ...B ...B } }
+			fragment B on User { name ...A }`, nil))
+		if r.Depth != 3 {
+			t.Errorf("Expected depth 3, got %d", r.Depth)
+		}
+	})
+
+	t.Run("reads GET and application/graphql requests", func(t *testing.T) {
+		query := url.Values{"query": {"query Me($id: ID) { user(id: $id) { name } }"}, "variables": {`{"id":"7"}`}}
+		g := config.detect(http.MethodGet, "/graphql", "", query, nil)
+		if g == nil || len(g.result.Arguments) != 1 || g.result.Arguments[0].Value != "7" {
+			t.Errorf("Expected the GET variables to be read, got %+v", g)
+		}
+
+		g = config.detect(http.MethodPost, "/graphql", "application/graphql; charset=utf-8", nil, []byte(`{ user(id: "9") { name } }`))
+		if g == nil || len(g.result.Arguments) != 1 || g.result.Arguments[0].Value != "9" {
+			t.Errorf("Expected the raw query to be read, got %+v", g)
+		}
+	})
+
+	t.Run("leaves other requests alone", func(t *testing.T) {
+		tests := []struct {
+			name, path, contentType, body string
+		}{
+			{"other path", "/api/users", "application/json", `{"query":"{ me { id } }"}`},
+			{"form", "/graphql", "application/x-www-form-urlencoded", "query=x"},
+			{"invalid JSON", "/graphql", "application/json", `{"query":`},
+			{"persisted query", "/graphql", "application/json", `{"extensions":{"persistedQuery":{"sha256Hash":"abc"}}}`},
+		}
+		for _, tt := range tests {
+			if g := config.detect(http.MethodPost, tt.path, tt.contentType, nil, []byte(tt.body)); g != nil {
+				t.Errorf("%s: Expected no detection, got %+v", tt.name, g.result)
+			}
+		}
+		if g := (GraphQLConfig{}).detect(http.MethodPost, "/graphql", "application/json", nil, []byte(`{"query":"{ me { id } }"}`)); g != nil {
+			t.Errorf("Expected the detector to be off without paths, got %+v", g.result)
+		}
+	})
+}
+
+func TestGraphQLMiddleware(t *testing.T) {
+	const body = `{"query":"query Find($q: String) { search(term: $q) { id } }","variables":{"q":"<b>hi</b>"},"operationName":"Find"}`
+	deep := `{"query":"{ a { b { c { d } } } }"}`
+	config := Config{Mode: LatencyFirst, GraphQL: GraphQLConfig{Paths: []string{"/graphql"}, MaxDepth: 3}}
+
+	t.Run("the WAF inspects argument values and the body without the operation", func(t *testing.T) {
+		waf := &recordingWAF{}
+		mw := NewMiddleware(&MockSender{}, waf, config)
+
+		var got string
+		var verdict Verdict
+		handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			got = string(b)
+			verdict, _ = VerdictFromContext(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if want := "/graphql?operationName=Find&search.term=%3Cb%3Ehi%3C%2Fb%3E"; waf.URI != want {
+			t.Errorf("Expected the WAF to see %q, got %q", want, waf.URI)
+		}
+		if want := `{"operationName":"Find","variables":{"q":"<b>hi</b>"}}`; waf.Body != want || waf.ContentType != "application/json" {
+			t.Errorf("Expected the WAF to see the body %q, got %q as %q", want, waf.Body, waf.ContentType)
+		}
+		if got != body {
+			t.Errorf("Expected the handler to get the original body, got %q", got)
+		}
+		if verdict.GraphQL == nil || verdict.GraphQL.Operations[0] != "query Find" {
+			t.Errorf("Expected the verdict to carry the GraphQL result, got %+v", verdict.GraphQL)
+		}
+	})
+
+	t.Run("GET operations get their values in the query string", func(t *testing.T) {
+		waf := &recordingWAF{}
+		mw := NewMiddleware(&MockSender{}, waf, config)
+		q := url.Values{"query": {`{ user(id: "5") { name } }`}, "trace": {"1"}}
+		mw.Protect(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).
+			ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/graphql?"+q.Encode(), nil))
+
+		if want := "/graphql?trace=1&user.id=5"; waf.URI != want {
+			t.Errorf("Expected the WAF to see %q, got %q", want, waf.URI)
+		}
+	})
+
+	t.Run("application/graphql operations are replaced by their values", func(t *testing.T) {
+		waf := &recordingWAF{}
+		mw := NewMiddleware(&MockSender{}, waf, config)
+		req := httptest.NewRequest(http.MethodPost, "/graphql?trace=1", strings.NewReader(`{ user(id: "5") { name } }`))
+		req.Header.Set("Content-Type", "application/graphql")
+		mw.Protect(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+		if waf.URI != "/graphql?trace=1" || waf.Body != "user.id=5" || waf.ContentType != "application/x-www-form-urlencoded" {
+			t.Errorf("Expected the WAF to see the form user.id=5, got %q %q as %q", waf.URI, waf.Body, waf.ContentType)
+		}
+	})
+
+	t.Run("blocks operations over the limits", func(t *testing.T) {
+		sender := &MockBatchSender{}
+		mw := NewMiddleware(sender, &MockWAF{}, config)
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(deep))
+		req.Header.Set("Content-Type", "application/json")
+		mw.Protect(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			t.Error("Expected the handler not to run")
+		})).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "query depth 4 exceeds 3") {
+			t.Errorf("Expected 403 naming the depth, got %d %q", rec.Code, rec.Body.String())
+		}
+
+		mw.Close(context.Background())
+		var reqs []protocol.AnalysisRequest
+		for _, batch := range sender.Batches {
+			reqs = append(reqs, batch...)
+		}
+		if len(reqs) != 1 || reqs[0].MetaData["graphql_violation"] != "query depth 4 exceeds 3" || reqs[0].MetaData["graphql_depth"] != "4" {
+			t.Errorf("Expected the violation to be logged, got %+v", reqs)
+		}
+	})
+
+	t.Run("shadow mode only reports violations", func(t *testing.T) {
+		shadow := config
+		shadow.Mode = Shadow
+		mw := NewMiddleware(&MockSender{}, &MockWAF{}, shadow)
+		served := false
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(deep))
+		req.Header.Set("Content-Type", "application/json")
+		mw.Protect(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { served = true })).
+			ServeHTTP(httptest.NewRecorder(), req)
+		mw.Close(context.Background())
+
+		if !served {
+			t.Error("Expected shadow mode to serve the request")
+		}
+	})
+
+	t.Run("argument values reach the backend", func(t *testing.T) {
+		paranoid := config
+		paranoid.Mode = Paranoid
+		sender := &MockSender{}
+		mw := NewMiddleware(sender, &MockWAF{}, paranoid)
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		mw.Protect(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+		meta := sender.SentReq.MetaData
+		var args []GraphQLArgument
+		json.Unmarshal([]byte(meta["graphql_arguments"]), &args)
+		if want := []GraphQLArgument{{"search.term", "<b>hi</b>"}}; !reflect.DeepEqual(args, want) {
+			t.Errorf("Expected the arguments %v in the metadata, got %q", want, meta["graphql_arguments"])
+		}
+		if meta["graphql_operations"] != "query Find" || meta["graphql_complexity"] != "2" {
+			t.Errorf("Expected the operation measures in the metadata, got %v", meta)
+		}
+		if sender.SentReq.Log != body {
+			t.Errorf("Expected the original body to be analyzed, got %q", sender.SentReq.Log)
+		}
+	})
+
+	t.Run("ServeExchange adds the values too", func(t *testing.T) {
+		waf := &recordingWAF{}
+		mw := NewMiddleware(&MockSender{}, waf, config)
+		x := &testExchange{
+			method:  http.MethodPost,
+			uri:     "/graphql",
+			headers: [][2]string{{"Content-Type", "application/json"}},
+			body:    []byte(body),
+		}
+		mw.ServeExchange(x)
+
+		if want := "/graphql?operationName=Find&search.term=%3Cb%3Ehi%3C%2Fb%3E"; waf.URI != want || !strings.Contains(waf.Body, `"variables"`) {
+			t.Errorf("Expected the WAF to see %q and the variables, got %q %q", want, waf.URI, waf.Body)
+		}
+		if x.nextCtx == nil {
+			t.Error("Expected the exchange to be served")
+		}
+	})
+
+	t.Run("CRS catches an injection in an argument", func(t *testing.T) {
+		waf, err := NewWAF(Config{})
+		if err != nil {
+			t.Fatalf("Failed to create WAF: %v", err)
+		}
+		mw := NewMiddleware(&MockSender{}, waf, config)
+		injection := `{"query":"query($id: String) { user(id: $id) { name } }","variables":{"id":"1' UNION SELECT password FROM users --"}}`
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(injection))
+		req.Header.Set("Content-Type", "application/json")
+		mw.Protect(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(rec, req)
+		mw.Close(context.Background())
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected the injection to be blocked, got %d", rec.Code)
+		}
+	})
+
+	t.Run("CRS catches an injection outside the operation", func(t *testing.T) {
+		waf, err := NewWAF(Config{})
+		if err != nil {
+			t.Fatalf("Failed to create WAF: %v", err)
+		}
+		mw := NewMiddleware(&MockSender{}, waf, config)
+		for name, payload := range map[string]string{
+			"extensions":       `{"query":"{ me { name } }","extensions":{"trace":"<script>alert(1)</script>"}}`,
+			"unused variables": `{"query":"{ me { name } }","variables":{"id":"1' UNION SELECT password FROM users --"}}`,
+			"extra keys":       `{"query":"{ me { name } }","debug":"<script>alert(1)</script>"}`,
+		} {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			mw.Protect(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("Expected the injection in %s to be blocked, got %d", name, rec.Code)
+			}
+		}
+		mw.Close(context.Background())
+	})
+
+	t.Run("CRS passes a clean operation", func(t *testing.T) {
+		waf, err := NewWAF(Config{})
+		if err != nil {
+			t.Fatalf("Failed to create WAF: %v", err)
+		}
+		mw := NewMiddleware(&MockSender{}, waf, Config{Mode: LatencyFirst, GraphQL: GraphQLConfig{Paths: []string{"/graphql"}}})
+		clean := `{"query":"query Feed($first: Int, $after: String) { feed(first: $first, after: $after) { edges { node { id title author { name } } } } }","variables":{"first":10,"after":"abc"},"operationName":"Feed"}`
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(clean))
+		req.Header.Set("Content-Type", "application/json")
+		mw.Protect(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(rec, req)
+		mw.Close(context.Background())
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected the clean operation to pass, got %d", rec.Code)
+		}
+	})
+}
+
+func TestWithoutOperations(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{"single", `{"query":"{ me { id } }","extensions":{"hash":"abc"}}`, `{"extensions":{"hash":"abc"}}`},
+		{"batch", `[{"query":"{ a }","debug":1},{"query":"{ b }","variables":{"x":"<i>"}}]`, `[{"debug":1},{"variables":{"x":"<i>"}}]`},
+		{"not JSON", `{"query":`, `{"query":`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(withoutOperations([]byte(tt.body))); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package argus
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+// Hook is called as the middleware decides on r, with the WAF result and the
+// sync AI verdict, which is zero when no analysis answered. The Verdict so far
+// is in r's context. Hooks run on the request goroutine, so a slow hook slows
+// the request down.
+type Hook func(r *http.Request, waf WAFResult, analysis protocol.AnalysisResponse)
+
+// Verdict is what the middleware decided about a request. Protected handlers
+// read it with VerdictFromContext.
+type Verdict struct {
+	Mode SecurityMode
+	WAF  WAFResult
+	// Analysis is the sync AI verdict, Analyzed reports whether one came back.
+	Analysis protocol.AnalysisResponse
+	Analyzed bool
+	Blocked  bool
+	// Fallback is set when the failure policy decided the request.
+	Fallback *Fallback
+	// GraphQL is set for requests to a GraphQLConfig endpoint.
+	GraphQL *GraphQLResult
+}
+
+// IsThreat reports whether the AI analysis found a threat.
+func (v Verdict) IsThreat() bool {
+	return v.Analyzed && v.Analysis.IsThreat != nil && *v.Analysis.IsThreat
+}
+
+// Fallback says why and how the failure policy decided a request.
+type Fallback struct {
+	// Reason is waf_error, latency_budget, breaker_open or analysis_error.
+	Reason string
+	Policy FailurePolicy
+	// Decision is allow, block, degraded or continue.
+	Decision string
+	Err      error
+}
+
+type verdictKey struct{}
+
+// VerdictFromContext returns the verdict on the request, and false outside of
+// a protected handler.
+func VerdictFromContext(ctx context.Context) (Verdict, bool) {
+	v, ok := ctx.Value(verdictKey{}).(*Verdict)
+	if !ok {
+		return Verdict{}, false
+	}
+	return *v, true
+}
+
+func withVerdict(ctx context.Context, mode SecurityMode) context.Context {
+	return context.WithValue(ctx, verdictKey{}, &Verdict{Mode: mode})
+}
+
+// verdictOf returns the verdict the middleware fills in for the request of
+// ctx.
+func verdictOf(ctx context.Context) *Verdict {
+	if v, ok := ctx.Value(verdictKey{}).(*Verdict); ok {
+		return v
+	}
+	return &Verdict{}
+}
+
+func fire(hook Hook, in inbound) {
+	if hook == nil {
+		return
+	}
+	v := verdictOf(in.Context())
+	hook(in.request(), v.WAF, v.Analysis)
+}
@@ -0,0 +1,135 @@
+package argus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+func TestHooks(t *testing.T) {
+	t.Run("Hooks fire in order with the WAF result and the AI verdict", func(t *testing.T) {
+		isThreat := true
+		sender := &MockSender{Response: protocol.AnalysisResponse{IsThreat: &isThreat}}
+		waf := &MockWAF{BlockRequest: true, Matches: []RuleMatch{{RuleID: 942100}}}
+		mw := NewMiddleware(sender, waf, Config{Mode: SmartShield})
+
+		var calls []string
+		record := func(name string) Hook {
+			return func(r *http.Request, waf WAFResult, analysis protocol.AnalysisResponse) {
+				calls = append(calls, name)
+				if !waf.Blocked || len(waf.Matches) != 1 {
+					t.Errorf("%s: expected the WAF result, got %+v", name, waf)
+				}
+				if name != "OnWAFMatch" && (analysis.IsThreat == nil || !*analysis.IsThreat) {
+					t.Errorf("%s: expected the threat verdict, got %+v", name, analysis)
+				}
+			}
+		}
+		mw.OnWAFMatch = record("OnWAFMatch")
+		mw.OnAIVerdict = record("OnAIVerdict")
+		mw.OnBlock = record("OnBlock")
+		mw.OnFallback = record("OnFallback")
+
+		rec := httptest.NewRecorder()
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("Expected the request to be blocked")
+		})).ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+		mw.Close(context.Background())
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", rec.Code)
+		}
+		if want := []string{"OnWAFMatch", "OnAIVerdict", "OnBlock"}; !slices.Equal(calls, want) {
+			t.Errorf("Expected hooks %v, got %v", want, calls)
+		}
+	})
+
+	t.Run("Protected handlers read the verdict", func(t *testing.T) {
+		isThreat := false
+		sender := &MockSender{Response: protocol.AnalysisResponse{IsThreat: &isThreat}}
+		mw := NewMiddleware(sender, &MockWAF{BlockRequest: true}, Config{Mode: SmartShield})
+		mw.OnBlock = func(r *http.Request, waf WAFResult, analysis protocol.AnalysisResponse) {
+			t.Error("Expected no OnBlock for a safe verdict")
+		}
+
+		var verdict Verdict
+		var ok bool
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			verdict, ok = VerdictFromContext(r.Context())
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+		mw.Close(context.Background())
+
+		if !ok {
+			t.Fatal("Expected a verdict in the request context")
+		}
+		if verdict.Mode != SmartShield || !verdict.WAF.Blocked || !verdict.Analyzed {
+			t.Errorf("Expected an analyzed SmartShield verdict on a WAF block, got %+v", verdict)
+		}
+		if verdict.IsThreat() || verdict.Blocked || verdict.Fallback != nil {
+			t.Errorf("Expected a safe, allowed verdict, got %+v", verdict)
+		}
+	})
+
+	t.Run("OnFallback sees the failure policy decision", func(t *testing.T) {
+		sender := &MockBatchSender{MockSender: MockSender{Err: errors.New("api down")}}
+		mw := NewMiddleware(sender, &MockWAF{}, Config{Mode: Paranoid})
+
+		var fallback *Fallback
+		mw.OnFallback = func(r *http.Request, waf WAFResult, analysis protocol.AnalysisResponse) {
+			v, _ := VerdictFromContext(r.Context())
+			fallback = v.Fallback
+		}
+		mw.OnAIVerdict = func(r *http.Request, waf WAFResult, analysis protocol.AnalysisResponse) {
+			t.Error("Expected no OnAIVerdict for a failed analysis")
+		}
+
+		var served Verdict
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served, _ = VerdictFromContext(r.Context())
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+		mw.Close(context.Background())
+
+		if fallback == nil {
+			t.Fatal("Expected OnFallback to be called")
+		}
+		if fallback.Reason != "analysis_error" || fallback.Policy != FailWAFVerdict || fallback.Decision != "allow" {
+			t.Errorf("Expected analysis_error, WAF_VERDICT, allow, got %+v", fallback)
+		}
+		if served.Fallback == nil || served.Analyzed {
+			t.Errorf("Expected the handler to see the fallback, got %+v", served)
+		}
+	})
+
+	t.Run("Hooks carry over to route policies", func(t *testing.T) {
+		mw := NewMiddleware(&MockSender{}, &MockWAF{BlockRequest: true}, Config{
+			Mode:     SmartShield,
+			Policies: []RoutePolicy{{Path: "/static/*", Mode: LatencyFirst}},
+		})
+		blocked := 0
+		mw.OnBlock = func(r *http.Request, waf WAFResult, analysis protocol.AnalysisResponse) {
+			if v, _ := VerdictFromContext(r.Context()); v.Mode != LatencyFirst {
+				t.Errorf("Expected the policy mode, got %s", v.Mode)
+			}
+			blocked++
+		}
+
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+			ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/static/app.js", nil))
+		mw.Close(context.Background())
+
+		if blocked != 1 {
+			t.Errorf("Expected OnBlock once, got %d", blocked)
+		}
+	})
+
+	t.Run("No verdict outside the middleware", func(t *testing.T) {
+		if _, ok := VerdictFromContext(context.Background()); ok {
+			t.Error("Expected no verdict")
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package argus
+
+import (
+	"context"
+	"net/http"
+)
+
+// inbound is a request past its WAF check, from Protect or ServeExchange.
+// The modes decide on it and answer it without knowing which.
+type inbound interface {
+	// Context carries the span, request ID and Verdict of the request.
+	Context() context.Context
+	// request is the *http.Request hooks are called with.
+	request() *http.Request
+	method() string
+	path() string
+	// query is the raw query string, without the '?'.
+	query() string
+	remoteAddr() string
+	visitHeaders(fn func(name, value string))
+	// header is the header of a response the middleware writes itself.
+	header() http.Header
+	respond(status int, body []byte)
+	// pass serves the request with the protected handler.
+	pass()
+}
+
+// respondError answers like http.Error.
+func respondError(in inbound, status int, message string) {
+	h := in.header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	in.respond(status, []byte(message+"\n"))
+}
+
+type httpInbound struct {
+	w    http.ResponseWriter
+	r    *http.Request
+	next http.Handler
+}
+
+func (in *httpInbound) Context() context.Context { return in.r.Context() }
+func (in *httpInbound) request() *http.Request   { return in.r }
+func (in *httpInbound) method() string           { return in.r.Method }
+func (in *httpInbound) path() string             { return in.r.URL.Path }
+func (in *httpInbound) query() string            { return in.r.URL.RawQuery }
+func (in *httpInbound) remoteAddr() string       { return in.r.RemoteAddr }
+func (in *httpInbound) header() http.Header      { return in.w.Header() }
+func (in *httpInbound) pass()                    { in.next.ServeHTTP(in.w, in.r) }
+
+func (in *httpInbound) visitHeaders(fn func(name, value string)) {
+	for name, values := range in.r.Header {
+		for _, value := range values {
+			fn(name, value)
+		}
+	}
+}
+
+func (in *httpInbound) respond(status int, body []byte) {
+	in.w.WriteHeader(status)
+	if len(body) > 0 {
+		in.w.Write(body)
+	}
+}
@@ -0,0 +1,226 @@
+package argus
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+// DropPolicy is what the async log queue does with a new event when it is full.
+type DropPolicy string
+
+const (
+	// DropOldest evicts the oldest queued event to make room.
+	DropOldest DropPolicy = "DROP_OLDEST"
+	// DropNewest discards the new event.
+	DropNewest DropPolicy = "DROP_NEWEST"
+	// DropBlock makes the request wait for room in the queue.
+	DropBlock DropPolicy = "BLOCK"
+)
+
+const (
+	defaultLogQueueSize    = 1024
+	defaultLogQueueWorkers = 4
+	defaultLogBatchSize    = 50
+)
+
+// BatchSender is an AnalysisSender that can also take many async events in
+// one call. The log queue uses it when available and falls back to one
+// SendAnalysis per event otherwise.
+type BatchSender interface {
+	AnalysisSender
+	SendAnalysisBatch(reqs []protocol.AnalysisRequest) error
+}
+
+// LogQueueConfig sizes the queue async events wait in before a worker
+// sends them. Zero values take the defaults.
+type LogQueueConfig struct {
+	// Size is how many events can wait. Default 1024.
+	Size int
+	// Workers send batches concurrently. Default 4.
+	Workers int
+	// BatchSize caps how many queued events one send carries. Default 50.
+	BatchSize int
+	// DropPolicy applies when the queue is full. Default DropOldest.
+	DropPolicy DropPolicy
+}
+
+// LogStats counts what happened to async events.
+type LogStats struct {
+	Queued  int64
+	Sent    uint64
+	Failed  uint64
+	Dropped uint64
+}
+
+// logQueue is a bounded queue drained by a pool of workers. Workers start
+// with the first event, so middlewares that never log cost nothing.
+type logQueue struct {
+	config LogQueueConfig
+	send   func(batch []protocol.AnalysisRequest) error
+	// onDrop, when set, is called for every dropped event
+	onDrop func()
+
+	start sync.Once
+	// mu guards closed against a concurrent enqueue.
+	mu      sync.RWMutex
+	closed  bool
+	events  chan protocol.AnalysisRequest
+	workers sync.WaitGroup
+
+	pending atomic.Int64
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func newLogQueue(config LogQueueConfig, send func(batch []protocol.AnalysisRequest) error) *logQueue {
+	if config.Size <= 0 {
+		config.Size = defaultLogQueueSize
+	}
+	if config.Workers <= 0 {
+		config.Workers = defaultLogQueueWorkers
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultLogBatchSize
+	}
+	if config.DropPolicy == "" {
+		config.DropPolicy = DropOldest
+	}
+
+	return &logQueue{
+		config: config,
+		send:   send,
+		events: make(chan protocol.AnalysisRequest, config.Size),
+	}
+}
+
+func (q *logQueue) enqueue(req protocol.AnalysisRequest) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		q.drop()
+		return
+	}
+
+	q.start.Do(func() {
+		for range q.config.Workers {
+			q.workers.Add(1)
+			go q.work()
+		}
+	})
+
+	q.pending.Add(1)
+	switch q.config.DropPolicy {
+	case DropBlock:
+		q.events <- req
+	case DropNewest:
+		select {
+		case q.events <- req:
+		default:
+			q.pending.Add(-1)
+			q.drop()
+		}
+	default:
+		for {
+			select {
+			case q.events <- req:
+				return
+			default:
+			}
+			select {
+			case <-q.events:
+				q.pending.Add(-1)
+				q.drop()
+			default:
+			}
+		}
+	}
+}
+
+func (q *logQueue) drop() {
+	q.dropped.Add(1)
+	if q.onDrop != nil {
+		q.onDrop()
+	}
+}
+
+// work sends whatever is queued, up to BatchSize events at a time, without
+// waiting for a batch to fill up.
+func (q *logQueue) work() {
+	defer q.workers.Done()
+
+	batch := make([]protocol.AnalysisRequest, 0, q.config.BatchSize)
+	for req := range q.events {
+		batch = append(batch[:0], req)
+	fill:
+		for len(batch) < q.config.BatchSize {
+			select {
+			case req, ok := <-q.events:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, req)
+			default:
+				break fill
+			}
+		}
+
+		if err := q.send(batch); err != nil {
+			q.failed.Add(uint64(len(batch)))
+		} else {
+			q.sent.Add(uint64(len(batch)))
+		}
+		q.pending.Add(-int64(len(batch)))
+	}
+}
+
+// flush waits until every queued event has been sent or dropped.
+func (q *logQueue) flush(ctx context.Context) error {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+
+	for q.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// close stops taking events, then waits for the workers to send the rest.
+func (q *logQueue) close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *logQueue) stats() LogStats {
+	return LogStats{
+		Queued:  q.pending.Load(),
+		Sent:    q.sent.Load(),
+		Failed:  q.failed.Load(),
+		Dropped: q.dropped.Load(),
+	}
+}
@@ -0,0 +1,193 @@
+package argus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+type MockBatchSender struct {
+	MockSender
+	mu      sync.Mutex
+	Batches [][]protocol.AnalysisRequest
+	Release chan struct{}
+	Err     error
+}
+
+func (m *MockBatchSender) SendAnalysisBatch(reqs []protocol.AnalysisRequest) error {
+	if m.Release != nil {
+		<-m.Release
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Batches = append(m.Batches, append([]protocol.AnalysisRequest(nil), reqs...))
+	return m.Err
+}
+
+func (m *MockBatchSender) events() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var logs []string
+	for _, batch := range m.Batches {
+		for _, req := range batch {
+			logs = append(logs, req.Log)
+		}
+	}
+	return logs
+}
+
+func TestLogQueue(t *testing.T) {
+	t.Run("batch queued events and flush", func(t *testing.T) {
+		sender := &MockBatchSender{Release: make(chan struct{})}
+		mw := NewMiddleware(sender, &MockWAF{}, Config{LogQueue: LogQueueConfig{Workers: 1, BatchSize: 10}})
+
+		// The first event holds the only worker, the rest queue up behind it
+		mw.sendAsyncPayload(protocol.AnalysisRequest{Log: "a"})
+		waitFor(t, func() bool { return len(mw.logs.events) == 0 })
+		for _, log := range []string{"b", "c", "d"} {
+			mw.sendAsyncPayload(protocol.AnalysisRequest{Log: log})
+		}
+		close(sender.Release)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := mw.Flush(ctx); err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+
+		if got := sender.events(); len(got) != 4 {
+			t.Fatalf("Expected 4 events sent, got %v", got)
+		}
+		if len(sender.Batches) != 2 || len(sender.Batches[1]) != 3 {
+			t.Errorf("Expected the queued events in one batch, got %v", sender.Batches)
+		}
+		if stats := mw.LogStats(); stats.Sent != 4 || stats.Queued != 0 || stats.Dropped != 0 {
+			t.Errorf("Unexpected stats %+v", stats)
+		}
+	})
+
+	dropTests := []struct {
+		policy DropPolicy
+		want   []string
+	}{
+		{DropOldest, []string{"a", "c", "d"}},
+		{DropNewest, []string{"a", "b", "c"}},
+	}
+	for _, tt := range dropTests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			sender := &MockBatchSender{Release: make(chan struct{})}
+			mw := NewMiddleware(sender, &MockWAF{}, Config{LogQueue: LogQueueConfig{Size: 2, Workers: 1, BatchSize: 1, DropPolicy: tt.policy}})
+
+			mw.sendAsyncPayload(protocol.AnalysisRequest{Log: "a"})
+			waitFor(t, func() bool { return mw.LogStats().Queued == 1 && len(mw.logs.events) == 0 })
+			for _, log := range []string{"b", "c", "d"} {
+				mw.sendAsyncPayload(protocol.AnalysisRequest{Log: log})
+			}
+			close(sender.Release)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := mw.Close(ctx); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			got := sender.events()
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Expected %v, got %v", tt.want, got)
+				}
+			}
+			if dropped := mw.LogStats().Dropped; dropped != 1 {
+				t.Errorf("Expected 1 dropped event, got %d", dropped)
+			}
+		})
+	}
+
+	t.Run("block waits for room", func(t *testing.T) {
+		sender := &MockBatchSender{Release: make(chan struct{})}
+		mw := NewMiddleware(sender, &MockWAF{}, Config{LogQueue: LogQueueConfig{Size: 1, Workers: 1, BatchSize: 1, DropPolicy: DropBlock}})
+
+		mw.sendAsyncPayload(protocol.AnalysisRequest{Log: "a"})
+		waitFor(t, func() bool { return len(mw.logs.events) == 0 })
+		mw.sendAsyncPayload(protocol.AnalysisRequest{Log: "b"})
+
+		done := make(chan struct{})
+		go func() {
+			mw.sendAsyncPayload(protocol.AnalysisRequest{Log: "c"})
+			close(done)
+		}()
+		select {
+		case <-done:
+			t.Fatal("Expected enqueue to block on a full queue")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		close(sender.Release)
+		<-done
+		mw.Close(context.Background())
+
+		if got := sender.events(); len(got) != 3 || mw.LogStats().Dropped != 0 {
+			t.Errorf("Expected all 3 events sent, got %v", got)
+		}
+	})
+
+	t.Run("drop events after close", func(t *testing.T) {
+		sender := &MockBatchSender{}
+		mw := NewMiddleware(sender, &MockWAF{}, Config{})
+
+		mw.Close(context.Background())
+		mw.sendAsyncPayload(protocol.AnalysisRequest{Log: "late"})
+
+		if stats := mw.LogStats(); stats.Dropped != 1 || len(sender.events()) != 0 {
+			t.Errorf("Expected the late event dropped, got %+v", stats)
+		}
+	})
+
+	t.Run("count failed sends", func(t *testing.T) {
+		sender := &MockBatchSender{Err: errors.New("api down")}
+		mw := NewMiddleware(sender, &MockWAF{}, Config{})
+
+		mw.sendAsyncPayload(protocol.AnalysisRequest{Log: "a"})
+		mw.Close(context.Background())
+
+		if failed := mw.LogStats().Failed; failed != 1 {
+			t.Errorf("Expected 1 failed event, got %d", failed)
+		}
+	})
+
+	t.Run("send one by one without batch support", func(t *testing.T) {
+		sender := &MockSender{CallSignal: make(chan struct{}, 1)}
+		mw := NewMiddleware(sender, &MockWAF{}, Config{Mode: LatencyFirst})
+
+		handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		mw.Close(context.Background())
+
+		select {
+		case <-sender.CallSignal:
+		default:
+			t.Error("Expected the event sent through SendAnalysis")
+		}
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
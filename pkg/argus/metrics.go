@@ -0,0 +1,278 @@
+package argus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLatencyBuckets are the upper bounds, in seconds, of the AI latency
+// histogram.
+var defaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics counts what the middlewares sharing it do, and serves the counts in
+// the Prometheus text exposition format. Set it on Config.Metrics, usually
+// one Metrics for every middleware of a process. The route label is the Path
+// of the RoutePolicy a request matched, or "default", which keeps raw paths
+// out of the label set.
+type Metrics struct {
+	requests      *metricVec
+	wafInterrupts *metricVec
+	aiVerdicts    *metricVec
+	aiLatency     *metricVec
+	breakers      *metricVec
+	droppedLogs   *metricVec
+	fallbacks     *metricVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: newCounterVec("argus_requests_total",
+			"Requests inspected by the middleware.", "mode", "route"),
+		wafInterrupts: newCounterVec("argus_waf_interrupts_total",
+			"Requests the WAF blocked, by CRS rule family of the matched rules.", "mode", "route", "family"),
+		aiVerdicts: newCounterVec("argus_ai_verdicts_total",
+			"Sync AI analysis verdicts.", "mode", "route", "verdict"),
+		aiLatency: newHistogramVec("argus_ai_latency_seconds",
+			"Duration of sync AI analyses, failed ones included.", defaultLatencyBuckets, "mode", "route"),
+		breakers: newCounterVec("argus_breaker_transitions_total",
+			"Circuit breaker state transitions.", "mode", "breaker", "from", "to"),
+		droppedLogs: newCounterVec("argus_async_logs_dropped_total",
+			"Async events dropped by a full or closed log queue.", "mode"),
+		fallbacks: newCounterVec("argus_fallbacks_total",
+			"Requests decided by the failure policy.", "mode", "route", "reason", "decision"),
+	}
+}
+
+// ServeHTTP writes the metrics in the text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, vec := range []*metricVec{m.requests, m.wafInterrupts, m.aiVerdicts, m.aiLatency, m.breakers, m.droppedLogs, m.fallbacks} {
+		vec.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// The observe methods do nothing on a nil Metrics, which is how the
+// middleware runs without metrics.
+
+func (m *Metrics) observeRequest(mode SecurityMode, route string) {
+	if m == nil {
+		return
+	}
+	m.requests.add(1, string(mode), route)
+}
+
+func (m *Metrics) observeWAFInterrupt(mode SecurityMode, route string, result WAFResult) {
+	if m == nil || !result.Blocked {
+		return
+	}
+	var families []string
+	for _, match := range result.Matches {
+		family := ruleFamilyLabel(match.RuleID)
+		if family != "" && !slices.Contains(families, family) {
+			families = append(families, family)
+		}
+	}
+	if len(families) == 0 {
+		families = []string{"unknown"}
+	}
+	for _, family := range families {
+		m.wafInterrupts.add(1, string(mode), route, family)
+	}
+}
+
+func (m *Metrics) observeAnalysis(mode SecurityMode, route string, elapsed time.Duration, verdict string) {
+	if m == nil {
+		return
+	}
+	m.aiLatency.observe(elapsed.Seconds(), string(mode), route)
+	if verdict != "" {
+		m.aiVerdicts.add(1, string(mode), route, verdict)
+	}
+}
+
+func (m *Metrics) observeBreaker(mode SecurityMode, name string, from, to BreakerState) {
+	if m == nil {
+		return
+	}
+	m.breakers.add(1, string(mode), name, string(from), string(to))
+}
+
+// observedBy returns c with its transitions also counted by metrics.
+func (c BreakerConfig) observedBy(metrics *Metrics, mode SecurityMode) BreakerConfig {
+	if metrics == nil {
+		return c
+	}
+	onStateChange := c.OnStateChange
+	c.OnStateChange = func(name string, from, to BreakerState) {
+		metrics.observeBreaker(mode, name, from, to)
+		if onStateChange != nil {
+			onStateChange(name, from, to)
+		}
+	}
+	return c
+}
+
+func (m *Metrics) observeDroppedLog(mode SecurityMode) {
+	if m == nil {
+		return
+	}
+	m.droppedLogs.add(1, string(mode))
+}
+
+func (m *Metrics) observeFallback(mode SecurityMode, route, reason, decision string) {
+	if m == nil {
+		return
+	}
+	m.fallbacks.add(1, string(mode), route, reason, decision)
+}
+
+// ruleFamilyLabel returns the CRS family of a rule ID, e.g. 942 for 942100.
+// The anomaly evaluation rules that do the blocking are left out.
+func ruleFamilyLabel(id int) string {
+	family := id / 1000
+	switch {
+	case family < 900 || family > 999:
+		return "custom"
+	case family == 949 || family == 959:
+		return ""
+	}
+	return strconv.Itoa(family)
+}
+
+type metricKind string
+
+const (
+	counterKind   metricKind = "counter"
+	histogramKind metricKind = "histogram"
+)
+
+// metricVec is a counter or histogram with one series per label values.
+type metricVec struct {
+	name    string
+	help    string
+	kind    metricKind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	// value is the count of a counter, sum the sum of a histogram
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+func newCounterVec(name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: counterKind, labels: labels, series: make(map[string]*series)}
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: histogramKind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+}
+
+func (v *metricVec) get(values []string) *series {
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{values: values, counts: make([]uint64, len(v.buckets))}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *metricVec) add(delta float64, values ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.get(values).value += delta
+}
+
+func (v *metricVec) observe(value float64, values ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s := v.get(values)
+	for i, bound := range v.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.value += value
+	s.count++
+}
+
+func (v *metricVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := v.series[key]
+		labels := v.labelPairs(s.values)
+		if v.kind == counterKind {
+			fmt.Fprintf(w, "%s{%s} %s\n", v.name, labels, formatFloat(s.value))
+			continue
+		}
+		for i, bound := range v.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", v.name, labels, formatFloat(bound), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", v.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", v.name, labels, formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", v.name, labels, s.count)
+	}
+}
+
+func (v *metricVec) labelPairs(values []string) string {
+	pairs := make([]string, len(v.labels))
+	for i, label := range v.labels {
+		pairs[i] = label + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,141 @@
+package argus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+func scrape(t *testing.T, metrics *Metrics) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Expected the text exposition format, got %s", ct)
+	}
+	return rec.Body.String()
+}
+
+func expectLines(t *testing.T, body string, lines ...string) {
+	t.Helper()
+
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected line %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestMiddlewareMetrics(t *testing.T) {
+	t.Run("WAF interrupts by rule family", func(t *testing.T) {
+		metrics := NewMetrics()
+		waf := &MockWAF{BlockRequest: true, Matches: []RuleMatch{{RuleID: 942100}, {RuleID: 942190}, {RuleID: 949110}}}
+		mw := NewMiddleware(&MockSender{}, waf, Config{Mode: LatencyFirst, Metrics: metrics})
+
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+			ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		mw.Close(context.Background())
+
+		expectLines(t, scrape(t, metrics),
+			`argus_requests_total{mode="LATENCY_FIRST",route="default"} 1`,
+			`argus_waf_interrupts_total{mode="LATENCY_FIRST",route="default",family="942"} 1`,
+		)
+	})
+
+	t.Run("AI verdicts and latency by route policy", func(t *testing.T) {
+		metrics := NewMetrics()
+		isThreat := true
+		sender := &MockSender{Response: protocol.AnalysisResponse{IsThreat: &isThreat}}
+		mw := NewMiddleware(sender, &MockWAF{}, Config{
+			Mode:     SmartShield,
+			Metrics:  metrics,
+			Policies: []RoutePolicy{{Path: "/login", Mode: Paranoid}},
+		})
+
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+			ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil))
+		mw.Close(context.Background())
+
+		expectLines(t, scrape(t, metrics),
+			`argus_requests_total{mode="PARANOID",route="/login"} 1`,
+			`argus_ai_verdicts_total{mode="PARANOID",route="/login",verdict="threat"} 1`,
+			`argus_ai_latency_seconds_bucket{mode="PARANOID",route="/login",le="+Inf"} 1`,
+			`argus_ai_latency_seconds_count{mode="PARANOID",route="/login"} 1`,
+		)
+	})
+
+	t.Run("Fallbacks and breaker transitions", func(t *testing.T) {
+		metrics := NewMetrics()
+		sender := &MockBatchSender{MockSender: MockSender{Err: errors.New("api down")}}
+		mw := NewMiddleware(sender, &MockWAF{BlockRequest: true}, Config{
+			Mode:    Paranoid,
+			Metrics: metrics,
+			Breaker: BreakerConfig{MaxConsecutiveFailures: 1},
+		})
+
+		handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		for range 2 {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+		}
+		mw.Close(context.Background())
+
+		expectLines(t, scrape(t, metrics),
+			`argus_fallbacks_total{mode="PARANOID",route="default",reason="analysis_error",decision="block"} 2`,
+			`argus_breaker_transitions_total{mode="PARANOID",breaker="argus-api-breaker",from="CLOSED",to="OPEN"} 1`,
+		)
+	})
+
+	t.Run("Dropped async logs", func(t *testing.T) {
+		metrics := NewMetrics()
+		mw := NewMiddleware(&MockBatchSender{}, &MockWAF{}, Config{Mode: Shadow, Metrics: metrics})
+
+		mw.Close(context.Background())
+		mw.sendAsyncPayload(protocol.AnalysisRequest{Log: "late"})
+
+		expectLines(t, scrape(t, metrics), `argus_async_logs_dropped_total{mode="SHADOW"} 1`)
+	})
+}
+
+func TestMetricsExposition(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.observeRequest(SmartShield, `/a"b\c`)
+	metrics.aiLatency.observe(0.03, "SMART_SHIELD", "default")
+
+	body := scrape(t, metrics)
+	expectLines(t, body,
+		"# TYPE argus_requests_total counter",
+		"# TYPE argus_ai_latency_seconds histogram",
+		`argus_requests_total{mode="SMART_SHIELD",route="/a\"b\\c"} 1`,
+		`argus_ai_latency_seconds_bucket{mode="SMART_SHIELD",route="default",le="0.025"} 0`,
+		`argus_ai_latency_seconds_bucket{mode="SMART_SHIELD",route="default",le="0.05"} 1`,
+		`argus_ai_latency_seconds_sum{mode="SMART_SHIELD",route="default"} 0.03`,
+	)
+
+	var nilMetrics *Metrics
+	nilMetrics.observeRequest(SmartShield, "default")
+}
+
+func TestRuleFamilyLabel(t *testing.T) {
+	tests := []struct {
+		id   int
+		want string
+	}{
+		{942100, "942"},
+		{930120, "930"},
+		{949110, ""},
+		{959100, ""},
+		{1001, "custom"},
+	}
+
+	for _, tt := range tests {
+		if got := ruleFamilyLabel(tt.id); got != tt.want {
+			t.Errorf("ruleFamilyLabel(%d): expected %q, got %q", tt.id, tt.want, got)
+		}
+	}
+}
@@ -1,144 +1,560 @@
 package argus
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/priyansh-dimri/argus/pkg/protocol"
+	"github.com/priyansh-dimri/argus/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Middleware struct {
-	Client  AnalysisSender
-	WAF     RuleEngine
-	Breaker *Breaker
-	Config  Config
+	Client AnalysisSender
+	WAF    RuleEngine
+	// Breaker guards sync analyses, LogBreaker the async events, so failing
+	// logs cannot trip the breaker blocking modes depend on. A nil LogBreaker
+	// shares Breaker.
+	Breaker    *Breaker
+	LogBreaker *Breaker
+	Config     Config
+
+	// OnWAFMatch is called when WAF rules match a request, OnAIVerdict when a
+	// sync analysis answers, OnBlock before a block response is written and
+	// OnFallback when the failure policy decides a request.
+	OnWAFMatch  Hook
+	OnAIVerdict Hook
+	OnBlock     Hook
+	OnFallback  Hook
+
+	// logs queues async events, nil on a Middleware not built by NewMiddleware
+	logs *logQueue
+	// route is the Path of the RoutePolicy the middleware copy serves
+	route string
 }
 
 func NewMiddleware(client AnalysisSender, waf RuleEngine, config Config) *Middleware {
-	return &Middleware{
-		Client:  client,
-		WAF:     waf,
-		Breaker: NewBreaker("argus-api-breaker"),
-		Config:  config,
+	m := &Middleware{Client: client, WAF: waf, Config: config}
+	logBreaker := config.LogBreaker.withDefaults(config.Breaker)
+	m.Breaker = NewBreakerWithConfig("argus-api-breaker", config.Breaker.observedBy(config.Metrics, m.mode()))
+	m.LogBreaker = NewBreakerWithConfig("argus-log-breaker", logBreaker.observedBy(config.Metrics, m.mode()))
+	m.logs = newLogQueue(config.LogQueue, m.sendBatch)
+	if config.Metrics != nil {
+		m.logs.onDrop = func() { config.Metrics.observeDroppedLog(m.mode()) }
 	}
+	return m
 }
 
 func (m *Middleware) Protect(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var bodyBytes []byte
-		if r.Body != nil {
-			bodyBytes, _ = io.ReadAll(r.Body)
-			r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		ctx, span, mw := m.start(r.Context(), r.Method, r.URL.Path)
+		defer span.End()
+		mw.serve(w, r.WithContext(ctx), next)
+	})
+}
+
+// start begins the span of a request and returns its context, carrying the
+// request ID and the Verdict, with the middleware that serves it.
+func (m *Middleware) start(ctx context.Context, method, path string) (context.Context, trace.Span, *Middleware) {
+	ctx, span := tracer().Start(ctx, "argus.Protect", trace.WithAttributes(
+		attribute.String("http.request.method", method),
+		attribute.String("url.path", path),
+	))
+
+	ctx = withRequestID(ctx)
+	mw := m.forRoute(method, path)
+	ctx = withVerdict(ctx, mw.mode())
+	span.SetAttributes(
+		attribute.String("argus.mode", string(mw.mode())),
+		attribute.String("argus.route", mw.routeLabel()),
+		attribute.String("argus.request_id", RequestIDFromContext(ctx)),
+	)
+	return ctx, span, mw
+}
+
+// mode is the mode the middleware runs, SmartShield when Config leaves it empty.
+func (m *Middleware) mode() SecurityMode {
+	return m.Config.mode()
+}
+
+// routeLabel names the route policy of the request in metrics.
+func (m *Middleware) routeLabel() string {
+	if m.route == "" {
+		return "default"
+	}
+	return m.route
+}
+
+// bodyLimits returns the inspected body size, the part of it held in memory
+// and what to do with bodies over the size.
+func (m *Middleware) bodyLimits() (limit, memory int64, action BodyLimitAction) {
+	limit = m.Config.MaxBodySize
+	if limit <= 0 {
+		limit = defaultMaxBodySize
+	}
+	memory = m.Config.MaxBodyMemory
+	if memory <= 0 {
+		memory = defaultMaxBodyMemory
+	}
+	action = m.Config.BodyLimitAction
+	if m.Config.Mode == Shadow && action == BodyLimitReject {
+		// Shadow never refuses a request, an oversized body goes uninspected
+		action = BodyLimitPassThrough
+	}
+	return limit, memory, action
+}
+
+func (m *Middleware) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	m.Config.Metrics.observeRequest(m.mode(), m.routeLabel())
+
+	limit, memory, action := m.bodyLimits()
+	shadow := m.Config.Mode == Shadow
+
+	if action == BodyLimitReject && r.ContentLength > limit {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var bodyBytes []byte
+	var body *requestBody
+	getBody := r.GetBody
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = readRequestBody(r.Body, limit, memory)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
 		}
+		defer body.Close()
 
-		resetBody := func() {
-			if bodyBytes != nil {
-				r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		if body.overflow && action == BodyLimitReject {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Rules and analysis run on the decoded body, the upstream still
+		// gets the original bytes.
+		inspected := body
+		if encodings := contentEncodings(r.Header); len(encodings) > 0 {
+			inspected, err = decodeRequestBody(body, encodings, m.Config.MaxDecompressionRatio, memory)
+			switch {
+			case err != nil && shadow:
+				inspected = nil
+			case errors.Is(err, errUnsupportedEncoding):
+				http.Error(w, "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+				return
+			case errors.Is(err, errDecompressionBomb):
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			case err != nil:
+				http.Error(w, "Malformed request body encoding", http.StatusBadRequest)
+				return
+			}
+			if inspected != nil {
+				defer inspected.Close()
+
+				if inspected.overflow && action == BodyLimitReject {
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
 			}
 		}
 
-		wafResult, _ := m.WAF.Check(r)
+		if inspected == nil || inspected.overflow && action == BodyLimitPassThrough {
+			r.Body, r.GetBody = http.NoBody, nil
+		} else {
+			bodyBytes = inspected.Payload()
+			r.Body = inspected.Inspected()
+			r.GetBody = func() (io.ReadCloser, error) { return inspected.Inspected(), nil }
+		}
+	}
 
-		resetBody()
+	resetBody := func() {
+		if body != nil {
+			r.Body, r.GetBody = body.Replay(), getBody
+		}
+	}
+
+	wafReq := r
+	if g := m.Config.GraphQL.detect(r.Method, r.URL.Path, r.Header.Get("Content-Type"), r.URL.Query(), bodyBytes); g != nil {
+		g.annotate(r.Context())
+		wafReq = g.wafRequest(r)
+	}
 
-		switch m.Config.Mode {
-		case LatencyFirst:
-			m.handleLatencyFirst(w, r, next, wafResult, bodyBytes)
-		case Paranoid:
-			m.handleParanoid(w, r, next, wafResult, bodyBytes)
-		case SmartShield:
-			fallthrough
-		default:
-			m.handleSmartShield(w, r, next, wafResult, bodyBytes)
+	_, wafSpan := tracer().Start(r.Context(), "argus.waf.Check")
+	var wafResult WAFResult
+	var wafErr error
+	if engine, ok := m.WAF.(ResponseRuleEngine); ok && m.Config.InspectResponses {
+		var check ResponseCheck
+		wafResult, check, wafErr = engine.CheckRequest(wafReq)
+		if check != nil {
+			defer check.Close()
+			next = m.inspectResponse(next, check)
 		}
-	})
+	} else {
+		wafResult, wafErr = m.WAF.Check(wafReq)
+	}
+	endWAFSpan(wafSpan, wafResult, wafErr)
+
+	resetBody()
+	if m.Config.WebSocket.Inspect && isWebSocketUpgrade(r) {
+		r.Header.Del("Sec-WebSocket-Extensions")
+		w = &websocketWriter{ResponseWriter: w, m: m, r: r}
+	}
+	m.decide(&httpInbound{w: w, r: r, next: next}, wafResult, wafErr, bodyBytes)
 }
 
-func (m *Middleware) handleLatencyFirst(w http.ResponseWriter, r *http.Request, next http.Handler, wafBlocked bool, body []byte) {
-	if wafBlocked {
-		go m.sendAsyncLog(r, body, wafBlocked)
-		http.Error(w, "Blocked by Argus Shield", http.StatusForbidden)
+func endWAFSpan(span trace.Span, result WAFResult, err error) {
+	span.SetAttributes(
+		attribute.Bool("argus.waf.blocked", result.Blocked),
+		attribute.Int("argus.waf.anomaly_score", result.AnomalyScore),
+		attribute.IntSlice("argus.waf.rule_ids", result.RuleIDs()),
+	)
+	tracing.End(span, err)
+}
+
+// decide runs the mode on a request past its WAF check, however it arrived.
+func (m *Middleware) decide(in inbound, wafResult WAFResult, wafErr error, body []byte) {
+	m.Config.Metrics.observeWAFInterrupt(m.mode(), m.routeLabel(), wafResult)
+	verdictOf(in.Context()).WAF = wafResult
+	if wafResult.Blocked || len(wafResult.Matches) > 0 {
+		fire(m.OnWAFMatch, in)
+	}
+
+	if g := verdictOf(in.Context()).GraphQL; g != nil && g.Violation != "" && m.Config.Mode != Shadow {
+		m.sendAsyncLog(in, body, wafResult)
+		m.block(in, "Blocked by Argus GraphQL limits: "+g.Violation)
 		return
 	}
-	go m.sendAsyncLog(r, body, wafBlocked)
-	next.ServeHTTP(w, r)
+
+	if wafErr != nil && m.Config.Mode != Shadow && m.handleWAFFailure(in, body, wafErr) {
+		return
+	}
+
+	switch m.Config.Mode {
+	case LatencyFirst:
+		m.handleLatencyFirst(in, wafResult, body)
+	case Paranoid:
+		m.handleParanoid(in, wafResult, body)
+	case Shadow:
+		m.handleShadow(in, wafResult, wafErr, body)
+	case SmartShield:
+		fallthrough
+	default:
+		m.handleSmartShield(in, wafResult, body)
+	}
 }
 
-func (m *Middleware) handleSmartShield(w http.ResponseWriter, r *http.Request, next http.Handler, wafBlocked bool, body []byte) {
-	if !wafBlocked {
-		go m.sendAsyncLog(r, body, wafBlocked)
-		next.ServeHTTP(w, r)
+func (m *Middleware) handleLatencyFirst(in inbound, wafResult WAFResult, body []byte) {
+	if wafResult.Blocked {
+		m.sendAsyncLog(in, body, wafResult)
+		m.block(in, "Blocked by Argus Shield")
 		return
 	}
+	m.sendAsyncLog(in, body, wafResult)
+	in.pass()
+}
 
-	resp, err := m.sendSyncAnalysis(r, body, wafBlocked)
+func (m *Middleware) handleSmartShield(in inbound, wafResult WAFResult, body []byte) {
+	if !wafResult.Blocked {
+		m.sendAsyncLog(in, body, wafResult)
+		in.pass()
+		return
+	}
 
-	isThreat := resp.IsThreat != nil && *resp.IsThreat
+	resp, err := m.sendSyncAnalysis(in, body, wafResult)
+	if in.Context().Err() != nil {
+		// The client is gone, nobody reads the answer
+		return
+	}
+	if err != nil {
+		m.handleAnalysisFailure(in, wafResult, body, err, "Blocked by Argus Smart Shield")
+		return
+	}
 
-	if err != nil || !isThreat {
-		next.ServeHTTP(w, r)
+	if resp.IsThreat == nil || !*resp.IsThreat {
+		in.pass()
 		return
 	}
 
-	http.Error(w, "Blocked by Argus Smart Shield", http.StatusForbidden)
+	m.block(in, "Blocked by Argus Smart Shield")
 }
 
-func (m *Middleware) handleParanoid(w http.ResponseWriter, r *http.Request, next http.Handler, wafBlocked bool, body []byte) {
-	resp, err := m.sendSyncAnalysis(r, body, wafBlocked)
-
-	isThreat := resp.IsThreat != nil && *resp.IsThreat
+func (m *Middleware) handleParanoid(in inbound, wafResult WAFResult, body []byte) {
+	resp, err := m.sendSyncAnalysis(in, body, wafResult)
+	if in.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		m.handleAnalysisFailure(in, wafResult, body, err, "Blocked by Argus Paranoid Shield")
+		return
+	}
 
-	if (err == nil && isThreat) || (err != nil && wafBlocked) {
-		http.Error(w, "Blocked by Argus Paranoid Shield", http.StatusForbidden)
+	if resp.IsThreat != nil && *resp.IsThreat {
+		m.block(in, "Blocked by Argus Paranoid Shield")
 		return
 	}
 
-	next.ServeHTTP(w, r)
+	in.pass()
+}
+
+// handleShadow serves the request whatever the shadowed mode would do, then
+// reports it once through the async path. The payload carries that mode and
+// would_block, or protocol.WouldBlockIfThreat when the verdict of the
+// analysis the backend runs on the report decides it.
+func (m *Middleware) handleShadow(in inbound, wafResult WAFResult, wafErr error, body []byte) {
+	req := m.buildPayload(in, body, wafResult)
+	req.MetaData["shadow_mode"] = string(m.Config.decidingMode())
+	req.MetaData["would_block"] = m.shadowBlocks(in, wafResult, wafErr, req.MetaData)
+
+	in.pass()
+	m.sendAsyncPayload(req)
 }
 
-func (m *Middleware) buildPayload(r *http.Request, body []byte, wafBlocked bool) protocol.AnalysisRequest {
-	headers := make(map[string]string)
-	for k, v := range r.Header {
-		if len(v) > 0 {
-			headers[k] = v[0]
+// shadowBlocks reports whether the shadowed mode would have blocked the
+// request, or answered it with a 503, as far as it can tell without an
+// analysis.
+func (m *Middleware) shadowBlocks(in inbound, wafResult WAFResult, wafErr error, meta map[string]string) string {
+	if g := verdictOf(in.Context()).GraphQL; g != nil && g.Violation != "" {
+		return "true"
+	}
+	if wafErr != nil {
+		policy, decision := m.Config.wafFallback()
+		m.noteFallback(in, meta, errors.Join(errWAFCheck, wafErr), policy, decision)
+		if decision != "continue" {
+			return "true"
 		}
 	}
-	headers["Method"] = r.Method
+
+	switch m.Config.decidingMode() {
+	case LatencyFirst:
+		return strconv.FormatBool(wafResult.Blocked)
+	case SmartShield:
+		if !wafResult.Blocked {
+			return "false"
+		}
+	}
+	return protocol.WouldBlockIfThreat
+}
+
+func (m *Middleware) buildPayload(in inbound, body []byte, wafResult WAFResult) protocol.AnalysisRequest {
+	red := m.Config.Redaction.start()
+	headers := make(protocol.Headers)
+	var cookies []string
+	var contentType string
+	in.visitHeaders(func(name, value string) {
+		masked := red.header(name, value)
+		headers[name] = append(headers[name], masked)
+		switch http.CanonicalHeaderKey(name) {
+		case "Cookie":
+			cookies = append(cookies, value)
+		case "Content-Type":
+			if contentType == "" {
+				contentType = masked
+			}
+		}
+	})
 
 	meta := map[string]string{
-		"waf_result": "PASS",
+		"waf_result":        "PASS",
+		"waf_anomaly_score": strconv.Itoa(wafResult.AnomalyScore),
 	}
-	if wafBlocked {
+	if wafResult.Blocked {
 		meta["waf_result"] = "BLOCK"
 	}
+	requestID := RequestIDFromContext(in.Context())
+	if requestID != "" {
+		meta["request_id"] = requestID
+	}
+	if len(wafResult.Matches) > 0 {
+		ids := make([]string, 0, len(wafResult.Matches))
+		for _, id := range wafResult.RuleIDs() {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		meta["waf_rule_ids"] = strings.Join(ids, ",")
+		meta["waf_tags"] = strings.Join(wafResult.AttackTags(), ",")
+
+		if matches, err := json.Marshal(red.matches(wafResult.Matches)); err == nil {
+			meta["waf_matches"] = string(matches)
+		}
+	}
+	if g := verdictOf(in.Context()).GraphQL; g != nil {
+		meta["graphql_operations"] = strings.Join(g.Operations, ",")
+		meta["graphql_depth"] = strconv.Itoa(g.Depth)
+		meta["graphql_aliases"] = strconv.Itoa(g.Aliases)
+		meta["graphql_complexity"] = strconv.Itoa(g.Complexity)
+		if g.Introspection {
+			meta["graphql_introspection"] = "true"
+		}
+		if g.Violation != "" {
+			meta["graphql_violation"] = g.Violation
+		}
+		if len(g.Arguments) > 0 {
+			if args, err := json.Marshal(red.arguments(g.Arguments)); err == nil {
+				meta["graphql_arguments"] = string(args)
+			}
+		}
+		red.graphql(g.literals)
+	}
 
-	return protocol.AnalysisRequest{
-		Log:      string(body),
-		IP:       r.RemoteAddr,
-		Route:    r.URL.Path,
-		Headers:  headers,
-		MetaData: meta,
+	req := protocol.AnalysisRequest{
+		Version:     protocol.Version2,
+		RequestID:   requestID,
+		Timestamp:   time.Now().UTC(),
+		Method:      in.method(),
+		Route:       in.path(),
+		Query:       red.query(in.query(), "query"),
+		IP:          in.remoteAddr(),
+		Headers:     headers,
+		Cookies:     red.cookies(cookies),
+		ContentType: contentType,
+		Log:         red.requestBody(body, contentType),
+		MetaData:    meta,
 	}
+	red.record(meta)
+	return req
 }
 
-func (m *Middleware) sendAsyncLog(r *http.Request, body []byte, wafBlocked bool) {
-	req := m.buildPayload(r, body, wafBlocked)
-	m.Breaker.Execute(func() (any, error) {
-		return m.Client.SendAnalysis(req)
-	})
+func (m *Middleware) sendAsyncLog(in inbound, body []byte, wafResult WAFResult) {
+	m.sendAsyncPayload(m.buildPayload(in, body, wafResult))
 }
 
-func (m *Middleware) sendSyncAnalysis(r *http.Request, body []byte, wafBlocked bool) (protocol.AnalysisResponse, error) {
-	req := m.buildPayload(r, body, wafBlocked)
+// sendAsyncPayload queues req for the log workers without waiting for it
+// to be sent.
+func (m *Middleware) sendAsyncPayload(req protocol.AnalysisRequest) {
+	if m.logs == nil {
+		go m.sendBatch([]protocol.AnalysisRequest{req})
+		return
+	}
+	m.logs.enqueue(req)
+}
 
+func (m *Middleware) sendBatch(batch []protocol.AnalysisRequest) error {
+	breaker := m.LogBreaker
+	if breaker == nil {
+		breaker = m.Breaker
+	}
+
+	if sender, ok := m.Client.(BatchSender); ok {
+		_, err := breaker.Execute(func() (any, error) {
+			return nil, sender.SendAnalysisBatch(batch)
+		})
+		return err
+	}
+
+	var failed error
+	for _, req := range batch {
+		_, err := breaker.Execute(func() (any, error) {
+			return m.Client.SendAnalysis(req)
+		})
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// Flush waits until every queued async event has been sent or dropped.
+func (m *Middleware) Flush(ctx context.Context) error {
+	if m.logs == nil {
+		return nil
+	}
+	return m.logs.flush(ctx)
+}
+
+// Close stops queueing async events and waits until the queued ones are
+// sent. Events logged after Close are dropped.
+func (m *Middleware) Close(ctx context.Context) error {
+	if m.logs == nil {
+		return nil
+	}
+	return m.logs.close(ctx)
+}
+
+// LogStats reports how many async events are queued, sent, failed and dropped.
+func (m *Middleware) LogStats() LogStats {
+	if m.logs == nil {
+		return LogStats{}
+	}
+	return m.logs.stats()
+}
+
+// errLatencyBudget marks a sync analysis cut short by the latency budget of
+// the mode. The middleware then goes with the WAF verdict, unless a failure
+// policy is configured for the mode, and the breaker doesn't count it.
+var errLatencyBudget = errors.New("latency budget exceeded")
+
+func (m *Middleware) sendSyncAnalysis(in inbound, body []byte, wafResult WAFResult) (protocol.AnalysisResponse, error) {
+	req := m.buildPayload(in, body, wafResult)
+
+	ctx := in.Context()
+	budget := m.Config.LatencyBudgets[m.Config.decidingMode()]
+	if budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+
+	start := time.Now()
 	result, err := m.Breaker.Execute(func() (any, error) {
-		return m.Client.SendAnalysis(req)
+		resp, err := m.sendContext(ctx, req)
+		if err != nil && budget > 0 && in.Context().Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w after %s: %w", errLatencyBudget, budget, err)
+		}
+		return resp, err
 	})
+	verdict := ""
+	if err == nil {
+		verdict = "safe"
+		if isThreat := result.(protocol.AnalysisResponse).IsThreat; isThreat != nil && *isThreat {
+			verdict = "threat"
+		}
+	}
+	m.Config.Metrics.observeAnalysis(m.mode(), m.routeLabel(), time.Since(start), verdict)
+	if verdict != "" {
+		trace.SpanFromContext(in.Context()).SetAttributes(attribute.String("argus.ai.verdict", verdict))
+	}
 
 	if err != nil {
 		return protocol.AnalysisResponse{}, err
 	}
 
-	return result.(protocol.AnalysisResponse), nil
+	resp := result.(protocol.AnalysisResponse)
+	v := verdictOf(in.Context())
+	v.Analysis, v.Analyzed = resp, true
+	fire(m.OnAIVerdict, in)
+	return resp, nil
+}
+
+// sendContext stops waiting for the analysis once ctx is done. Senders that
+// are not a ContextSender keep running in the background.
+func (m *Middleware) sendContext(ctx context.Context, req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	if sender, ok := m.Client.(ContextSender); ok {
+		return sender.SendAnalysisContext(ctx, req)
+	}
+
+	type result struct {
+		resp protocol.AnalysisResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := m.Client.SendAnalysis(req)
+		done <- result{resp, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.resp, res.err
+	case <-ctx.Done():
+		return protocol.AnalysisResponse{}, ctx.Err()
+	}
 }
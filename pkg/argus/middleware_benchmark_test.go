@@ -19,8 +19,8 @@ type benchmarkWAF struct {
 	blockRequest bool
 }
 
-func (w *benchmarkWAF) Check(r *http.Request) (bool, error) {
-	return w.blockRequest, nil
+func (w *benchmarkWAF) Check(r *http.Request) (WAFResult, error) {
+	return WAFResult{Blocked: w.blockRequest}, nil
 }
 
 type benchmarkSender struct {
@@ -269,7 +269,7 @@ func BenchmarkMiddleware_BuildPayload_Isolated(b *testing.B) {
 	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = mw.buildPayload(req, body, false)
+		_ = mw.buildPayload(&httpInbound{r: req}, body, WAFResult{})
 	}
 }
 
@@ -1,9 +1,11 @@
 package argus
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -13,11 +15,12 @@ import (
 
 type MockWAF struct {
 	BlockRequest bool
+	Matches      []RuleMatch
 	Err          error
 }
 
-func (m *MockWAF) Check(r *http.Request) (bool, error) {
-	return m.BlockRequest, m.Err
+func (m *MockWAF) Check(r *http.Request) (WAFResult, error) {
+	return WAFResult{Blocked: m.BlockRequest, Matches: m.Matches}, m.Err
 }
 
 type MockSender struct {
@@ -235,6 +238,95 @@ func TestParanoidMiddleware(t *testing.T) {
 	})
 }
 
+func TestShadowMiddleware(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       Config
+		wafBlock     bool
+		wafErr       error
+		wantMode     string
+		wantBlock    string
+		wantDecision string
+	}{
+		{"LatencyFirst WAF block", Config{ShadowMode: LatencyFirst}, true, nil, "LATENCY_FIRST", "true", ""},
+		{"LatencyFirst WAF pass", Config{ShadowMode: LatencyFirst}, false, nil, "LATENCY_FIRST", "false", ""},
+		{"SmartShield by default leaves a WAF block to the AI", Config{}, true, nil, "SMART_SHIELD", protocol.WouldBlockIfThreat, ""},
+		{"SmartShield WAF pass", Config{ShadowMode: SmartShield}, false, nil, "SMART_SHIELD", "false", ""},
+		{"Paranoid leaves every request to the AI", Config{ShadowMode: Paranoid}, false, nil, "PARANOID", protocol.WouldBlockIfThreat, ""},
+		{"WAF failure policy of the shadowed mode",
+			Config{FailurePolicies: map[SecurityMode]FailurePolicy{SmartShield: FailClosed}},
+			false, errors.New("waf down"), "SMART_SHIELD", "true", "block"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &MockBatchSender{}
+			config := tt.config
+			config.Mode = Shadow
+			mw := NewMiddleware(sender, &MockWAF{BlockRequest: tt.wafBlock, Err: tt.wafErr}, config)
+
+			handlerCalled := false
+			handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerCalled = true
+			}))
+
+			req := httptest.NewRequest("POST", "/api", strings.NewReader("payload"))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			mw.Close(context.Background())
+
+			if !handlerCalled || rec.Code != http.StatusOK {
+				t.Fatalf("Shadow: request should always pass, got %d", rec.Code)
+			}
+			if sender.SentReq.Version != 0 {
+				t.Errorf("Shadow: expected no sync analysis, got %+v", sender.SentReq)
+			}
+			if len(sender.Batches) != 1 || len(sender.Batches[0]) != 1 {
+				t.Fatalf("Shadow: expected one async event, got %v", sender.Batches)
+			}
+			meta := sender.Batches[0][0].MetaData
+			if got := meta["shadow_mode"]; got != tt.wantMode {
+				t.Errorf("Expected shadow_mode %s, got %s", tt.wantMode, got)
+			}
+			if got := meta["would_block"]; got != tt.wantBlock {
+				t.Errorf("Expected would_block %s, got %s", tt.wantBlock, got)
+			}
+			if got := meta["fallback_decision"]; got != tt.wantDecision {
+				t.Errorf("Expected fallback_decision %q, got %q", tt.wantDecision, got)
+			}
+		})
+	}
+
+	t.Run("Does not wait on the analysis", func(t *testing.T) {
+		sender := &slowSender{Delay: time.Second}
+		mw := NewMiddleware(sender, &MockWAF{BlockRequest: true}, Config{Mode: Shadow, ShadowMode: Paranoid})
+
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest("POST", "/api", nil))
+
+		if rec.Code != http.StatusOK || time.Since(start) > 500*time.Millisecond {
+			t.Errorf("Expected the request to pass right away, got %d after %s", rec.Code, time.Since(start))
+		}
+	})
+
+	t.Run("Oversized body is not rejected", func(t *testing.T) {
+		mw := NewMiddleware(&MockSender{}, &MockWAF{}, Config{Mode: Shadow, MaxBodySize: 4, BodyLimitAction: BodyLimitReject})
+
+		handlerCalled := false
+		handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerCalled = true
+		}))
+
+		req := httptest.NewRequest("POST", "/api", strings.NewReader("way too large"))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if !handlerCalled {
+			t.Error("Shadow: oversized body should reach the handler")
+		}
+	})
+}
+
 func TestHeaderParsingInMiddleware(t *testing.T) {
 	waf := &MockWAF{BlockRequest: false}
 	sender := &MockSender{CallSignal: make(chan struct{}, 1)}
@@ -258,7 +350,7 @@ func TestHeaderParsingInMiddleware(t *testing.T) {
 	case <-sender.CallSignal:
 		headers := sender.SentReq.Headers
 
-		if val, ok := headers["User-Agent"]; !ok || val != "Go-Test-Client" {
+		if val, ok := headers["User-Agent"]; !ok || headers.Get("User-Agent") != "Go-Test-Client" {
 			t.Errorf("Expected User-Agent header to be captured, got: %s", val)
 		}
 
@@ -266,7 +358,7 @@ func TestHeaderParsingInMiddleware(t *testing.T) {
 			t.Error("Expected Empty-Slice-Header to be skipped (len=0), but it was present")
 		}
 
-		if val, ok := headers["Empty-String-Header"]; !ok || val != "" {
+		if val, ok := headers["Empty-String-Header"]; !ok || len(val) != 1 || val[0] != "" {
 			t.Errorf("Expected Empty-String-Header to be captured as empty string, got: %q", val)
 		}
 
@@ -275,6 +367,50 @@ func TestHeaderParsingInMiddleware(t *testing.T) {
 	}
 }
 
+func TestPayloadFields(t *testing.T) {
+	sender := &MockSender{CallSignal: make(chan struct{}, 1)}
+	mw := NewMiddleware(sender, &MockWAF{}, Config{Mode: LatencyFirst, Redaction: RedactionConfig{Headers: []string{}}})
+
+	req := httptest.NewRequest(http.MethodPut, "/items?id=1&id=2", strings.NewReader(`{"name":"a"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Add("Accept", "text/html")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Cookie", "session=abc; theme=dark")
+	req.Header.Add("Cookie", "session=other")
+
+	before := time.Now()
+	mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case <-sender.CallSignal:
+	case <-time.After(50 * time.Millisecond):
+		t.Fatal("Timeout waiting for async log")
+	}
+	got := sender.SentReq
+
+	if got.Version != protocol.Version2 || got.Method != http.MethodPut || got.Route != "/items" || got.Query != "id=1&id=2" {
+		t.Errorf("Expected a version 2 PUT of /items?id=1&id=2, got %d %s %s?%s", got.Version, got.Method, got.Route, got.Query)
+	}
+	if _, ok := got.Headers["Method"]; ok {
+		t.Error("Expected the method to be left out of the headers")
+	}
+	if want := []string{"text/html", "application/json"}; !reflect.DeepEqual(got.Headers["Accept"], want) {
+		t.Errorf("Expected Accept %q, got %q", want, got.Headers["Accept"])
+	}
+	if want := map[string]string{"session": "abc", "theme": "dark"}; !reflect.DeepEqual(got.Cookies, want) {
+		t.Errorf("Expected cookies %v, got %v", want, got.Cookies)
+	}
+	if got.ContentType != "application/json" {
+		t.Errorf("Expected content type application/json, got %q", got.ContentType)
+	}
+	if got.RequestID == "" || got.RequestID != got.MetaData["request_id"] {
+		t.Errorf("Expected the request ID %q, got %q", got.MetaData["request_id"], got.RequestID)
+	}
+	if got.Timestamp.Before(before) || got.Timestamp.After(time.Now()) {
+		t.Errorf("Expected a timestamp of the request, got %v", got.Timestamp)
+	}
+}
+
 func TestSyncAnalysisError(t *testing.T) {
 	t.Run("WAF Threat + AI Error -> Pass (Fail Open)", func(t *testing.T) {
 		waf := &MockWAF{BlockRequest: true}
@@ -299,3 +435,30 @@ func TestSyncAnalysisError(t *testing.T) {
 		}
 	})
 }
+
+func TestWAFResultInPayload(t *testing.T) {
+	waf := &MockWAF{
+		BlockRequest: true,
+		Matches: []RuleMatch{
+			{RuleID: 942100, Message: "SQL Injection Attack Detected via libinjection", Tags: []string{"attack-sqli", "OWASP_CRS"}},
+			{RuleID: 949110, Message: "Inbound Anomaly Score Exceeded", Tags: []string{"anomaly-evaluation"}},
+		},
+	}
+	isThreat := true
+	sender := &MockSender{Response: protocol.AnalysisResponse{IsThreat: &isThreat}}
+	mw := NewMiddleware(sender, waf, Config{Mode: SmartShield})
+
+	handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api", nil))
+
+	meta := sender.SentReq.MetaData
+	if meta["waf_rule_ids"] != "942100,949110" {
+		t.Errorf("Expected rule ids in metadata, got %q", meta["waf_rule_ids"])
+	}
+	if meta["waf_tags"] != "attack-sqli" {
+		t.Errorf("Expected attack tags in metadata, got %q", meta["waf_tags"])
+	}
+	if !strings.Contains(meta["waf_matches"], `"rule_id":942100`) {
+		t.Errorf("Expected serialized matches in metadata, got %q", meta["waf_matches"])
+	}
+}
@@ -0,0 +1,163 @@
+package argus
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+)
+
+// RoutePolicy overrides the middleware settings for matching requests, e.g.
+//
+//	{Path: "/login", Mode: Paranoid, FailurePolicy: FailClosed}
+//	{Method: "GET", Path: "/static/*", Mode: LatencyFirst}
+//
+// Zero fields keep the value of Config, which is also the policy of requests
+// no RoutePolicy matches.
+type RoutePolicy struct {
+	// Method matches the request method. Empty matches every method.
+	Method string
+	// Path matches the request path exactly, or as a prefix when it ends in *.
+	Path string
+
+	Mode            SecurityMode
+	MaxBodySize     int64
+	MaxBodyMemory   int64
+	BodyLimitAction BodyLimitAction
+	FailurePolicy   FailurePolicy
+	// LatencyBudget overrides the budget of the mode on this route.
+	LatencyBudget time.Duration
+
+	// RuleExclusions are compiled into the WAF by NewWAF. An exclusion without
+	// Method or Path takes the ones of the policy.
+	RuleExclusions []RuleExclusion
+}
+
+// PolicyMatch is how a request is matched against Config.Policies.
+type PolicyMatch string
+
+const (
+	// PolicyFirstMatch uses the first policy in the list that matches.
+	PolicyFirstMatch PolicyMatch = "FIRST_MATCH"
+	// PolicyMostSpecific uses the matching policy with the longest path,
+	// exact paths first, then the one that names a method.
+	PolicyMostSpecific PolicyMatch = "MOST_SPECIFIC"
+)
+
+func (p RoutePolicy) matches(method, path string) bool {
+	if p.Method != "" && !strings.EqualFold(p.Method, method) {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(p.Path, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return path == p.Path
+}
+
+func (p RoutePolicy) specificity() int {
+	score := len(p.Path) * 2
+	if !strings.HasSuffix(p.Path, "*") {
+		score += 1 << 20
+	}
+	if p.Method != "" {
+		score++
+	}
+	return score
+}
+
+// policyFor returns the policy of a request, or nil when it gets the plain
+// Config.
+func (c Config) policyFor(method, path string) *RoutePolicy {
+	var match *RoutePolicy
+	for i := range c.Policies {
+		p := &c.Policies[i]
+		if !p.matches(method, path) {
+			continue
+		}
+		if c.PolicyMatch != PolicyMostSpecific {
+			return p
+		}
+		if match == nil || p.specificity() > match.specificity() {
+			match = p
+		}
+	}
+	return match
+}
+
+// withPolicy returns config with the non zero settings of p applied.
+func (c Config) withPolicy(p *RoutePolicy) Config {
+	if p.Mode != "" {
+		c.Mode = p.Mode
+	}
+	if p.MaxBodySize != 0 {
+		c.MaxBodySize = p.MaxBodySize
+	}
+	if p.MaxBodyMemory != 0 {
+		c.MaxBodyMemory = p.MaxBodyMemory
+	}
+	if p.BodyLimitAction != "" {
+		c.BodyLimitAction = p.BodyLimitAction
+	}
+	if p.FailurePolicy != "" {
+		policies := make(map[SecurityMode]FailurePolicy, len(c.FailurePolicies)+1)
+		maps.Copy(policies, c.FailurePolicies)
+		policies[c.decidingMode()] = p.FailurePolicy
+		c.FailurePolicies = policies
+	}
+	if p.LatencyBudget != 0 {
+		budgets := make(map[SecurityMode]time.Duration, len(c.LatencyBudgets)+1)
+		maps.Copy(budgets, c.LatencyBudgets)
+		budgets[c.decidingMode()] = p.LatencyBudget
+		c.LatencyBudgets = budgets
+	}
+	return c
+}
+
+// forRoute returns the middleware that serves a request: m itself, or a copy
+// carrying the config of the route policy the request matches.
+func (m *Middleware) forRoute(method, path string) *Middleware {
+	p := m.Config.policyFor(method, path)
+	if p == nil {
+		return m
+	}
+	mw := *m
+	mw.Config = m.Config.withPolicy(p)
+	mw.route = p.Path
+	return &mw
+}
+
+// allRuleExclusions joins Config.RuleExclusions with the ones of the policies.
+func allRuleExclusions(config Config) []RuleExclusion {
+	exclusions := append([]RuleExclusion(nil), config.RuleExclusions...)
+	for _, p := range config.Policies {
+		for _, ex := range p.RuleExclusions {
+			if ex.Method == "" {
+				ex.Method = p.Method
+			}
+			if ex.Path == "" {
+				ex.Path = p.Path
+			}
+			exclusions = append(exclusions, ex)
+		}
+	}
+	return exclusions
+}
+
+// wafBodyLimit is the largest body any policy inspects, which Coraza has to
+// accept.
+func wafBodyLimit(config Config) (int64, error) {
+	if config.MaxBodySize < 0 {
+		return 0, fmt.Errorf("max body size must be positive, got %d", config.MaxBodySize)
+	}
+	limit := config.MaxBodySize
+	if limit == 0 {
+		limit = defaultMaxBodySize
+	}
+	for i, p := range config.Policies {
+		if p.MaxBodySize < 0 {
+			return 0, fmt.Errorf("policy %d: max body size must be positive, got %d", i, p.MaxBodySize)
+		}
+		limit = max(limit, p.MaxBodySize)
+	}
+	return limit, nil
+}
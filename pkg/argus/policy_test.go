@@ -0,0 +1,140 @@
+package argus
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPolicyFor(t *testing.T) {
+	policies := []RoutePolicy{
+		{Path: "/api/*", Mode: SmartShield},
+		{Method: "POST", Path: "/api/login", Mode: Paranoid},
+		{Path: "/api/login", Mode: LatencyFirst},
+		{Path: "/api/admin/*", Mode: Paranoid},
+	}
+
+	tests := []struct {
+		name     string
+		match    PolicyMatch
+		method   string
+		path     string
+		wantMode SecurityMode
+	}{
+		{"First match takes the list order", "", "POST", "/api/login", SmartShield},
+		{"Most specific prefers method and exact path", PolicyMostSpecific, "POST", "/api/login", Paranoid},
+		{"Most specific without method", PolicyMostSpecific, "GET", "/api/login", LatencyFirst},
+		{"Most specific prefers longer prefix", PolicyMostSpecific, "GET", "/api/admin/users", Paranoid},
+		{"No match uses the default", PolicyMostSpecific, "GET", "/static/app.js", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{Policies: policies, PolicyMatch: tt.match}
+			p := config.policyFor(tt.method, tt.path)
+
+			var mode SecurityMode
+			if p != nil {
+				mode = p.Mode
+			}
+			if mode != tt.wantMode {
+				t.Errorf("Expected mode %q, got %q", tt.wantMode, mode)
+			}
+		})
+	}
+}
+
+func TestRoutePolicies(t *testing.T) {
+	config := Config{
+		Mode: LatencyFirst,
+		Policies: []RoutePolicy{
+			{Path: "/login", Mode: Paranoid, FailurePolicy: FailClosed},
+			{Path: "/upload/*", MaxBodySize: 4, BodyLimitAction: BodyLimitReject},
+		},
+	}
+
+	t.Run("Route gets its own mode and failure policy", func(t *testing.T) {
+		sender := &MockSender{Err: errors.New("api down")}
+		mw := NewMiddleware(sender, &MockWAF{}, config)
+
+		handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("Handler should NOT be called when Paranoid fails closed")
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/login", nil))
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Route gets its own body limits", func(t *testing.T) {
+		mw := NewMiddleware(&MockSender{}, &MockWAF{}, config)
+		handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/upload/file", strings.NewReader("too large")))
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected 413 on the upload route, got %d", rec.Code)
+		}
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/other", strings.NewReader("too large")))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected the default policy elsewhere, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Default policy fails closed on WAF errors", func(t *testing.T) {
+		mw := NewMiddleware(&MockSender{}, &MockWAF{Err: errors.New("waf broken")},
+			Config{Mode: LatencyFirst, FailurePolicy: FailClosed})
+		handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Paranoid fails open", func(t *testing.T) {
+		sender := &MockSender{Err: errors.New("api down")}
+		mw := NewMiddleware(sender, &MockWAF{BlockRequest: true}, Config{Mode: Paranoid, FailurePolicy: FailOpen})
+
+		handlerCalled := false
+		handler := mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlerCalled = true
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+		if !handlerCalled {
+			t.Error("Expected Paranoid to serve the request when failing open")
+		}
+	})
+}
+
+func TestCorazaPolicyExclusions(t *testing.T) {
+	sqliPayload := url.Values{"content": {"' OR 1=1"}}.Encode()
+
+	waf, err := NewWAF(Config{Policies: []RoutePolicy{
+		{Path: "/blog/editor", RuleExclusions: []RuleExclusion{{Targets: []string{"ARGS:content"}}}},
+		{Path: "/upload", MaxBodySize: 20 << 20},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to init WAF: %v", err)
+	}
+
+	if result, _ := waf.Check(httptest.NewRequest("GET", "/blog/editor?"+sqliPayload, nil)); result.Blocked {
+		t.Errorf("Expected the policy exclusion to apply on its route, fired %v", result.RuleIDs())
+	}
+	if result, _ := waf.Check(httptest.NewRequest("GET", "/blog/other?"+sqliPayload, nil)); !result.Blocked {
+		t.Error("Expected other routes to still be inspected")
+	}
+
+	if limit, _ := wafBodyLimit(waf.config); limit != 20<<20 {
+		t.Errorf("Expected the WAF to accept the largest policy body size, got %d", limit)
+	}
+}
@@ -0,0 +1,617 @@
+package argus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const redactedValue = "[REDACTED]"
+
+// DefaultRedactedHeaders are the headers RedactionConfig masks when its
+// Headers are nil.
+var DefaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+	"X-Csrf-Token",
+}
+
+// Detector finds sensitive values in payload text.
+type Detector struct {
+	// Name is how the detector is recorded in the redacted metadata.
+	Name    string
+	Pattern *regexp.Regexp
+	// Valid, when set, confirms a match, like the Luhn check of card numbers.
+	Valid func(match string) bool
+}
+
+var (
+	// CardNumberDetector finds payment card numbers that pass the Luhn check,
+	// with or without space and dash separators.
+	CardNumberDetector = Detector{
+		Name:    "card_number",
+		Pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		Valid:   luhn,
+	}
+	EmailDetector = Detector{
+		Name:    "email",
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	}
+	// TokenDetector finds bearer tokens, JWTs and the API keys of AWS,
+	// GitHub, Slack and Stripe.
+	TokenDetector = Detector{
+		Name: "token",
+		Pattern: regexp.MustCompile(`(?i:bearer)\s+[A-Za-z0-9._~+/-]+=*` +
+			`|\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*` +
+			`|\bAKIA[0-9A-Z]{16}\b` +
+			`|\bgh[pousr]_[A-Za-z0-9]{36,}\b` +
+			`|\bxox[abpr]-[A-Za-z0-9-]{10,}` +
+			`|\b[sprk]k_(?:live|test)_[A-Za-z0-9]{16,}\b`),
+	}
+)
+
+// Detectors are the built in detectors by the names ARGUS_REDACT_DETECTORS
+// takes.
+var Detectors = map[string]Detector{
+	"card":  CardNumberDetector,
+	"email": EmailDetector,
+	"token": TokenDetector,
+}
+
+// RedactionConfig masks secrets and personal data in the payloads sent to the
+// backend. The WAF and the protected handler still see the request as it is.
+// What was masked is listed in the redacted metadata, e.g.
+// ["header:Authorization","json:$.card.number","detector:email"].
+type RedactionConfig struct {
+	// Headers are masked by name, case-insensitively. Nil means
+	// DefaultRedactedHeaders, an empty slice masks none.
+	Headers []string
+	// JSONPaths mask the fields of JSON bodies they match: $.password,
+	// $.card.number, $.items[*].token, $.items[0], or $..secret at any depth.
+	// A field that is an object or an array is masked whole. An invalid path
+	// masks the whole body, and a body that stops parsing is cut there. They
+	// match query string and form fields by name too, card.number or
+	// card[number] for $.card.number, and GraphQL arguments by field path.
+	JSONPaths []string
+	// Detectors mask what they find in the body, header values, GraphQL
+	// arguments and WAF matches.
+	Detectors []Detector
+	// KeepShape masks letters as x and digits as 0 and keeps the rest, so
+	// "4111-1111-1111-1111" becomes "0000-0000-0000-0000". Otherwise values
+	// become [REDACTED].
+	KeepShape bool
+}
+
+// redaction masks one payload and records what it masked.
+type redaction struct {
+	config  RedactionConfig
+	headers map[string]bool
+	paths   []jsonPath
+	// invalid is set when a JSONPath doesn't parse
+	invalid bool
+	fields  map[string]bool
+	// operations maps GraphQL operation texts to their masked copies
+	operations map[string]string
+}
+
+func (c RedactionConfig) start() *redaction {
+	r := &redaction{config: c, headers: make(map[string]bool), fields: make(map[string]bool)}
+	headers := c.Headers
+	if headers == nil {
+		headers = DefaultRedactedHeaders
+	}
+	for _, name := range headers {
+		r.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, p := range c.JSONPaths {
+		path, err := parseJSONPath(p)
+		if err != nil {
+			r.invalid = true
+			continue
+		}
+		r.paths = append(r.paths, path)
+	}
+	return r
+}
+
+func (r *redaction) mask(value string) string {
+	if !r.config.KeepShape {
+		return redactedValue
+	}
+	return strings.Map(func(c rune) rune {
+		switch {
+		case unicode.IsUpper(c):
+			return 'X'
+		case unicode.IsLetter(c):
+			return 'x'
+		case unicode.IsDigit(c):
+			return '0'
+		}
+		return c
+	}, value)
+}
+
+// header masks a denylisted header and runs the detectors on the others.
+func (r *redaction) header(name, value string) string {
+	if name = http.CanonicalHeaderKey(name); r.headers[name] {
+		r.fields["header:"+name] = true
+		return r.mask(value)
+	}
+	return r.text(value)
+}
+
+// cookies parses Cookie header values, keeping the first of cookies with
+// the same name. Their values are masked like the Cookie header.
+func (r *redaction) cookies(values []string) map[string]string {
+	var cookies map[string]string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ";") {
+			name, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if _, ok := cookies[name]; ok || name == "" {
+				continue
+			}
+			if cookies == nil {
+				cookies = make(map[string]string)
+			}
+			cookies[name] = r.header("Cookie", val)
+		}
+	}
+	return cookies
+}
+
+// text masks what the detectors find in s.
+func (r *redaction) text(s string) string {
+	for _, d := range r.config.Detectors {
+		s = d.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if d.Valid != nil && !d.Valid(match) {
+				return match
+			}
+			r.fields["detector:"+d.Name] = true
+			return r.mask(match)
+		})
+	}
+	return s
+}
+
+// requestBody masks a request body: the fields of a form by name, other
+// bodies like body.
+func (r *redaction) requestBody(body []byte, contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/x-www-form-urlencoded" || r.invalid {
+		return r.body(body)
+	}
+	return r.query(string(body), "form")
+}
+
+// query masks the values of the fields of a query string or form whose name
+// a JSON path matches, then what the detectors find. kind names them in the
+// redacted metadata.
+func (r *redaction) query(raw, kind string) string {
+	if raw == "" {
+		return ""
+	}
+	fields := strings.Split(raw, "&")
+	for i, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		decoded, err := url.QueryUnescape(value)
+		if err != nil {
+			decoded = value
+		}
+		switch {
+		case ok && r.sensitiveField(name):
+			r.fields[kind+":"+name] = true
+			fields[i] = key + "=" + url.QueryEscape(r.mask(decoded))
+		case ok && r.operations[decoded] != "":
+			fields[i] = key + "=" + url.QueryEscape(r.operations[decoded])
+		}
+	}
+	return r.text(strings.Join(fields, "&"))
+}
+
+// sensitiveField reports whether a JSON path matches a query string or form
+// field name, dotted like card.number or bracketed like card[number].
+func (r *redaction) sensitiveField(name string) bool {
+	if r.invalid {
+		return true
+	}
+	if len(r.paths) == 0 {
+		return false
+	}
+	name = strings.NewReplacer("[", ".", "]", "").Replace(name)
+	return r.matchesPath(strings.Split(name, "."))
+}
+
+// graphql masks, in the text of GraphQL operations, the literal argument
+// values whose field path a JSON path matches. The masked texts replace the
+// originals wherever the payload carries them.
+func (r *redaction) graphql(literals []graphqlLiteral) {
+	masked := make(map[string][]graphqlLiteral)
+	for _, l := range literals {
+		if r.invalid || r.matchesPath(strings.Split(l.path, ".")) {
+			masked[l.query] = append(masked[l.query], l)
+		}
+	}
+	if len(masked) == 0 {
+		return
+	}
+
+	r.operations = make(map[string]string, len(masked))
+	for query, spans := range masked {
+		sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+		runes := []rune(query)
+		var out strings.Builder
+		pos := 0
+		for _, l := range spans {
+			// fragments spread more than once repeat their literals
+			if l.start < pos || l.end > len(runes) {
+				continue
+			}
+			out.WriteString(string(runes[pos:l.start]))
+			out.WriteString(strconv.Quote(r.mask(l.raw)))
+			pos = l.end
+		}
+		out.WriteString(string(runes[pos:]))
+		r.operations[query] = out.String()
+	}
+}
+
+// body masks the JSON paths of a JSON body, then what the detectors find.
+func (r *redaction) body(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if r.invalid {
+		r.fields["body"] = true
+		return redactedValue
+	}
+	if masked, ok := r.operations[string(body)]; ok {
+		// an application/graphql body is the operation text
+		return r.text(masked)
+	}
+	if trimmed := bytes.TrimSpace(body); len(r.paths) > 0 && len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		var out bytes.Buffer
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.UseNumber()
+		if err := r.copyJSON(dec, &out, nil); err != nil {
+			// what follows can't be matched to a path, so it is left out
+			r.fields["json:unparsed"] = true
+		}
+		body = out.Bytes()
+	}
+	return r.text(string(body))
+}
+
+// copyJSON copies the next value of dec to out, masking the paths under path.
+func (r *redaction) copyJSON(dec *json.Decoder, out *bytes.Buffer, path []pathElem) error {
+	for _, p := range r.paths {
+		if path != nil && p.match(path) {
+			return r.maskJSON(dec, out, path)
+		}
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		closing := byte('}')
+		if tok == '[' {
+			closing = ']'
+		}
+		out.WriteByte(byte(tok))
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			elem := pathElem{index: i}
+			if tok == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				name, ok := key.(string)
+				if !ok {
+					return errors.New("argus: invalid JSON object key")
+				}
+				writeJSONString(out, name)
+				out.WriteByte(':')
+				elem = pathElem{key: name, index: -1}
+			}
+			if err := r.copyJSON(dec, out, append(path[:len(path):len(path)], elem)); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		out.WriteByte(closing)
+	case string:
+		if masked, ok := r.operations[tok]; ok {
+			tok = masked
+		}
+		writeJSONString(out, tok)
+	case json.Number:
+		out.WriteString(tok.String())
+	case bool:
+		out.WriteString(strconv.FormatBool(tok))
+	case nil:
+		out.WriteString("null")
+	}
+	return nil
+}
+
+// maskJSON replaces the next value of dec. Objects and arrays are masked
+// whole, null is kept.
+func (r *redaction) maskJSON(dec *json.Decoder, out *bytes.Buffer, path []pathElem) error {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	r.fields["json:"+formatJSONPath(path)] = true
+
+	var value any
+	json.Unmarshal(raw, &value)
+	switch value := value.(type) {
+	case nil:
+		out.WriteString("null")
+	case string:
+		writeJSONString(out, r.mask(value))
+	case float64, bool:
+		writeJSONString(out, r.mask(string(raw)))
+	default:
+		writeJSONString(out, redactedValue)
+	}
+	return nil
+}
+
+func writeJSONString(out *bytes.Buffer, s string) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	out.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+// matches masks the matched values of denylisted headers and of JSON paths,
+// which Coraza names like REQUEST_HEADERS:Authorization and
+// ARGS_POST:json.card.number, and runs the detectors on the others. A
+// RESPONSE_BODY value is the whole response body, masked like a body.
+func (r *redaction) matches(matches []RuleMatch) []RuleMatch {
+	masked := make([]RuleMatch, len(matches))
+	for i, m := range matches {
+		m.Variables = append([]MatchedVariable(nil), m.Variables...)
+		for j, v := range m.Variables {
+			switch {
+			case r.sensitiveVariable(v.Name):
+				m.Variables[j].Value = r.mask(v.Value)
+			case v.Name == "RESPONSE_BODY":
+				m.Variables[j].Value = r.body([]byte(v.Value))
+			default:
+				m.Variables[j].Value = r.text(v.Value)
+			}
+		}
+		masked[i] = m
+	}
+	return masked
+}
+
+func (r *redaction) sensitiveVariable(name string) bool {
+	collection, key, _ := strings.Cut(name, ":")
+	switch collection {
+	case "REQUEST_HEADERS", "RESPONSE_HEADERS":
+		return r.headers[http.CanonicalHeaderKey(key)]
+	case "REQUEST_COOKIES":
+		return r.headers["Cookie"]
+	case "ARGS", "ARGS_GET", "ARGS_POST":
+		if field, ok := strings.CutPrefix(key, "json."); ok && !r.invalid {
+			return r.matchesPath(strings.Split(field, "."))
+		}
+		return r.sensitiveField(key)
+	}
+	return false
+}
+
+// arguments masks the GraphQL arguments whose field path a JSON path matches,
+// and runs the detectors on the others.
+func (r *redaction) arguments(args []GraphQLArgument) []GraphQLArgument {
+	masked := make([]GraphQLArgument, len(args))
+	for i, arg := range args {
+		if r.invalid || r.matchesPath(strings.Split(arg.Path, ".")) {
+			arg.Value = r.mask(arg.Value)
+		} else {
+			arg.Value = r.text(arg.Value)
+		}
+		masked[i] = arg
+	}
+	return masked
+}
+
+// matchesPath reports whether a JSON path matches a dotted path, whose
+// numeric parts are array indexes.
+func (r *redaction) matchesPath(parts []string) bool {
+	path := make([]pathElem, len(parts))
+	for i, part := range parts {
+		path[i] = pathElem{key: part, index: -1}
+		if n, err := strconv.Atoi(part); err == nil && n >= 0 {
+			path[i] = pathElem{index: n}
+		}
+	}
+	for _, p := range r.paths {
+		if p.match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// record lists what was masked in the redacted metadata.
+func (r *redaction) record(meta map[string]string) {
+	if len(r.fields) == 0 {
+		return
+	}
+	fields := make([]string, 0, len(r.fields))
+	for field := range r.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	b, _ := json.Marshal(fields)
+	meta["redacted"] = string(b)
+}
+
+// pathElem is an object key, or an array index when index is not -1.
+type pathElem struct {
+	key   string
+	index int
+}
+
+func formatJSONPath(path []pathElem) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, elem := range path {
+		if elem.index >= 0 {
+			b.WriteString("[" + strconv.Itoa(elem.index) + "]")
+		} else {
+			b.WriteString("." + elem.key)
+		}
+	}
+	return b.String()
+}
+
+// jsonPath is a parsed RedactionConfig.JSONPaths entry.
+type jsonPath []pathSegment
+
+type pathSegment struct {
+	// descend matches the segment at any depth, for ..
+	descend  bool
+	wildcard bool
+	key      string
+	index    int
+}
+
+func (s pathSegment) matches(elem pathElem) bool {
+	switch {
+	case s.wildcard:
+		return true
+	case s.index >= 0:
+		return elem.index == s.index
+	default:
+		return elem.index < 0 && elem.key == s.key
+	}
+}
+
+func (p jsonPath) match(path []pathElem) bool {
+	if len(p) == 0 {
+		return len(path) == 0
+	}
+	s := p[0]
+	if s.descend {
+		for i := range path {
+			if s.matches(path[i]) && p[1:].match(path[i+1:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(path) > 0 && s.matches(path[0]) && p[1:].match(path[1:])
+}
+
+var errJSONPath = errors.New("argus: invalid JSON path")
+
+// parseJSONPath parses the subset of JSONPath RedactionConfig takes: $
+// followed by .key, ..key, .*, [n], [*] and ['key'].
+func parseJSONPath(s string) (jsonPath, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(s), "$")
+	if !ok {
+		return nil, errJSONPath
+	}
+	var path jsonPath
+	for rest != "" {
+		var seg pathSegment
+		seg.index = -1
+		switch {
+		case strings.HasPrefix(rest, "["):
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errJSONPath
+			}
+			inner := rest[1:end]
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				seg.wildcard = true
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				seg.key = inner[1 : len(inner)-1]
+			default:
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, errJSONPath
+				}
+				seg.index = n
+			}
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			if strings.HasPrefix(rest, ".") {
+				seg.descend = true
+				rest = rest[1:]
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			if name == "" {
+				return nil, errJSONPath
+			}
+			if name == "*" {
+				seg.wildcard = true
+			} else {
+				seg.key = name
+			}
+		default:
+			return nil, errJSONPath
+		}
+		path = append(path, seg)
+	}
+	if len(path) == 0 {
+		return nil, errJSONPath
+	}
+	return path, nil
+}
+
+// luhn reports whether the digits of s pass the Luhn check.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
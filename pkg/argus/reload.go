@@ -0,0 +1,121 @@
+package argus
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// Reload recompiles the WAF from its current config, re-reading RuleFiles,
+// and swaps the new rule set in. If compiling fails the running rules are kept.
+func (w *WAFWrapper) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.swap(w.config)
+}
+
+// ReloadConfig swaps in a rule set compiled from config. If compiling fails
+// the running rules and config are kept.
+func (w *WAFWrapper) ReloadConfig(config Config) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.swap(config); err != nil {
+		return err
+	}
+	w.config = config
+	return nil
+}
+
+func (w *WAFWrapper) swap(config Config) error {
+	waf, err := compileWAF(config)
+	if err != nil {
+		return fmt.Errorf("reload rejected: %w", err)
+	}
+
+	w.waf.Store(&waf)
+	return nil
+}
+
+// WatchRules polls dir every interval and reloads the WAF when a file in it is
+// added, removed or modified. Point RuleFiles at files in dir for their edits
+// to be picked up. onReload, if set, receives the outcome of every reload.
+// It blocks until ctx is done.
+func (w *WAFWrapper) WatchRules(ctx context.Context, dir string, interval time.Duration, onReload func(error)) error {
+	last, err := dirFingerprint(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read rules dir: %w", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := dirFingerprint(dir)
+		if err != nil || current == last {
+			continue
+		}
+		last = current
+
+		err = w.Reload()
+		if onReload != nil {
+			onReload(err)
+		}
+	}
+}
+
+// dirFingerprint hashes the name, size and modification time of every file
+// under dir.
+func dirFingerprint(dir string) ([sha256.Size]byte, error) {
+	h := sha256.New()
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s|%d|%d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// ReloadHandler reloads the WAF on POST. It answers 204 on success and 422
+// with the compile error when the new rules are rejected. Mount it behind
+// your own authentication.
+func (w *WAFWrapper) ReloadHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			rw.Header().Set("Allow", http.MethodPost)
+			http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := w.Reload(); err != nil {
+			http.Error(rw, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
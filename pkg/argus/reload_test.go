@@ -0,0 +1,169 @@
+package argus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeRule(t *testing.T, path, rule string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(rule), 0o644); err != nil {
+		t.Fatalf("Failed to write rule file: %v", err)
+	}
+}
+
+func blockedWith(t *testing.T, waf *WAFWrapper, header string) bool {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(header, "1")
+	result, err := waf.Check(req)
+	if err != nil {
+		t.Fatalf("WAF check failed: %v", err)
+	}
+	return result.Blocked
+}
+
+const (
+	blockAlpha = `SecRule REQUEST_HEADERS:X-Alpha "@streq 1" "id:10010,phase:1,deny,status:403,msg:'alpha'"`
+	blockBeta  = `SecRule REQUEST_HEADERS:X-Beta "@streq 1" "id:10011,phase:1,deny,status:403,msg:'beta'"`
+	brokenRule = `SecRule REQUEST_HEADERS:X-Beta "@rx (" "id:10012,phase:1,deny"`
+)
+
+func TestWAFReload(t *testing.T) {
+	t.Run("pick up edited rule file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "custom.conf")
+		writeRule(t, path, blockAlpha)
+
+		waf, err := NewWAF(Config{RuleFiles: []string{path}})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		writeRule(t, path, blockBeta)
+		if err := waf.Reload(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+
+		if blockedWith(t, waf, "X-Alpha") {
+			t.Error("Expected old rule to be gone after reload")
+		}
+		if !blockedWith(t, waf, "X-Beta") {
+			t.Error("Expected new rule to be active after reload")
+		}
+	})
+
+	t.Run("reject broken rules and keep current ones", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "custom.conf")
+		writeRule(t, path, blockAlpha)
+
+		waf, err := NewWAF(Config{RuleFiles: []string{path}})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		writeRule(t, path, brokenRule)
+		if err := waf.Reload(); err == nil {
+			t.Fatal("Expected reload of broken rules to fail")
+		}
+
+		if !blockedWith(t, waf, "X-Alpha") {
+			t.Error("Expected current rules to keep running after failed reload")
+		}
+	})
+
+	t.Run("swap to a new config", func(t *testing.T) {
+		waf, err := NewWAF(Config{RuleDirectives: []string{blockAlpha}})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		if err := waf.ReloadConfig(Config{RuleDirectives: []string{brokenRule}}); err == nil {
+			t.Fatal("Expected broken config to be rejected")
+		}
+		if err := waf.ReloadConfig(Config{RuleDirectives: []string{blockBeta}}); err != nil {
+			t.Fatalf("ReloadConfig failed: %v", err)
+		}
+		if err := waf.Reload(); err != nil {
+			t.Fatalf("Reload failed: %v", err)
+		}
+
+		if blockedWith(t, waf, "X-Alpha") || !blockedWith(t, waf, "X-Beta") {
+			t.Error("Expected reload to keep the last accepted config")
+		}
+	})
+}
+
+func TestWAFWatchRules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "custom.conf")
+	writeRule(t, path, blockAlpha)
+
+	waf, err := NewWAF(Config{RulesFS: os.DirFS(dir), RuleFiles: []string{"custom.conf"}})
+	if err != nil {
+		t.Fatalf("Failed to init WAF: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloaded := make(chan error, 1)
+	go waf.WatchRules(ctx, dir, 10*time.Millisecond, func(err error) { reloaded <- err })
+
+	// Make sure the modification time moves even on coarse filesystem clocks
+	time.Sleep(20 * time.Millisecond)
+	writeRule(t, path, blockBeta)
+	future := time.Now().Add(time.Second)
+	os.Chtimes(path, future, future)
+
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("Watched reload failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for watched reload")
+	}
+
+	if !blockedWith(t, waf, "X-Beta") {
+		t.Error("Expected watched change to be loaded")
+	}
+
+	if err := waf.WatchRules(ctx, filepath.Join(dir, "missing"), time.Second, nil); err == nil {
+		t.Error("Expected error watching missing dir")
+	}
+}
+
+func TestWAFReloadHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custom.conf")
+	writeRule(t, path, blockAlpha)
+
+	waf, err := NewWAF(Config{RuleFiles: []string{path}})
+	if err != nil {
+		t.Fatalf("Failed to init WAF: %v", err)
+	}
+	handler := waf.ReloadHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/reload", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 on successful reload, got %d", rec.Code)
+	}
+
+	writeRule(t, path, brokenRule)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/reload", nil))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 on rejected reload, got %d", rec.Code)
+	}
+}
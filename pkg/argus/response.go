@@ -0,0 +1,157 @@
+package argus
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const defaultMaxResponseInspectSize = 512 * 1024
+
+// inspectResponse wraps next so its response is buffered and run through
+// check before it reaches the client. Leaks are reported to the backend with
+// the redacted part of the response that was inspected and, with
+// BlockResponseLeaks, replaced by a generic error.
+func (m *Middleware) inspectResponse(next http.Handler, check ResponseCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := m.Config.MaxResponseInspectSize
+		if limit <= 0 {
+			limit = defaultMaxResponseInspectSize
+		}
+
+		riw := &responseInspector{
+			ResponseWriter: w,
+			limit:          limit,
+			status:         http.StatusOK,
+			block: func(w http.ResponseWriter) {
+				m.writeBlock(&httpInbound{w: w, r: r}, http.StatusInternalServerError, "Response blocked by Argus Shield")
+			},
+			inspect: func(status int, header http.Header, respBody []byte) bool {
+				result, err := check.CheckResponse(status, header, respBody)
+				if err != nil || len(result.Matches) == 0 {
+					return false
+				}
+
+				req := m.buildPayload(&httpInbound{r: r}, respBody, result)
+				req.ContentType = header.Get("Content-Type")
+				req.MetaData["inspection"] = "response"
+				req.MetaData["response_status"] = strconv.Itoa(status)
+
+				block := result.Blocked && m.Config.BlockResponseLeaks
+				if m.Config.Mode == Shadow {
+					req.MetaData["would_block"] = strconv.FormatBool(block)
+					block = false
+				}
+				m.sendAsyncPayload(req)
+
+				return block
+			},
+		}
+
+		next.ServeHTTP(riw, r)
+		riw.finish()
+	})
+}
+
+// responseInspector holds back the status, headers and up to limit body bytes
+// of a response until they have been inspected. Once inspected, or when the
+// handler flushes, everything else is written straight through.
+type responseInspector struct {
+	http.ResponseWriter
+	limit   int
+	inspect func(status int, header http.Header, body []byte) bool
+	block   func(w http.ResponseWriter)
+
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+	inspected   bool
+	blocked     bool
+	hijacked    bool
+}
+
+func (ri *responseInspector) WriteHeader(code int) {
+	if ri.wroteHeader {
+		return
+	}
+	ri.wroteHeader = true
+	ri.status = code
+}
+
+func (ri *responseInspector) Write(p []byte) (int, error) {
+	if !ri.wroteHeader {
+		ri.WriteHeader(http.StatusOK)
+	}
+	if ri.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if ri.blocked {
+		return len(p), nil
+	}
+	if ri.inspected {
+		return ri.ResponseWriter.Write(p)
+	}
+
+	if ri.buf.Len()+len(p) <= ri.limit {
+		return ri.buf.Write(p)
+	}
+
+	n := ri.limit - ri.buf.Len()
+	ri.buf.Write(p[:n])
+	if !ri.release() {
+		return len(p), nil
+	}
+	written, err := ri.ResponseWriter.Write(p[n:])
+	return n + written, err
+}
+
+// Flush sends what has been buffered so far, inspecting it first, so
+// streaming handlers keep working past their first flush.
+func (ri *responseInspector) Flush() {
+	if !ri.inspected && !ri.release() {
+		return
+	}
+	if f, ok := ri.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection to the handler. Nothing is inspected after
+// that, what the handler writes to it goes straight to the client.
+func (ri *responseInspector) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(ri.ResponseWriter).Hijack()
+	if err == nil {
+		ri.inspected = true
+		ri.hijacked = true
+	}
+	return conn, brw, err
+}
+
+func (ri *responseInspector) Unwrap() http.ResponseWriter {
+	return ri.ResponseWriter
+}
+
+// release inspects the buffered response and writes it, or the block
+// response in its place. It reports whether the response went through.
+func (ri *responseInspector) release() bool {
+	ri.inspected = true
+
+	if ri.inspect(ri.status, ri.Header(), ri.buf.Bytes()) {
+		ri.blocked = true
+		clear(ri.Header())
+		ri.block(ri.ResponseWriter)
+		return false
+	}
+
+	ri.ResponseWriter.WriteHeader(ri.status)
+	ri.ResponseWriter.Write(ri.buf.Bytes())
+	return true
+}
+
+func (ri *responseInspector) finish() {
+	if !ri.inspected {
+		ri.release()
+	}
+}
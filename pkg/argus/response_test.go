@@ -0,0 +1,274 @@
+package argus
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+type MockResponseWAF struct {
+	MockWAF
+	ResponseResult WAFResult
+	SeenBody       []byte
+	Closed         bool
+}
+
+func (m *MockResponseWAF) CheckRequest(r *http.Request) (WAFResult, ResponseCheck, error) {
+	result, err := m.Check(r)
+	return result, m, err
+}
+
+func (m *MockResponseWAF) CheckResponse(status int, header http.Header, body []byte) (WAFResult, error) {
+	m.SeenBody = append([]byte(nil), body...)
+	return m.ResponseResult, nil
+}
+
+func (m *MockResponseWAF) Close() error {
+	m.Closed = true
+	return nil
+}
+
+var sqlLeak = WAFResult{
+	Blocked: true,
+	Matches: []RuleMatch{{RuleID: 951220, Message: "mysql SQL Information Leakage", Tags: []string{"attack-disclosure"}}},
+}
+
+func leakyHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("X-Upstream", "app")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	})
+}
+
+func TestResponseInspection(t *testing.T) {
+	t.Run("replace blocked leak and report it", func(t *testing.T) {
+		waf := &MockResponseWAF{ResponseResult: sqlLeak}
+		isThreat := false
+		sender := &MockSender{
+			Response:   protocol.AnalysisResponse{IsThreat: &isThreat},
+			CallSignal: make(chan struct{}, 2),
+		}
+		// Paranoid analyses the request synchronously, leaving the leak report as the only async call
+		mw := NewMiddleware(sender, waf, Config{Mode: Paranoid, InspectResponses: true, BlockResponseLeaks: true})
+
+		rec := httptest.NewRecorder()
+		mw.Protect(leakyHandler("You have an error in your SQL syntax")).ServeHTTP(rec, httptest.NewRequest("GET", "/item", nil))
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("Expected 500, got %d", rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "SQL syntax") || rec.Header().Get("X-Upstream") != "" {
+			t.Errorf("Expected leaking body and headers to be replaced, got %q", rec.Body.String())
+		}
+		if !waf.Closed {
+			t.Error("Expected response check to be closed")
+		}
+
+		for range 2 {
+			select {
+			case <-sender.CallSignal:
+			case <-time.After(100 * time.Millisecond):
+				t.Fatal("Timeout waiting for leak report")
+			}
+		}
+		if sender.SentReq.MetaData["inspection"] != "response" || sender.SentReq.MetaData["waf_rule_ids"] != "951220" {
+			t.Errorf("Expected leak rule in report, got %v", sender.SentReq.MetaData)
+		}
+		if sender.SentReq.Log != "You have an error in your SQL syntax" || sender.SentReq.ContentType != "text/html" {
+			t.Errorf("Expected the response in the report, got %q as %q", sender.SentReq.Log, sender.SentReq.ContentType)
+		}
+	})
+
+	t.Run("redact the response in the leak report", func(t *testing.T) {
+		body := `{"error":"You have an error in your SQL syntax","password":"hunter2","contact":"ops@example.com"}`
+		leak := sqlLeak
+		leak.Matches = []RuleMatch{{RuleID: 951220, Variables: []MatchedVariable{{Name: "RESPONSE_BODY", Value: body}}}}
+		waf := &MockResponseWAF{ResponseResult: leak}
+		isThreat := false
+		sender := &MockSender{
+			Response:   protocol.AnalysisResponse{IsThreat: &isThreat},
+			CallSignal: make(chan struct{}, 2),
+		}
+		mw := NewMiddleware(sender, waf, Config{
+			Mode:             Paranoid,
+			InspectResponses: true,
+			Redaction:        RedactionConfig{JSONPaths: []string{"$.password"}, Detectors: []Detector{EmailDetector}},
+		})
+
+		mw.Protect(leakyHandler(body)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/item", nil))
+		for range 2 {
+			select {
+			case <-sender.CallSignal:
+			case <-time.After(100 * time.Millisecond):
+				t.Fatal("Timeout waiting for leak report")
+			}
+		}
+
+		want := `{"error":"You have an error in your SQL syntax","password":"[REDACTED]","contact":"[REDACTED]"}`
+		if sender.SentReq.Log != want {
+			t.Errorf("Expected the redacted response %q, got %q", want, sender.SentReq.Log)
+		}
+		if matches := sender.SentReq.MetaData["waf_matches"]; strings.Contains(matches, "hunter2") || strings.Contains(matches, "ops@example.com") {
+			t.Errorf("Expected the RESPONSE_BODY match to be redacted, got %s", matches)
+		}
+	})
+
+	t.Run("report leak but keep response without blocking", func(t *testing.T) {
+		waf := &MockResponseWAF{ResponseResult: sqlLeak}
+		sender := &MockSender{}
+		mw := NewMiddleware(sender, waf, Config{Mode: LatencyFirst, InspectResponses: true})
+
+		rec := httptest.NewRecorder()
+		mw.Protect(leakyHandler("You have an error in your SQL syntax")).ServeHTTP(rec, httptest.NewRequest("GET", "/item", nil))
+
+		if rec.Code != http.StatusOK || rec.Body.String() != "You have an error in your SQL syntax" {
+			t.Errorf("Expected original response, got %d %q", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("shadow mode keeps blocked leak", func(t *testing.T) {
+		waf := &MockResponseWAF{ResponseResult: sqlLeak}
+		mw := NewMiddleware(&MockSender{}, waf, Config{Mode: Shadow, InspectResponses: true, BlockResponseLeaks: true})
+
+		rec := httptest.NewRecorder()
+		mw.Protect(leakyHandler("You have an error in your SQL syntax")).ServeHTTP(rec, httptest.NewRequest("GET", "/item", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("Expected shadow mode not to block the response, got %d", rec.Code)
+		}
+	})
+
+	t.Run("pass clean response with status and headers", func(t *testing.T) {
+		waf := &MockResponseWAF{}
+		mw := NewMiddleware(&MockSender{}, waf, Config{Mode: LatencyFirst, InspectResponses: true, BlockResponseLeaks: true})
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Upstream", "app")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("created"))
+		})
+		rec := httptest.NewRecorder()
+		mw.Protect(handler).ServeHTTP(rec, httptest.NewRequest("POST", "/item", nil))
+
+		if rec.Code != http.StatusCreated || rec.Body.String() != "created" || rec.Header().Get("X-Upstream") != "app" {
+			t.Errorf("Expected untouched response, got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+		}
+		if string(waf.SeenBody) != "created" {
+			t.Errorf("Expected body to be inspected, got %q", waf.SeenBody)
+		}
+	})
+
+	t.Run("inspect prefix and stream rest of large body", func(t *testing.T) {
+		waf := &MockResponseWAF{}
+		mw := NewMiddleware(&MockSender{}, waf, Config{Mode: LatencyFirst, InspectResponses: true, MaxResponseInspectSize: 4})
+
+		rec := httptest.NewRecorder()
+		mw.Protect(leakyHandler("0123456789")).ServeHTTP(rec, httptest.NewRequest("GET", "/big", nil))
+
+		if string(waf.SeenBody) != "0123" {
+			t.Errorf("Expected only 4 byte prefix to be inspected, got %q", waf.SeenBody)
+		}
+		if rec.Body.String() != "0123456789" {
+			t.Errorf("Expected full body to reach client, got %q", rec.Body.String())
+		}
+	})
+
+	t.Run("release buffer on flush", func(t *testing.T) {
+		waf := &MockResponseWAF{}
+		mw := NewMiddleware(&MockSender{}, waf, Config{Mode: LatencyFirst, InspectResponses: true})
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("event: 1\n"))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("event: 2\n"))
+		})
+		rec := httptest.NewRecorder()
+		mw.Protect(handler).ServeHTTP(rec, httptest.NewRequest("GET", "/stream", nil))
+
+		if string(waf.SeenBody) != "event: 1\n" || !rec.Flushed {
+			t.Errorf("Expected first event to be inspected and flushed, got %q", waf.SeenBody)
+		}
+		if rec.Body.String() != "event: 1\nevent: 2\n" {
+			t.Errorf("Expected both events, got %q", rec.Body.String())
+		}
+	})
+
+	t.Run("skip response check when disabled", func(t *testing.T) {
+		waf := &MockResponseWAF{ResponseResult: sqlLeak}
+		mw := NewMiddleware(&MockSender{}, waf, Config{Mode: LatencyFirst, BlockResponseLeaks: true})
+
+		rec := httptest.NewRecorder()
+		mw.Protect(leakyHandler("leak")).ServeHTTP(rec, httptest.NewRequest("GET", "/item", nil))
+
+		if rec.Code != http.StatusOK || waf.SeenBody != nil {
+			t.Error("Expected no response inspection when InspectResponses is off")
+		}
+	})
+
+	t.Run("pass hijacked connections through", func(t *testing.T) {
+		waf := &MockResponseWAF{ResponseResult: sqlLeak}
+		mw := NewMiddleware(&MockSender{}, waf, Config{Mode: LatencyFirst, InspectResponses: true, BlockResponseLeaks: true})
+
+		server := httptest.NewServer(mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hj, ok := w.(http.Hijacker)
+			if !ok {
+				t.Error("Expected the response writer to be a Hijacker")
+				return
+			}
+			conn, brw, err := hj.Hijack()
+			if err != nil {
+				t.Errorf("Expected hijack to succeed, got %v", err)
+				return
+			}
+			defer conn.Close()
+			brw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello")
+			brw.Flush()
+		})))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+			t.Errorf("Expected the hijacked response, got %d %q", resp.StatusCode, body)
+		}
+		if waf.SeenBody != nil {
+			t.Error("Expected no inspection of a hijacked connection")
+		}
+	})
+}
+
+func TestResponseInspectionWithCoraza(t *testing.T) {
+	waf, err := NewWAF(Config{})
+	if err != nil {
+		t.Fatalf("Failed to init WAF: %v", err)
+	}
+
+	isThreat := false
+	sender := &MockSender{Response: protocol.AnalysisResponse{IsThreat: &isThreat}}
+	mw := NewMiddleware(sender, waf, Config{Mode: LatencyFirst, InspectResponses: true, BlockResponseLeaks: true})
+
+	leak := "<html><body>You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version for the right syntax to use near ''' at line 1</body></html>"
+
+	rec := httptest.NewRecorder()
+	mw.Protect(leakyHandler(leak)).ServeHTTP(rec, httptest.NewRequest("GET", "/item?id=1", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected SQL error leak to be blocked, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mw.Protect(leakyHandler("<html><body>Item 1</body></html>")).ServeHTTP(rec, httptest.NewRequest("GET", "/item?id=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected clean page to pass, got %d", rec.Code)
+	}
+}
@@ -7,66 +7,345 @@ import (
 	"io"
 	"io/fs"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/corazawaf/coraza/v3"
+	"github.com/corazawaf/coraza/v3/experimental/plugins/plugintypes"
+	"github.com/corazawaf/coraza/v3/types"
 )
 
 type RuleEngine interface {
-	Check(r *http.Request) (bool, error)
+	Check(r *http.Request) (WAFResult, error)
 }
 
-type WAFWrapper struct {
-	waf coraza.WAF
+// ResponseRuleEngine is a RuleEngine that can keep a request's transaction
+// open until its response is known, so the CRS RESPONSE-95x rules can run.
+type ResponseRuleEngine interface {
+	RuleEngine
+	CheckRequest(r *http.Request) (WAFResult, ResponseCheck, error)
+}
+
+// ResponseCheck inspects the response of the request it was started for.
+type ResponseCheck interface {
+	CheckResponse(status int, header http.Header, body []byte) (WAFResult, error)
+	Close() error
+}
+
+// WAFResult explains a RuleEngine verdict: whether the request (or response)
+// was blocked, which rules fired on the way and the anomaly score they added
+// up to.
+type WAFResult struct {
+	Blocked      bool
+	AnomalyScore int
+	Matches      []RuleMatch
+}
+
+type RuleMatch struct {
+	RuleID    int               `json:"rule_id"`
+	Message   string            `json:"message"`
+	Severity  string            `json:"severity"`
+	Tags      []string          `json:"tags"`
+	Variables []MatchedVariable `json:"variables"`
+}
+
+// MatchedVariable is the variable a rule matched on, e.g. ARGS:q.
+type MatchedVariable struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// RuleIDs returns the IDs of every rule that fired, in match order.
+func (r WAFResult) RuleIDs() []int {
+	ids := make([]int, 0, len(r.Matches))
+	for _, m := range r.Matches {
+		ids = append(ids, m.RuleID)
+	}
+	return ids
+}
+
+// AttackTags returns the distinct attack-* tags (attack-sqli, attack-xss, ...)
+// of the rules that fired.
+func (r WAFResult) AttackTags() []string {
+	var tags []string
+	for _, m := range r.Matches {
+		for _, tag := range m.Tags {
+			if strings.HasPrefix(tag, "attack-") && !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
 }
 
-var _ RuleEngine = (*WAFWrapper)(nil)
+// RuleFamily identifies one OWASP CRS rule file by its numeric prefix.
+type RuleFamily string
+
+const (
+	FamilyScannerDetection    RuleFamily = "913"
+	FamilyProtocolEnforcement RuleFamily = "920"
+	FamilyProtocolAttack      RuleFamily = "921"
+	FamilyLFI                 RuleFamily = "930"
+	FamilyRFI                 RuleFamily = "931"
+	FamilyRCE                 RuleFamily = "932"
+	FamilyPHP                 RuleFamily = "933"
+	FamilyGeneric             RuleFamily = "934"
+	FamilyXSS                 RuleFamily = "941"
+	FamilySQLi                RuleFamily = "942"
+	FamilyJava                RuleFamily = "944"
+
+	// Response families, only evaluated when responses are inspected
+	FamilyDataLeakages     RuleFamily = "950"
+	FamilySQLLeakages      RuleFamily = "951"
+	FamilyJavaLeakages     RuleFamily = "952"
+	FamilyPHPLeakages      RuleFamily = "953"
+	FamilyIISLeakages      RuleFamily = "954"
+	FamilyWebShellLeakages RuleFamily = "955"
+	FamilyRubyLeakages     RuleFamily = "956"
+)
+
+// Kept in CRS numeric order, which is the order the files have to be parsed in.
+var ruleFamilyFiles = []struct {
+	family RuleFamily
+	file   string
+}{
+	{FamilyScannerDetection, "REQUEST-913-SCANNER-DETECTION.conf"},
+	{FamilyProtocolEnforcement, "REQUEST-920-PROTOCOL-ENFORCEMENT.conf"},
+	{FamilyProtocolAttack, "REQUEST-921-PROTOCOL-ATTACK.conf"},
+	{FamilyLFI, "REQUEST-930-APPLICATION-ATTACK-LFI.conf"},
+	{FamilyRFI, "REQUEST-931-APPLICATION-ATTACK-RFI.conf"},
+	{FamilyRCE, "REQUEST-932-APPLICATION-ATTACK-RCE.conf"},
+	{FamilyPHP, "REQUEST-933-APPLICATION-ATTACK-PHP.conf"},
+	{FamilyGeneric, "REQUEST-934-APPLICATION-ATTACK-GENERIC.conf"},
+	{FamilyXSS, "REQUEST-941-APPLICATION-ATTACK-XSS.conf"},
+	{FamilySQLi, "REQUEST-942-APPLICATION-ATTACK-SQLI.conf"},
+	{FamilyJava, "REQUEST-944-APPLICATION-ATTACK-JAVA.conf"},
+	{FamilyDataLeakages, "RESPONSE-950-DATA-LEAKAGES.conf"},
+	{FamilySQLLeakages, "RESPONSE-951-DATA-LEAKAGES-SQL.conf"},
+	{FamilyJavaLeakages, "RESPONSE-952-DATA-LEAKAGES-JAVA.conf"},
+	{FamilyPHPLeakages, "RESPONSE-953-DATA-LEAKAGES-PHP.conf"},
+	{FamilyIISLeakages, "RESPONSE-954-DATA-LEAKAGES-IIS.conf"},
+	{FamilyWebShellLeakages, "RESPONSE-955-WEB-SHELLS.conf"},
+	{FamilyRubyLeakages, "RESPONSE-956-DATA-LEAKAGES-RUBY.conf"},
+}
+
+// AllRuleFamilies returns every embedded CRS request and response family. It
+// is what NewWAF loads when Config.RuleFamilies is empty.
+func AllRuleFamilies() []RuleFamily {
+	families := make([]RuleFamily, 0, len(ruleFamilyFiles))
+	for _, rf := range ruleFamilyFiles {
+		families = append(families, rf.family)
+	}
+	return families
+}
+
+// ParseRuleFamilies turns a comma separated list such as "930,932,942" into
+// rule families. It is meant for reading the selection from env vars.
+func ParseRuleFamilies(s string) ([]RuleFamily, error) {
+	var families []RuleFamily
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		family := RuleFamily(part)
+		if !slices.Contains(AllRuleFamilies(), family) {
+			return nil, fmt.Errorf("unknown rule family %q", part)
+		}
+		families = append(families, family)
+	}
+	return families, nil
+}
+
+type WAFWrapper struct {
+	// waf is swapped as a whole on reload, a transaction keeps the rule set it
+	// started with.
+	waf atomic.Pointer[coraza.WAF]
+
+	// mu serializes reloads and guards config.
+	mu     sync.Mutex
+	config Config
+}
 
 var (
-	instance *WAFWrapper
-	once     sync.Once
-	initErr  error
+	_ ResponseRuleEngine = (*WAFWrapper)(nil)
+	_ ExchangeRuleEngine = (*WAFWrapper)(nil)
 )
 
 //go:embed rules/*.conf rules/*.data
 var rulesFS embed.FS
 
-func NewWAF() (*WAFWrapper, error) {
-	once.Do(func() {
-		cfg := coraza.NewWAFConfig()
+// NewWAF compiles the rule families, CRS settings and custom rules of config
+// into a new WAF. Every call returns an independent instance, so differently
+// configured WAFs can run side by side and a failed build affects nothing else.
+// Middlewares using the same rules should share one instance.
+func NewWAF(config Config) (*WAFWrapper, error) {
+	waf, err := compileWAF(config)
+	if err != nil {
+		return nil, err
+	}
 
-		root, _ := fs.Sub(rulesFS, "rules")
-		cfg = cfg.WithRootFS(root)
+	w := &WAFWrapper{config: config}
+	w.waf.Store(&waf)
+	return w, nil
+}
 
-		files := []string{
-			"coraza.conf",
-			"crs-setup.conf",
-			"REQUEST-901-INITIALIZATION.conf",
-			"REQUEST-941-APPLICATION-ATTACK-XSS.conf",
-			"REQUEST-942-APPLICATION-ATTACK-SQLI.conf",
-			"REQUEST-949-BLOCKING-EVALUATION.conf",
+func compileWAF(config Config) (coraza.WAF, error) {
+	files, err := ruleFiles(config.RuleFamilies)
+	if err != nil {
+		return nil, err
+	}
+
+	setup, err := crsSetup(config)
+	if err != nil {
+		return nil, err
+	}
+
+	exclusions, err := exclusionDirectives(allRuleExclusions(config))
+	if err != nil {
+		return nil, err
+	}
+
+	custom, err := customRules(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := coraza.NewWAFConfig()
+
+	root, _ := fs.Sub(rulesFS, "rules")
+	cfg = cfg.WithRootFS(root)
+
+	for _, file := range files {
+		cfg, err = parseRuleFile(cfg, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse rule file %s: %w", file, err)
 		}
+		switch file {
+		case "crs-setup.conf":
+			// Overrides have to land after the CRS setup and before 901 fills in defaults
+			cfg = cfg.WithDirectives(setup)
+		case "REQUEST-901-INITIALIZATION.conf":
+			// Runtime exclusions have to run before the rules they remove
+			cfg = cfg.WithDirectives(exclusions)
+		}
+	}
+	cfg = cfg.WithDirectives(custom)
 
-		var err error
+	waf, err := coraza.NewWAF(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize coraza waf: %w", err)
+	}
 
-		for _, file := range files {
-			cfg, err = parseRuleFile(cfg, file)
-			if err != nil {
-				initErr = fmt.Errorf("failed to parse rule file %s: %w", file, err)
-				return
-			}
+	return waf, nil
+}
+
+// ruleFiles resolves the selected families into the ordered list of files to
+// parse, wrapped by the setup, initialization and request and response
+// blocking evaluation files.
+func ruleFiles(families []RuleFamily) ([]string, error) {
+	if len(families) == 0 {
+		families = AllRuleFamilies()
+	}
+
+	for _, family := range families {
+		if !slices.Contains(AllRuleFamilies(), family) {
+			return nil, fmt.Errorf("unknown rule family %q", family)
 		}
+	}
 
-		waf, err := coraza.NewWAF(cfg)
+	files := []string{
+		"coraza.conf",
+		"crs-setup.conf",
+		"REQUEST-901-INITIALIZATION.conf",
+	}
+	for _, rf := range ruleFamilyFiles {
+		if strings.HasPrefix(rf.file, "RESPONSE-") && !slices.Contains(files, "REQUEST-949-BLOCKING-EVALUATION.conf") {
+			files = append(files, "REQUEST-949-BLOCKING-EVALUATION.conf")
+		}
+		if slices.Contains(families, rf.family) {
+			files = append(files, rf.file)
+		}
+	}
+	return append(files, "RESPONSE-959-BLOCKING-EVALUATION.conf"), nil
+}
+
+// crsSetup renders the engine mode, paranoia level and anomaly thresholds of
+// config as directives.
+func crsSetup(config Config) (string, error) {
+	engine := config.EngineMode
+	if engine == "" {
+		engine = EngineOn
+	}
+	if engine != EngineOn && engine != EngineDetectionOnly {
+		return "", fmt.Errorf("invalid engine mode %q", engine)
+	}
+
+	paranoia := config.ParanoiaLevel
+	if paranoia == 0 {
+		paranoia = 1
+	}
+	if paranoia < 1 || paranoia > 4 {
+		return "", fmt.Errorf("paranoia level must be between 1 and 4, got %d", paranoia)
+	}
+
+	inbound, outbound := config.InboundAnomalyThreshold, config.OutboundAnomalyThreshold
+	if inbound == 0 {
+		inbound = 5
+	}
+	if outbound == 0 {
+		outbound = 4
+	}
+	if inbound < 0 || outbound < 0 {
+		return "", fmt.Errorf("anomaly thresholds must be positive, got %d/%d", inbound, outbound)
+	}
+
+	directives := fmt.Sprintf(`SecRuleEngine %s
+SecAction "id:900000,phase:1,pass,t:none,nolog,tag:'OWASP_CRS',setvar:tx.blocking_paranoia_level=%d"
+SecAction "id:900110,phase:1,pass,t:none,nolog,tag:'OWASP_CRS',setvar:tx.inbound_anomaly_score_threshold=%d,setvar:tx.outbound_anomaly_score_threshold=%d"
+`, engine, paranoia, inbound, outbound)
+
+	// The middleware hands the WAF up to MaxBodySize bytes, Coraza must not
+	// reject them with its own smaller limit.
+	limit, err := wafBodyLimit(config)
+	if err != nil {
+		return "", err
+	}
+	directives += fmt.Sprintf("SecRequestBodyLimit %d\n", limit)
+
+	return directives, nil
+}
+
+// customRules reads the user supplied rule files and inline directives of
+// config into one block of directives.
+func customRules(config Config) (string, error) {
+	var sb strings.Builder
+
+	for _, file := range config.RuleFiles {
+		var data []byte
+		var err error
+		if config.RulesFS != nil {
+			data, err = fs.ReadFile(config.RulesFS, file)
+		} else {
+			data, err = os.ReadFile(file)
+		}
 		if err != nil {
-			initErr = fmt.Errorf("failed to initialize coraza waf: %w", err)
-			return
+			return "", fmt.Errorf("failed to read rule file %s: %w", file, err)
 		}
+		sb.Write(data)
+		sb.WriteString("\n")
+	}
 
-		instance = &WAFWrapper{waf: waf}
+	for _, directive := range config.RuleDirectives {
+		sb.WriteString(directive)
+		sb.WriteString("\n")
+	}
 
-	})
-	return instance, initErr
+	return sb.String(), nil
 }
 
 func parseRuleFile(cfg coraza.WAFConfig, filename string) (coraza.WAFConfig, error) {
@@ -84,40 +363,190 @@ func parseRuleFile(cfg coraza.WAFConfig, filename string) (coraza.WAFConfig, err
 	return cfg.WithDirectives(string(data)), nil
 }
 
-func (w *WAFWrapper) Check(r *http.Request) (bool, error) {
-	tx := w.waf.NewTransaction()
-	defer tx.Close()
+func (w *WAFWrapper) Check(r *http.Request) (WAFResult, error) {
+	result, check, err := w.CheckRequest(r)
+	if err != nil {
+		return result, err
+	}
+	check.Close()
+	return result, nil
+}
+
+// CheckRequest runs the request phases like Check but keeps the transaction
+// open, so the response can be inspected through the returned ResponseCheck.
+// The caller has to Close it.
+func (w *WAFWrapper) CheckRequest(r *http.Request) (WAFResult, ResponseCheck, error) {
+	tx := (*w.waf.Load()).NewTransaction()
 
+	result, err := processRequest(tx, r)
+	if err != nil {
+		tx.Close()
+		return WAFResult{}, nil, err
+	}
+	return result, &responseCheck{tx: tx}, nil
+}
+
+func processRequest(tx types.Transaction, r *http.Request) (WAFResult, error) {
 	tx.ProcessConnection(r.RemoteAddr, 0, "", 0)
 	tx.ProcessURI(r.URL.String(), r.Method, r.Proto)
 
+	// net/http moves Host out of r.Header, the protocol enforcement rules expect it
+	if r.Host != "" {
+		tx.AddRequestHeader("Host", r.Host)
+	}
 	for k, vv := range r.Header {
 		for _, v := range vv {
 			tx.AddRequestHeader(k, v)
 		}
 	}
 	if it := tx.ProcessRequestHeaders(); it != nil {
-		return true, nil
+		return buildResult(tx, false), nil
 	}
 
 	if r.Body != nil && r.Body != http.NoBody {
-		bodyBytes, err := io.ReadAll(r.Body)
+		// A body that can't be read again is buffered so r keeps it. The
+		// middleware sets GetBody to replay its own copy instead.
+		if r.GetBody == nil {
+			bodyBytes, err := io.ReadAll(r.Body)
+			if err != nil {
+				return WAFResult{}, fmt.Errorf("failed to read request body: %w", err)
+			}
+			r.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(bodyBytes)), nil
+			}
+			r.Body, _ = r.GetBody()
+		}
+
+		it, _, err := tx.ReadRequestBodyFrom(r.Body)
 		if err != nil {
-			return false, fmt.Errorf("failed to read request body: %w", err)
+			return WAFResult{}, fmt.Errorf("failed to write body to waf: %w", err)
+		}
+		if r.Body, err = r.GetBody(); err != nil {
+			return WAFResult{}, fmt.Errorf("failed to restore request body: %w", err)
+		}
+		if it != nil {
+			return buildResult(tx, false), nil
+		}
+	}
+
+	if _, err := tx.ProcessRequestBody(); err != nil {
+		return WAFResult{}, fmt.Errorf("failed to process request body: %w", err)
+	}
+
+	return buildResult(tx, false), nil
+}
+
+// CheckExchange runs the request phases on x as it is, reading the part of
+// the body to inspect from body.
+func (w *WAFWrapper) CheckExchange(x Exchange, body io.Reader) (WAFResult, error) {
+	tx := (*w.waf.Load()).NewTransaction()
+	defer tx.Close()
+
+	tx.ProcessConnection(x.RemoteAddr(), 0, "", 0)
+	tx.ProcessURI(x.RequestURI(), x.Method(), x.Proto())
+	x.VisitHeaders(tx.AddRequestHeader)
+	if it := tx.ProcessRequestHeaders(); it != nil {
+		return buildResult(tx, false), nil
+	}
+
+	it, _, err := tx.ReadRequestBodyFrom(body)
+	if err != nil {
+		return WAFResult{}, fmt.Errorf("failed to write body to waf: %w", err)
+	}
+	if it != nil {
+		return buildResult(tx, false), nil
+	}
+
+	if _, err := tx.ProcessRequestBody(); err != nil {
+		return WAFResult{}, fmt.Errorf("failed to process request body: %w", err)
+	}
+
+	return buildResult(tx, false), nil
+}
+
+type responseCheck struct {
+	tx types.Transaction
+}
+
+// CheckResponse runs the response phases. A transaction the request phases
+// already interrupted cannot continue, so it reports no response matches.
+func (c *responseCheck) CheckResponse(status int, header http.Header, body []byte) (WAFResult, error) {
+	if c.tx.IsInterrupted() {
+		return WAFResult{}, nil
+	}
+
+	for k, vv := range header {
+		for _, v := range vv {
+			c.tx.AddResponseHeader(k, v)
+		}
+	}
+	if it := c.tx.ProcessResponseHeaders(status, "HTTP/1.1"); it != nil {
+		return buildResult(c.tx, true), nil
+	}
+
+	if c.tx.IsResponseBodyAccessible() && c.tx.IsResponseBodyProcessable() {
+		if _, _, err := c.tx.WriteResponseBody(body); err != nil {
+			return WAFResult{}, fmt.Errorf("failed to write response body to waf: %w", err)
 		}
+	}
+
+	if _, err := c.tx.ProcessResponseBody(); err != nil {
+		return WAFResult{}, fmt.Errorf("failed to process response body: %w", err)
+	}
+
+	return buildResult(c.tx, true), nil
+}
+
+func (c *responseCheck) Close() error {
+	return c.tx.Close()
+}
 
-		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+// Matched values can be whole request bodies, only a prefix is kept.
+const maxMatchedValueLen = 256
 
-		if _, _, err := tx.WriteRequestBody(bodyBytes); err != nil {
-			return false, fmt.Errorf("failed to write body to waf: %w", err)
+// buildResult collects the matches of the request phases, or of the response
+// phases when response is set, along with the matching anomaly score.
+func buildResult(tx types.Transaction, response bool) WAFResult {
+	result := WAFResult{Blocked: tx.IsInterrupted()}
+
+	scoreVar := "blocking_inbound_anomaly_score"
+	if response {
+		scoreVar = "blocking_outbound_anomaly_score"
+	}
+	if state, ok := tx.(plugintypes.TransactionState); ok {
+		if score := state.Variables().TX().Get(scoreVar); len(score) > 0 {
+			result.AnomalyScore, _ = strconv.Atoi(score[0])
 		}
 	}
 
-	if it, err := tx.ProcessRequestBody(); err != nil {
-		return false, fmt.Errorf("failed to process request body: %w", err)
-	} else if it != nil {
-		return true, nil
+	for _, mr := range tx.MatchedRules() {
+		// CRS flow control rules (paranoia level skips, setup) match without a message
+		if mr.Message() == "" {
+			continue
+		}
+		if isResponse := mr.Rule().Phase() >= types.PhaseResponseHeaders; isResponse != response {
+			continue
+		}
+
+		match := RuleMatch{
+			RuleID:   mr.Rule().ID(),
+			Message:  mr.Message(),
+			Severity: mr.Rule().Severity().String(),
+			Tags:     mr.Rule().Tags(),
+		}
+		for _, md := range mr.MatchedDatas() {
+			name := md.Variable().Name()
+			if md.Key() != "" {
+				name += ":" + md.Key()
+			}
+			value := md.Value()
+			if len(value) > maxMatchedValueLen {
+				value = value[:maxMatchedValueLen]
+			}
+			match.Variables = append(match.Variables, MatchedVariable{Name: name, Value: value})
+		}
+		result.Matches = append(result.Matches, match)
 	}
 
-	return tx.IsInterrupted(), nil
+	return result
 }
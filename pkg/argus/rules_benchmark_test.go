@@ -7,10 +7,10 @@ import (
 	"testing"
 )
 
-var benchmarkResult bool
+var benchmarkResult WAFResult
 
 func BenchmarkWAF_Check_Clean(b *testing.B) {
-	waf, err := NewWAF()
+	waf, err := NewWAF(Config{})
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -21,16 +21,16 @@ func BenchmarkWAF_Check_Clean(b *testing.B) {
 	b.ResetTimer()
 
 	for b.Loop() {
-		isThreat, err := waf.Check(req)
+		result, err := waf.Check(req)
 		if err != nil {
 			b.Fatalf("Check failed: %v", err)
 		}
-		benchmarkResult = isThreat
+		benchmarkResult = result
 	}
 }
 
 func BenchmarkWAF_Check_SQLi(b *testing.B) {
-	waf, err := NewWAF()
+	waf, err := NewWAF(Config{})
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -41,16 +41,16 @@ func BenchmarkWAF_Check_SQLi(b *testing.B) {
 	b.ResetTimer()
 
 	for b.Loop() {
-		isThreat, err := waf.Check(req)
+		result, err := waf.Check(req)
 		if err != nil {
 			b.Fatalf("Check failed: %v", err)
 		}
-		benchmarkResult = isThreat
+		benchmarkResult = result
 	}
 }
 
 func BenchmarkWAF_Check_POST_JSON(b *testing.B) {
-	waf, err := NewWAF()
+	waf, err := NewWAF(Config{})
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -65,16 +65,16 @@ func BenchmarkWAF_Check_POST_JSON(b *testing.B) {
 
 	for b.Loop() {
 		req.Body = io.NopCloser(strings.NewReader(payload))
-		isThreat, err := waf.Check(req)
+		result, err := waf.Check(req)
 		if err != nil {
 			b.Fatalf("Check failed: %v", err)
 		}
-		benchmarkResult = isThreat
+		benchmarkResult = result
 	}
 }
 
 func BenchmarkWAF_Check_XSS(b *testing.B) {
-	waf, err := NewWAF()
+	waf, err := NewWAF(Config{})
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -88,16 +88,16 @@ func BenchmarkWAF_Check_XSS(b *testing.B) {
 		req := httptest.NewRequest("POST", "/profile", strings.NewReader(payload))
 		req.Header.Set("Content-Type", "application/json")
 
-		isThreat, err := waf.Check(req)
+		result, err := waf.Check(req)
 		if err != nil {
 			b.Fatalf("Check failed: %v", err)
 		}
-		benchmarkResult = isThreat
+		benchmarkResult = result
 	}
 }
 
 func BenchmarkWAF_Check_Parallel(b *testing.B) {
-	waf, err := NewWAF()
+	waf, err := NewWAF(Config{})
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -112,17 +112,17 @@ func BenchmarkWAF_Check_Parallel(b *testing.B) {
 			req := httptest.NewRequest("POST", "/api/data", strings.NewReader(payload))
 			req.Header.Set("Content-Type", "application/json")
 
-			isThreat, err := waf.Check(req)
+			result, err := waf.Check(req)
 			if err != nil {
 				b.Fatalf("Check failed: %v", err)
 			}
-			benchmarkResult = isThreat
+			benchmarkResult = result
 		}
 	})
 }
 
 func BenchmarkWAF_Check_Parallel_SQLi(b *testing.B) {
-	waf, err := NewWAF()
+	waf, err := NewWAF(Config{})
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -134,17 +134,17 @@ func BenchmarkWAF_Check_Parallel_SQLi(b *testing.B) {
 		for pb.Next() {
 			req := httptest.NewRequest("GET", "/api/search?q='%20OR%201=1%20--", nil)
 
-			isThreat, err := waf.Check(req)
+			result, err := waf.Check(req)
 			if err != nil {
 				b.Fatalf("Check failed: %v", err)
 			}
-			benchmarkResult = isThreat
+			benchmarkResult = result
 		}
 	})
 }
 
 func BenchmarkWAF_Check_Parallel_XSS(b *testing.B) {
-	waf, err := NewWAF()
+	waf, err := NewWAF(Config{})
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -159,11 +159,11 @@ func BenchmarkWAF_Check_Parallel_XSS(b *testing.B) {
 			req := httptest.NewRequest("POST", "/profile", strings.NewReader(payload))
 			req.Header.Set("Content-Type", "application/json")
 
-			isThreat, err := waf.Check(req)
+			result, err := waf.Check(req)
 			if err != nil {
 				b.Fatalf("Check failed: %v", err)
 			}
-			benchmarkResult = isThreat
+			benchmarkResult = result
 		}
 	})
 }
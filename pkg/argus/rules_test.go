@@ -1,15 +1,17 @@
 package argus
 
 import (
+	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"strings"
 	"testing"
 )
 
 func TestCoraza(t *testing.T) {
 	t.Run("successfully block SQLi from request URL", func(t *testing.T) {
-		waf, err := NewWAF()
+		waf, err := NewWAF(Config{})
 		if err != nil {
 			t.Fatalf("Failed to init WAF: %v", err)
 		}
@@ -18,18 +20,18 @@ func TestCoraza(t *testing.T) {
 		path := "/search?q=" + url.QueryEscape(payload)
 		req := httptest.NewRequest("GET", path, nil)
 
-		isThreat, err := waf.Check(req)
+		result, err := waf.Check(req)
 		if err != nil {
 			t.Fatalf("WAF check failed: %v", err)
 		}
 
-		if !isThreat {
+		if !result.Blocked {
 			t.Error("Expected SQLi to be blocked, but it passed")
 		}
 	})
 
 	t.Run("successfully block XSS attack from request URL", func(t *testing.T) {
-		waf, err := NewWAF()
+		waf, err := NewWAF(Config{})
 		if err != nil {
 			t.Fatalf("Failed to init WAF: %v", err)
 		}
@@ -38,36 +40,36 @@ func TestCoraza(t *testing.T) {
 		path := "/search?q=" + url.QueryEscape(payload)
 		req := httptest.NewRequest("GET", path, nil)
 
-		isThreat, err := waf.Check(req)
+		result, err := waf.Check(req)
 		if err != nil {
 			t.Fatalf("WAF check failed: %v", err)
 		}
 
-		if !isThreat {
+		if !result.Blocked {
 			t.Error("Expected XSS to be blocked, but it passed")
 		}
 	})
 
 	t.Run("allow clean requests", func(t *testing.T) {
-		waf, err := NewWAF()
+		waf, err := NewWAF(Config{})
 		if err != nil {
 			t.Fatalf("Failed to init WAF: %v", err)
 		}
 
 		clean_req := httptest.NewRequest("GET", "/search?q=hello_world", nil)
 
-		isThreat, err := waf.Check(clean_req)
+		result, err := waf.Check(clean_req)
 		if err != nil {
 			t.Fatalf("WAF check failed: %v", err)
 		}
 
-		if isThreat {
+		if result.Blocked {
 			t.Error("Expected clean request to pass, but it was blocked")
 		}
 	})
 
 	t.Run("Successfully block SQLi from request body", func(t *testing.T) {
-		waf, err := NewWAF()
+		waf, err := NewWAF(Config{})
 		if err != nil {
 			t.Fatalf("Failed to init WAF: %v", err)
 		}
@@ -76,18 +78,18 @@ func TestCoraza(t *testing.T) {
 		req := httptest.NewRequest("POST", "/login", body)
 		req.Header.Set("Content-Type", "application/json")
 
-		isThreat, err := waf.Check(req)
+		result, err := waf.Check(req)
 		if err != nil {
 			t.Fatalf("WAF check failed: %v", err)
 		}
 
-		if !isThreat {
+		if !result.Blocked {
 			t.Error("Expected Body SQLi to be blocked, but it passed")
 		}
 	})
 
 	t.Run("Successfully block XSS attack from request body", func(t *testing.T) {
-		waf, err := NewWAF()
+		waf, err := NewWAF(Config{})
 		if err != nil {
 			t.Fatalf("Failed to init WAF: %v", err)
 		}
@@ -96,13 +98,295 @@ func TestCoraza(t *testing.T) {
 		req := httptest.NewRequest("POST", "/profile", safe_body)
 		req.Header.Set("Content-Type", "application/json")
 
-		isThreat, err := waf.Check(req)
+		result, err := waf.Check(req)
 		if err != nil {
 			t.Fatalf("WAF check failed: %v", err)
 		}
 
-		if isThreat {
+		if result.Blocked {
 			t.Error("Expected clean body to pass, but it was blocked")
 		}
 	})
+
+	t.Run("allow browser report content types", func(t *testing.T) {
+		waf, err := NewWAF(Config{})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		for _, contentType := range []string{"application/csp-report", "application/reports+json"} {
+			body := strings.NewReader(`{"csp-report": {"document-uri": "https://example.com/"}}`)
+			req := httptest.NewRequest("POST", "/reports", body)
+			req.Header.Set("Content-Type", contentType)
+
+			result, err := waf.Check(req)
+			if err != nil {
+				t.Fatalf("WAF check failed: %v", err)
+			}
+
+			if result.Blocked {
+				t.Errorf("Expected %s report to pass, but it was blocked", contentType)
+			}
+		}
+	})
+
+	t.Run("block content types outside the allowed list", func(t *testing.T) {
+		waf, err := NewWAF(Config{})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		req := httptest.NewRequest("POST", "/reports", strings.NewReader("hello"))
+		req.Header.Set("Content-Type", "application/x-unknown")
+
+		result, err := waf.Check(req)
+		if err != nil {
+			t.Fatalf("WAF check failed: %v", err)
+		}
+
+		if !result.Blocked {
+			t.Error("Expected unknown content type to be blocked, but it passed")
+		}
+	})
+}
+
+func TestCorazaRuleFamilies(t *testing.T) {
+	t.Run("block path traversal with LFI family", func(t *testing.T) {
+		waf, err := NewWAF(Config{})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/download?file="+url.QueryEscape("../../../../etc/passwd"), nil)
+
+		result, err := waf.Check(req)
+		if err != nil {
+			t.Fatalf("WAF check failed: %v", err)
+		}
+
+		if !result.Blocked {
+			t.Error("Expected path traversal to be blocked, but it passed")
+		}
+	})
+
+	t.Run("block command injection with RCE family", func(t *testing.T) {
+		waf, err := NewWAF(Config{})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/ping?host="+url.QueryEscape("127.0.0.1; cat /etc/shadow"), nil)
+
+		result, err := waf.Check(req)
+		if err != nil {
+			t.Fatalf("WAF check failed: %v", err)
+		}
+
+		if !result.Blocked {
+			t.Error("Expected command injection to be blocked, but it passed")
+		}
+	})
+
+	t.Run("only load selected families", func(t *testing.T) {
+		waf, err := NewWAF(Config{RuleFamilies: []RuleFamily{FamilySQLi}})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		xss := httptest.NewRequest("GET", "/search?q="+url.QueryEscape("<script>alert(1)</script>"), nil)
+		result, err := waf.Check(xss)
+		if err != nil {
+			t.Fatalf("WAF check failed: %v", err)
+		}
+		if result.Blocked {
+			t.Error("Expected XSS to pass when only SQLi family is loaded")
+		}
+
+		sqli := httptest.NewRequest("GET", "/search?q="+url.QueryEscape("' OR 1=1"), nil)
+		result, err = waf.Check(sqli)
+		if err != nil {
+			t.Fatalf("WAF check failed: %v", err)
+		}
+		if !result.Blocked {
+			t.Error("Expected SQLi to be blocked by the SQLi family")
+		}
+	})
+
+	t.Run("reject unknown family", func(t *testing.T) {
+		if _, err := NewWAF(Config{RuleFamilies: []RuleFamily{"999"}}); err == nil {
+			t.Error("Expected error for unknown rule family, got nil")
+		}
+	})
+
+	t.Run("parse families from env string", func(t *testing.T) {
+		families, err := ParseRuleFamilies(" 930, 942 ,")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(families) != 2 || families[0] != FamilyLFI || families[1] != FamilySQLi {
+			t.Errorf("Expected [930 942], got %v", families)
+		}
+
+		if _, err := ParseRuleFamilies("930,abc"); err == nil {
+			t.Error("Expected error for unknown family in list, got nil")
+		}
+	})
+}
+
+func TestCorazaResultDetails(t *testing.T) {
+	waf, err := NewWAF(Config{})
+	if err != nil {
+		t.Fatalf("Failed to init WAF: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/search?q="+url.QueryEscape("' OR 1=1"), nil)
+
+	result, err := waf.Check(req)
+	if err != nil {
+		t.Fatalf("WAF check failed: %v", err)
+	}
+
+	if result.AnomalyScore < 5 {
+		t.Errorf("Expected inbound anomaly score of at least 5, got %d", result.AnomalyScore)
+	}
+
+	if !slices.Contains(result.AttackTags(), "attack-sqli") {
+		t.Errorf("Expected attack-sqli tag, got %v", result.AttackTags())
+	}
+
+	var sqliMatch *RuleMatch
+	for i, m := range result.Matches {
+		if m.RuleID >= 942000 && m.RuleID < 943000 {
+			sqliMatch = &result.Matches[i]
+			break
+		}
+	}
+	if sqliMatch == nil {
+		t.Fatalf("Expected a 942xxx rule to match, got %v", result.RuleIDs())
+	}
+
+	if sqliMatch.Message == "" || sqliMatch.Severity == "" {
+		t.Errorf("Expected message and severity on match, got %+v", sqliMatch)
+	}
+
+	if len(sqliMatch.Variables) == 0 || sqliMatch.Variables[0].Name != "ARGS:q" {
+		t.Errorf("Expected match on ARGS:q, got %+v", sqliMatch.Variables)
+	}
+}
+
+func TestCorazaCRSSettings(t *testing.T) {
+	sqli := func() *http.Request {
+		return httptest.NewRequest("GET", "/search?q="+url.QueryEscape("' OR 1=1"), nil)
+	}
+
+	t.Run("detection only scores without blocking", func(t *testing.T) {
+		waf, err := NewWAF(Config{EngineMode: EngineDetectionOnly})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		result, err := waf.Check(sqli())
+		if err != nil {
+			t.Fatalf("WAF check failed: %v", err)
+		}
+
+		if result.Blocked {
+			t.Error("Expected detection only engine to never block")
+		}
+		if len(result.Matches) == 0 || result.AnomalyScore == 0 {
+			t.Errorf("Expected matches to still be reported, got %+v", result)
+		}
+	})
+
+	t.Run("raised inbound threshold lets single match pass", func(t *testing.T) {
+		waf, err := NewWAF(Config{InboundAnomalyThreshold: 1000})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		result, err := waf.Check(sqli())
+		if err != nil {
+			t.Fatalf("WAF check failed: %v", err)
+		}
+
+		if result.Blocked {
+			t.Errorf("Expected score %d to stay under threshold 1000", result.AnomalyScore)
+		}
+	})
+
+	t.Run("higher paranoia level catches more", func(t *testing.T) {
+		req := func() *http.Request {
+			return httptest.NewRequest("GET", "/search?q="+url.QueryEscape("a'b--"), nil)
+		}
+
+		pl1, err := NewWAF(Config{})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+		pl4, err := NewWAF(Config{ParanoiaLevel: 4})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		low, _ := pl1.Check(req())
+		high, _ := pl4.Check(req())
+
+		if high.AnomalyScore <= low.AnomalyScore {
+			t.Errorf("Expected PL4 score above PL1 score, got %d <= %d", high.AnomalyScore, low.AnomalyScore)
+		}
+	})
+
+	t.Run("reject invalid settings", func(t *testing.T) {
+		invalid := []Config{
+			{ParanoiaLevel: 5},
+			{InboundAnomalyThreshold: -1},
+			{EngineMode: "Off"},
+		}
+		for _, cfg := range invalid {
+			if _, err := NewWAF(cfg); err == nil {
+				t.Errorf("Expected error for %+v, got nil", cfg)
+			}
+		}
+	})
+}
+
+func TestNewWAFInstances(t *testing.T) {
+	t.Run("return independent instances", func(t *testing.T) {
+		strict, err := NewWAF(Config{})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+		relaxed, err := NewWAF(Config{RuleFamilies: []RuleFamily{FamilySQLi}})
+		if err != nil {
+			t.Fatalf("Failed to init WAF: %v", err)
+		}
+
+		if strict == relaxed {
+			t.Fatal("Expected separate WAF instances")
+		}
+
+		req := func() *http.Request {
+			return httptest.NewRequest("GET", "/search?q="+url.QueryEscape("<script>alert(1)</script>"), nil)
+		}
+		if result, _ := strict.Check(req()); !result.Blocked {
+			t.Error("Expected strict profile to block XSS")
+		}
+		if result, _ := relaxed.Check(req()); result.Blocked {
+			t.Error("Expected relaxed profile to let XSS through")
+		}
+	})
+
+	t.Run("failed build does not affect later builds", func(t *testing.T) {
+		if _, err := NewWAF(Config{RuleDirectives: []string{`SecRule ARGS "@rx (" "id:10003,phase:1,deny"`}}); err == nil {
+			t.Fatal("Expected error for bad rule, got nil")
+		}
+
+		waf, err := NewWAF(Config{})
+		if err != nil {
+			t.Fatalf("Expected clean build after failed one, got: %v", err)
+		}
+		if waf == nil {
+			t.Fatal("Expected WAF instance, got nil")
+		}
+	})
 }
@@ -0,0 +1,14 @@
+package argus
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/priyansh-dimri/argus/pkg/argus"
+
+// tracer is looked up on every span so a tracer provider set after the
+// middleware was built still takes effect.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
@@ -0,0 +1,125 @@
+package argus
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans sets a global tracer provider recording every span for the
+// rest of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func endedSpan(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("Expected span %s to have ended", name)
+	return nil
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing(t *testing.T) {
+	t.Run("Client propagates the middleware trace to the backend", func(t *testing.T) {
+		recorder := recordSpans(t)
+
+		traceparents := make(chan string, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceparents <- r.Header.Get("traceparent")
+			isThreat := true
+			json.NewEncoder(w).Encode(protocol.AnalysisResponse{IsThreat: &isThreat})
+		}))
+		defer server.Close()
+
+		client := NewClient(server.URL, "key", time.Second)
+		mw := NewMiddleware(client, &MockWAF{}, Config{Mode: Paranoid})
+		rec := httptest.NewRecorder()
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+			ServeHTTP(rec, httptest.NewRequest("POST", "/login", nil))
+		mw.Close(context.Background())
+
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("Expected 403, got %d", rec.Code)
+		}
+
+		protect := endedSpan(t, recorder, "argus.Protect")
+		waf := endedSpan(t, recorder, "argus.waf.Check")
+		send := endedSpan(t, recorder, "argus.client.SendAnalysis")
+
+		traceID := protect.SpanContext().TraceID()
+		for _, span := range []sdktrace.ReadOnlySpan{waf, send} {
+			if span.Parent().SpanID() != protect.SpanContext().SpanID() {
+				t.Errorf("Expected %s to be a child of argus.Protect", span.Name())
+			}
+		}
+		if send.SpanKind() != trace.SpanKindClient {
+			t.Errorf("Expected a client span, got %s", send.SpanKind())
+		}
+
+		traceparent := <-traceparents
+		want := "00-" + traceID.String() + "-" + send.SpanContext().SpanID().String() + "-01"
+		if traceparent != want {
+			t.Errorf("Expected traceparent %s, got %s", want, traceparent)
+		}
+
+		if got := spanAttribute(protect, "argus.ai.verdict").AsString(); got != "threat" {
+			t.Errorf("Expected verdict attribute threat, got %q", got)
+		}
+		if !spanAttribute(protect, "argus.blocked").AsBool() {
+			t.Error("Expected argus.blocked on the request span")
+		}
+		if got := spanAttribute(protect, "argus.mode").AsString(); got != string(Paranoid) {
+			t.Errorf("Expected mode attribute %s, got %q", Paranoid, got)
+		}
+	})
+
+	t.Run("Fallbacks are span events", func(t *testing.T) {
+		recorder := recordSpans(t)
+
+		sender := &MockBatchSender{MockSender: MockSender{Err: context.DeadlineExceeded}}
+		mw := NewMiddleware(sender, &MockWAF{}, Config{Mode: Paranoid})
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+			ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+		mw.Close(context.Background())
+
+		events := endedSpan(t, recorder, "argus.Protect").Events()
+		if len(events) != 1 || events[0].Name != "argus.fallback" {
+			t.Fatalf("Expected one argus.fallback event, got %v", events)
+		}
+		for _, kv := range events[0].Attributes {
+			if kv.Key == "argus.fallback.decision" && kv.Value.AsString() != "allow" {
+				t.Errorf("Expected decision allow, got %s", kv.Value.AsString())
+			}
+		}
+	})
+}
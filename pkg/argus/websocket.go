@@ -0,0 +1,516 @@
+package argus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultMaxWebSocketMessage = 1 << 20
+
+// Close codes of RFC 6455, section 7.4.1.
+const (
+	closeProtocolError   = 1002
+	closePolicyViolation = 1008
+	closeMessageTooBig   = 1009
+	closeTryAgainLater   = 1013
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opClose        = 0x8
+)
+
+// maxControlPayload is the largest payload of a control frame, RFC 6455,
+// section 5.5.
+const maxControlPayload = 125
+
+var (
+	errMessageBlocked = errors.New("argus: websocket message blocked")
+	errMessageTooBig  = errors.New("argus: websocket message too big")
+	errProtocolError  = errors.New("argus: websocket protocol error")
+)
+
+// WebSocketConfig turns on the inspection of WebSocket messages. Only Protect
+// inspects them, ServeExchange never sees the connection.
+type WebSocketConfig struct {
+	// Inspect hijacks upgraded connections and checks every text message the
+	// client sends like a request body, through the WAF and, depending on the
+	// mode, the analysis. A blocked message closes the connection with 1008
+	// (policy violation). The handshake is stripped of
+	// Sec-WebSocket-Extensions, since compressed messages can't be inspected.
+	Inspect bool
+	// MaxClientMessageSize and MaxServerMessageSize limit the messages of each
+	// direction. A larger one closes the connection with 1009 (message too
+	// big). Zero means 1MB.
+	MaxClientMessageSize int64
+	MaxServerMessageSize int64
+}
+
+func (c WebSocketConfig) limits() (client, server int64) {
+	client, server = c.MaxClientMessageSize, c.MaxServerMessageSize
+	if client <= 0 {
+		client = defaultMaxWebSocketMessage
+	}
+	if server <= 0 {
+		server = defaultMaxWebSocketMessage
+	}
+	return client, server
+}
+
+// isWebSocketUpgrade reports whether r asks to upgrade to WebSocket.
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// websocketWriter hands the protected handler an inspected connection when
+// it hijacks the upgraded one.
+type websocketWriter struct {
+	http.ResponseWriter
+	m *Middleware
+	r *http.Request
+}
+
+func (w *websocketWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *websocketWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, server := w.m.Config.WebSocket.limits()
+	c := &websocketConn{
+		Conn: conn,
+		br:   brw.Reader,
+		m:    w.m,
+		r:    w.r,
+		// messages outlive the handler the server cancels the context with
+		ctx:   context.WithoutCancel(w.r.Context()),
+		limit: client,
+		out:   frameTracker{limit: server, handshake: true},
+	}
+	return c, bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c)), nil
+}
+
+// websocketConn is a hijacked connection that holds each text message of the
+// client back until it is inspected, and tracks the frames the handler
+// writes to enforce MaxServerMessageSize.
+type websocketConn struct {
+	net.Conn
+	// br holds what the server read past the handshake
+	br  *bufio.Reader
+	m   *Middleware
+	r   *http.Request
+	ctx context.Context
+
+	limit   int64
+	pending []byte
+	readErr error
+	// message is the text or binary message being read
+	opcode  byte
+	size    int64
+	frames  []byte
+	payload []byte
+
+	mu     sync.Mutex
+	out    frameTracker
+	closed bool
+}
+
+func (c *websocketConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		c.pending, c.readErr = c.readFrame()
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readFrame reads one frame of the client and returns the bytes the handler
+// may read now. Control frames and binary messages go through as they come,
+// text frames once their message is complete and let through.
+func (c *websocketConn) readFrame() ([]byte, error) {
+	raw, err := readFrameHeader(c.br)
+	if err != nil {
+		return nil, err
+	}
+	fin, opcode, masked, length := parseFrameHeader(raw)
+
+	if opcode >= opClose {
+		if !fin || length > maxControlPayload {
+			c.fail(closeProtocolError, "Protocol error")
+			return nil, errProtocolError
+		}
+	} else {
+		if opcode != opContinuation {
+			c.opcode, c.size = opcode, 0
+		}
+		if length > c.limit-c.size {
+			c.fail(closeMessageTooBig, "Message too big")
+			return nil, errMessageTooBig
+		}
+		c.size += length
+	}
+
+	headerLen := len(raw)
+	if masked {
+		raw = append(raw, make([]byte, 4)...)
+		if _, err := io.ReadFull(c.br, raw[headerLen:]); err != nil {
+			return nil, err
+		}
+	}
+	frame := make([]byte, len(raw)+int(length))
+	copy(frame, raw)
+	if _, err := io.ReadFull(c.br, frame[len(raw):]); err != nil {
+		return nil, err
+	}
+
+	if opcode >= opClose || c.opcode != opText {
+		return frame, nil
+	}
+
+	payload := frame[len(raw):]
+	if masked {
+		key := raw[headerLen:]
+		unmasked := make([]byte, len(payload))
+		for i, b := range payload {
+			unmasked[i] = b ^ key[i%4]
+		}
+		payload = unmasked
+	}
+	c.frames = append(c.frames, frame...)
+	c.payload = append(c.payload, payload...)
+	if !fin {
+		return nil, nil
+	}
+
+	frames, message := c.frames, c.payload
+	c.frames, c.payload = nil, nil
+	if code, reason, ok := c.inspect(message); !ok {
+		c.fail(code, reason)
+		return nil, errMessageBlocked
+	}
+	return frames, nil
+}
+
+// inspect runs a text message through the middleware and returns the close
+// code and reason when it is not let through.
+func (c *websocketConn) inspect(message []byte) (code int, reason string, ok bool) {
+	x := newWebSocketMessage(c.r, message)
+	ctx, span := tracer().Start(c.ctx, "argus.websocket.Message", trace.WithAttributes(
+		attribute.String("url.path", c.r.URL.Path),
+		attribute.Int("argus.websocket.message_size", len(message)),
+	))
+	defer span.End()
+	ctx = withVerdict(withRequestID(ctx), c.m.mode())
+	c.m.serveExchange(&exchangeInbound{ctx: ctx, x: x})
+
+	if x.served {
+		return 0, "", true
+	}
+	reason = "(request ID: " + x.header.Get("X-Request-ID") + ")"
+	switch x.status {
+	case http.StatusRequestEntityTooLarge:
+		return closeMessageTooBig, "Message too big " + reason, false
+	case http.StatusServiceUnavailable:
+		return closeTryAgainLater, "Try again later " + reason, false
+	default:
+		return closePolicyViolation, "Message blocked " + reason, false
+	}
+}
+
+func (c *websocketConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+
+	n, err := c.out.feed(p)
+	written, werr := c.Conn.Write(p[:n])
+	if werr != nil {
+		return written, werr
+	}
+	if err != nil {
+		c.closeLocked(closeMessageTooBig, "Message too big")
+		return written, err
+	}
+	return len(p), nil
+}
+
+// fail closes the connection, sending a close frame first unless the handler
+// is in the middle of writing one of its own frames.
+func (c *websocketConn) fail(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closeLocked(code, reason)
+	}
+}
+
+func (c *websocketConn) closeLocked(code int, reason string) {
+	if c.out.atBoundary() {
+		c.Conn.Write(closeFrame(code, reason))
+	}
+	c.closed = true
+	c.Conn.Close()
+}
+
+func (c *websocketConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// readFrameHeader reads a frame header up to its masking key.
+func readFrameHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	switch header[1] & 0x7f {
+	case 126:
+		header = header[:4]
+	case 127:
+		header = header[:10]
+	default:
+		return header, nil
+	}
+	if _, err := io.ReadFull(r, header[2:]); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// frameHeaderSize returns the size of the frame header starting with b, and
+// false while b is too short to tell.
+func frameHeaderSize(b []byte) (int, bool) {
+	if len(b) < 2 {
+		return 0, false
+	}
+	size := 2
+	switch b[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if b[1]&0x80 != 0 {
+		size += 4
+	}
+	return size, len(b) >= size
+}
+
+func parseFrameHeader(b []byte) (fin bool, opcode byte, masked bool, length int64) {
+	fin, opcode, masked = b[0]&0x80 != 0, b[0]&0x0f, b[1]&0x80 != 0
+	switch n := b[1] & 0x7f; n {
+	case 126:
+		length = int64(binary.BigEndian.Uint16(b[2:4]))
+	case 127:
+		length = int64(binary.BigEndian.Uint64(b[2:10]) & (1<<63 - 1))
+	default:
+		length = int64(n)
+	}
+	return fin, opcode, masked, length
+}
+
+// closeFrame is an unmasked close frame, as servers send them.
+func closeFrame(code int, reason string) []byte {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	frame := []byte{0x80 | opClose, byte(2 + len(reason)), 0, 0}
+	binary.BigEndian.PutUint16(frame[2:], uint16(code))
+	return append(frame, reason...)
+}
+
+// frameTracker follows the frames a handler writes, past its handshake
+// response, to measure its messages without buffering them.
+type frameTracker struct {
+	limit int64
+	// handshake is set until the end of the handshake response, response
+	// holds it so far
+	handshake bool
+	response  []byte
+	// passthrough is set when the handshake was refused
+	passthrough bool
+
+	header    []byte
+	remaining int64
+	size      int64
+	// torn is set when writing stopped inside a frame header
+	torn bool
+}
+
+// atBoundary reports whether a frame can be written without corrupting one
+// the handler is writing.
+func (t *frameTracker) atBoundary() bool {
+	return !t.handshake && !t.torn && len(t.header) == 0 && t.remaining == 0
+}
+
+// feed tracks p and returns how much of it can be written. It stops at the
+// header of a frame that takes its message over the limit.
+func (t *frameTracker) feed(p []byte) (int, error) {
+	if t.passthrough {
+		return len(p), nil
+	}
+	i := 0
+	for i < len(p) {
+		if t.handshake {
+			t.response = append(t.response, p[i])
+			i++
+			if !bytes.HasSuffix(t.response, []byte("\r\n\r\n")) {
+				if len(t.response) > 16<<10 {
+					t.passthrough = true
+					return len(p), nil
+				}
+				continue
+			}
+			if !bytes.HasPrefix(t.response, []byte("HTTP/1.1 101")) {
+				t.passthrough = true
+				return len(p), nil
+			}
+			t.handshake, t.response = false, nil
+			continue
+		}
+
+		if t.remaining > 0 {
+			n := int64(len(p) - i)
+			if n > t.remaining {
+				n = t.remaining
+			}
+			i += int(n)
+			t.remaining -= n
+			continue
+		}
+
+		t.header = append(t.header, p[i])
+		i++
+		if _, ok := frameHeaderSize(t.header); !ok {
+			continue
+		}
+		_, opcode, _, length := parseFrameHeader(t.header)
+		start := i - len(t.header)
+		t.header = t.header[:0]
+		if opcode < opClose {
+			if opcode != opContinuation {
+				t.size = 0
+			}
+			t.size += length
+			if t.size > t.limit {
+				if start < 0 {
+					// part of the header went out with an earlier write
+					t.torn, start = true, 0
+				}
+				return start, errMessageTooBig
+			}
+		}
+		t.remaining = length
+	}
+	return len(p), nil
+}
+
+// websocketMessage is a text message of the client, presented to Argus as a
+// POST to the path of the handshake. A JSON message is the body as it is,
+// any other one the message field of a form.
+type websocketMessage struct {
+	r           *http.Request
+	body        []byte
+	contentType string
+
+	served bool
+	status int
+	header http.Header
+}
+
+var _ Exchange = (*websocketMessage)(nil)
+
+func newWebSocketMessage(r *http.Request, message []byte) *websocketMessage {
+	x := &websocketMessage{r: r, body: message, contentType: "application/json"}
+	if trimmed := bytes.TrimSpace(message); len(trimmed) == 0 || trimmed[0] != '{' && trimmed[0] != '[' || !json.Valid(trimmed) {
+		x.body = []byte("message=" + url.QueryEscape(string(message)))
+		x.contentType = "application/x-www-form-urlencoded"
+	}
+	return x
+}
+
+func (x *websocketMessage) Context() context.Context { return x.r.Context() }
+func (x *websocketMessage) Method() string           { return http.MethodPost }
+func (x *websocketMessage) RequestURI() string       { return x.r.RequestURI }
+func (x *websocketMessage) Path() string             { return x.r.URL.Path }
+func (x *websocketMessage) Proto() string            { return x.r.Proto }
+func (x *websocketMessage) RemoteAddr() string       { return x.r.RemoteAddr }
+func (x *websocketMessage) Body() []byte             { return x.body }
+func (x *websocketMessage) Next(context.Context)     { x.served = true }
+
+// VisitHeaders reports the handshake headers, less the ones of the upgrade
+// and of its body.
+func (x *websocketMessage) VisitHeaders(fn func(name, value string)) {
+	fn("Host", x.r.Host)
+	fn("Content-Type", x.contentType)
+	for name, values := range x.r.Header {
+		switch {
+		case name == "Upgrade", name == "Connection", name == "Content-Type", name == "Content-Length",
+			strings.HasPrefix(name, "Sec-Websocket-"):
+			continue
+		}
+		for _, value := range values {
+			fn(name, value)
+		}
+	}
+}
+
+func (x *websocketMessage) Respond(status int, header http.Header, body []byte) {
+	x.status, x.header = status, header
+}
+
+func (x *websocketMessage) Request(ctx context.Context) *http.Request {
+	r := &http.Request{
+		Method:        http.MethodPost,
+		URL:           x.r.URL,
+		RequestURI:    x.r.RequestURI,
+		Proto:         x.r.Proto,
+		ProtoMajor:    x.r.ProtoMajor,
+		ProtoMinor:    x.r.ProtoMinor,
+		Header:        make(http.Header),
+		Host:          x.r.Host,
+		ContentLength: int64(len(x.body)),
+		Body:          io.NopCloser(bytes.NewReader(x.body)),
+		RemoteAddr:    x.r.RemoteAddr,
+	}
+	x.VisitHeaders(func(name, value string) {
+		if name != "Host" {
+			r.Header.Add(name, value)
+		}
+	})
+	return r.WithContext(ctx)
+}
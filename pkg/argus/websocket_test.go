@@ -0,0 +1,392 @@
+package argus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+// bodyWAF blocks requests whose body contains "attack".
+type bodyWAF struct{}
+
+func (bodyWAF) Check(r *http.Request) (WAFResult, error) {
+	body, _ := io.ReadAll(r.Body)
+	return WAFResult{Blocked: bytes.Contains(body, []byte("attack"))}, nil
+}
+
+// messageSender flags every request with a body as a threat.
+type messageSender struct {
+	MockSender
+}
+
+func (m *messageSender) SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	m.SentReq = req
+	isThreat := req.Log != ""
+	return protocol.AnalysisResponse{IsThreat: &isThreat}, nil
+}
+
+// wsEcho completes the handshake on the hijacked connection and echoes every
+// client frame back unmasked. What ended it is sent on done.
+type wsEcho struct {
+	header http.Header
+	done   chan error
+}
+
+func (h *wsEcho) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.header = r.Header.Clone()
+	conn, brw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		h.done <- err
+		return
+	}
+	defer conn.Close()
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	brw.Flush()
+
+	for {
+		fin, opcode, payload, err := readTestFrame(brw.Reader)
+		if err != nil {
+			h.done <- err
+			return
+		}
+		if _, err := conn.Write(testFrame(fin, opcode, payload, false)); err != nil {
+			h.done <- err
+			return
+		}
+	}
+}
+
+func testFrame(fin bool, opcode byte, payload []byte, mask bool) []byte {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	var b1 byte
+	if mask {
+		b1 = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, b1|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, b1|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, b1|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if !mask {
+		return append(frame, payload...)
+	}
+	key := []byte{1, 2, 3, 4}
+	frame = append(frame, key...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i%4])
+	}
+	return frame
+}
+
+func readTestFrame(r io.Reader) (fin bool, opcode byte, payload []byte, err error) {
+	header, err := readFrameHeader(r)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode, masked, length := parseFrameHeader(header)
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// dialWebSocket sends a handshake to srv and returns the upgraded connection.
+func dialWebSocket(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ws", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Failed to write handshake: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("Failed to read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+	return conn, br
+}
+
+// expectClose reads frames until a close frame and checks its code.
+func expectClose(t *testing.T, br *bufio.Reader, code int) {
+	t.Helper()
+	for {
+		_, opcode, payload, err := readTestFrame(br)
+		if err != nil {
+			t.Fatalf("Expected close frame %d, got %v", code, err)
+		}
+		if opcode != opClose {
+			continue
+		}
+		if got := int(binary.BigEndian.Uint16(payload)); got != code {
+			t.Errorf("Expected close code %d, got %d (%s)", code, got, payload[2:])
+		}
+		return
+	}
+}
+
+func TestWebSocketInspection(t *testing.T) {
+	serve := func(t *testing.T, config Config, sender AnalysisSender) (*httptest.Server, *wsEcho) {
+		mw := NewMiddleware(sender, bodyWAF{}, config)
+		echo := &wsEcho{done: make(chan error, 1)}
+		srv := httptest.NewServer(mw.Protect(echo))
+		t.Cleanup(srv.Close)
+		return srv, echo
+	}
+	inspect := Config{Mode: LatencyFirst, WebSocket: WebSocketConfig{Inspect: true}}
+
+	t.Run("lets clean messages through", func(t *testing.T) {
+		srv, echo := serve(t, inspect, &MockSender{})
+		conn, br := dialWebSocket(t, srv)
+
+		for _, message := range []string{"hello", `{"op":"subscribe","topic":"prices"}`} {
+			conn.Write(testFrame(true, opText, []byte(message), true))
+			_, opcode, payload, err := readTestFrame(br)
+			if err != nil {
+				t.Fatalf("Failed to read echo: %v", err)
+			}
+			if opcode != opText || string(payload) != message {
+				t.Errorf("Expected echo %q, got opcode %d %q", message, opcode, payload)
+			}
+		}
+		if echo.header.Get("Sec-WebSocket-Extensions") != "" {
+			t.Errorf("Expected extensions to be stripped from the handshake, got %q", echo.header.Get("Sec-WebSocket-Extensions"))
+		}
+	})
+
+	t.Run("closes the connection on a blocked message", func(t *testing.T) {
+		srv, echo := serve(t, inspect, &MockSender{})
+		conn, br := dialWebSocket(t, srv)
+
+		conn.Write(testFrame(true, opText, []byte("an attack"), true))
+		expectClose(t, br, closePolicyViolation)
+		if err := <-echo.done; !errors.Is(err, errMessageBlocked) {
+			t.Errorf("Expected the handler to read errMessageBlocked, got %v", err)
+		}
+	})
+
+	t.Run("inspects fragmented messages as a whole", func(t *testing.T) {
+		srv, echo := serve(t, inspect, &MockSender{})
+		conn, br := dialWebSocket(t, srv)
+
+		conn.Write(testFrame(false, opText, []byte("an at"), true))
+		conn.Write(testFrame(true, 0x9, []byte("ping"), true))
+		conn.Write(testFrame(true, opContinuation, []byte("tack"), true))
+
+		_, opcode, payload, err := readTestFrame(br)
+		if err != nil || opcode != 0x9 || string(payload) != "ping" {
+			t.Errorf("Expected the ping to go through first, got opcode %d %q %v", opcode, payload, err)
+		}
+		expectClose(t, br, closePolicyViolation)
+		if err := <-echo.done; !errors.Is(err, errMessageBlocked) {
+			t.Errorf("Expected the handler to read errMessageBlocked, got %v", err)
+		}
+	})
+
+	t.Run("closes on a threat the analysis finds", func(t *testing.T) {
+		sender := &messageSender{}
+		config := Config{Mode: Paranoid, WebSocket: WebSocketConfig{Inspect: true}}
+		srv, _ := serve(t, config, sender)
+		conn, br := dialWebSocket(t, srv)
+
+		conn.Write(testFrame(true, opText, []byte("drop table users"), true))
+		expectClose(t, br, closePolicyViolation)
+		if sender.SentReq.Log != "message=drop+table+users" || sender.SentReq.Route != "/ws" {
+			t.Errorf("Expected the message to be analyzed on its route, got %+v", sender.SentReq)
+		}
+		if sender.SentReq.Method != http.MethodPost {
+			t.Errorf("Expected the message to be sent as a POST, got %q", sender.SentReq.Method)
+		}
+	})
+
+	t.Run("limits client messages", func(t *testing.T) {
+		config := inspect
+		config.WebSocket.MaxClientMessageSize = 8
+		srv, echo := serve(t, config, &MockSender{})
+		conn, br := dialWebSocket(t, srv)
+
+		conn.Write(testFrame(false, 0x2, []byte("0123"), true))
+		conn.Write(testFrame(true, opContinuation, []byte("456789"), true))
+		_, _, payload, err := readTestFrame(br)
+		if err != nil || string(payload) != "0123" {
+			t.Errorf("Expected binary frames to stream through, got %q %v", payload, err)
+		}
+		expectClose(t, br, closeMessageTooBig)
+		if err := <-echo.done; !errors.Is(err, errMessageTooBig) {
+			t.Errorf("Expected the handler to read errMessageTooBig, got %v", err)
+		}
+	})
+
+	t.Run("rejects oversized control frames", func(t *testing.T) {
+		srv, echo := serve(t, inspect, &MockSender{})
+		conn, br := dialWebSocket(t, srv)
+
+		conn.Write(testFrame(true, 0x9, bytes.Repeat([]byte("p"), maxControlPayload+1), true))
+		expectClose(t, br, closeProtocolError)
+		if err := <-echo.done; !errors.Is(err, errProtocolError) {
+			t.Errorf("Expected the handler to read errProtocolError, got %v", err)
+		}
+	})
+
+	t.Run("checks the frame length before reading it", func(t *testing.T) {
+		srv, echo := serve(t, inspect, &MockSender{})
+		conn, br := dialWebSocket(t, srv)
+
+		// a header announcing the largest length, with no payload behind it
+		header := []byte{0x80 | opText, 0x80 | 127}
+		header = binary.BigEndian.AppendUint64(header, 1<<63-1)
+		conn.Write(append(header, 1, 2, 3, 4))
+		expectClose(t, br, closeMessageTooBig)
+		if err := <-echo.done; !errors.Is(err, errMessageTooBig) {
+			t.Errorf("Expected the handler to read errMessageTooBig, got %v", err)
+		}
+	})
+
+	t.Run("limits server messages", func(t *testing.T) {
+		config := inspect
+		config.WebSocket.MaxServerMessageSize = 8
+		srv, echo := serve(t, config, &MockSender{})
+		conn, br := dialWebSocket(t, srv)
+
+		conn.Write(testFrame(true, opText, []byte("short"), true))
+		if _, _, payload, err := readTestFrame(br); err != nil || string(payload) != "short" {
+			t.Fatalf("Expected echo %q, got %q %v", "short", payload, err)
+		}
+		conn.Write(testFrame(true, opText, []byte("much too long"), true))
+		expectClose(t, br, closeMessageTooBig)
+		if err := <-echo.done; !errors.Is(err, errMessageTooBig) {
+			t.Errorf("Expected the handler's write to fail with errMessageTooBig, got %v", err)
+		}
+	})
+
+	t.Run("leaves messages alone unless enabled", func(t *testing.T) {
+		srv, _ := serve(t, Config{Mode: LatencyFirst}, &MockSender{})
+		conn, br := dialWebSocket(t, srv)
+
+		conn.Write(testFrame(true, opText, []byte("an attack"), true))
+		if _, _, payload, err := readTestFrame(br); err != nil || string(payload) != "an attack" {
+			t.Errorf("Expected the message to go through, got %q %v", payload, err)
+		}
+	})
+}
+
+func TestFrameTracker(t *testing.T) {
+	t.Run("skips the handshake and stops at an oversized frame", func(t *testing.T) {
+		tracker := frameTracker{limit: 4, handshake: true}
+		stream := append([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"), testFrame(true, opText, []byte("ok"), false)...)
+		big := len(stream)
+		stream = append(stream, testFrame(true, opText, []byte("too big"), false)...)
+
+		// byte by byte, the way a handler might write
+		for i := range stream {
+			n, err := tracker.feed(stream[i : i+1])
+			if i < big+1 && (n != 1 || err != nil) {
+				t.Fatalf("Expected byte %d to pass, got %d %v", i, n, err)
+			}
+			if i == big+1 {
+				if n != 0 || !errors.Is(err, errMessageTooBig) {
+					t.Errorf("Expected the oversized header to be refused, got %d %v", n, err)
+				}
+				if tracker.atBoundary() {
+					t.Error("Expected a torn header not to be a frame boundary")
+				}
+				return
+			}
+		}
+	})
+
+	t.Run("passes a refused handshake through", func(t *testing.T) {
+		tracker := frameTracker{limit: 1, handshake: true}
+		response := []byte("HTTP/1.1 403 Forbidden\r\nContent-Length: 5\r\n\r\nnope!")
+		if n, err := tracker.feed(response); n != len(response) || err != nil {
+			t.Errorf("Expected the response to pass, got %d %v", n, err)
+		}
+		if n, err := tracker.feed(testFrame(true, opText, []byte("long"), false)); n != 6 || err != nil {
+			t.Errorf("Expected later bytes to pass, got %d %v", n, err)
+		}
+	})
+}
+
+func TestWebSocketMessageExchange(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/ws?room=1", nil)
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	r.Header.Set("Origin", "https://example.com")
+
+	tests := []struct {
+		message, body, contentType string
+	}{
+		{`{"text":"hi"}`, `{"text":"hi"}`, "application/json"},
+		{"hi there", "message=hi+there", "application/x-www-form-urlencoded"},
+		{"{not json", "message=%7Bnot+json", "application/x-www-form-urlencoded"},
+	}
+	for _, tt := range tests {
+		x := newWebSocketMessage(r, []byte(tt.message))
+		if string(x.Body()) != tt.body {
+			t.Errorf("Expected body %q for %q, got %q", tt.body, tt.message, x.Body())
+		}
+
+		req := x.Request(context.Background())
+		if req.Method != http.MethodPost || req.URL.RequestURI() != "/ws?room=1" || req.Host != "example.com" {
+			t.Errorf("Expected POST /ws?room=1 on example.com, got %s %s on %s", req.Method, req.URL.RequestURI(), req.Host)
+		}
+		if got := req.Header.Get("Content-Type"); got != tt.contentType {
+			t.Errorf("Expected content type %q, got %q", tt.contentType, got)
+		}
+		if req.Header.Get("Origin") != "https://example.com" {
+			t.Errorf("Expected the handshake headers, got %v", req.Header)
+		}
+		for _, name := range []string{"Upgrade", "Connection", "Sec-Websocket-Key"} {
+			if req.Header.Get(name) != "" {
+				t.Errorf("Expected %s to be left out, got %q", name, req.Header.Get(name))
+			}
+		}
+	}
+}
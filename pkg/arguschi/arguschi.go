@@ -0,0 +1,14 @@
+// Package arguschi protects chi routers with an argus.Middleware.
+package arguschi
+
+import (
+	"net/http"
+
+	"github.com/priyansh-dimri/argus/pkg/argus"
+)
+
+// Middleware returns mw as chi middleware, for r.Use or r.With. chi takes
+// net/http middleware as it is, so this is mw.Protect.
+func Middleware(mw *argus.Middleware) func(http.Handler) http.Handler {
+	return mw.Protect
+}
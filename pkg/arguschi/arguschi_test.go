@@ -0,0 +1,52 @@
+package arguschi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/priyansh-dimri/argus/pkg/argus"
+	"github.com/priyansh-dimri/argus/pkg/arguschi"
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+type queryWAF struct{}
+
+func (queryWAF) Check(r *http.Request) (argus.WAFResult, error) {
+	return argus.WAFResult{Blocked: strings.Contains(r.URL.RawQuery, "attack")}, nil
+}
+
+type nopSender struct{}
+
+func (nopSender) SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	return protocol.AnalysisResponse{}, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	mw := argus.NewMiddleware(nopSender{}, queryWAF{}, argus.Config{Mode: argus.LatencyFirst})
+	defer mw.Close(context.Background())
+
+	router := chi.NewRouter()
+	router.Use(arguschi.Middleware(mw))
+	router.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if argus.RequestIDFromContext(r.Context()) == "" {
+			t.Error("Expected a request ID in the request context")
+		}
+		w.Write([]byte(chi.URLParam(r, "id")))
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/users/42", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "42" {
+		t.Errorf("Expected 200 with the URL param, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/users/42?q=attack", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d", rec.Code)
+	}
+}
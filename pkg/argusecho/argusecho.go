@@ -0,0 +1,31 @@
+// Package argusecho protects echo servers with an argus.Middleware.
+package argusecho
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/priyansh-dimri/argus/pkg/argus"
+)
+
+// Middleware returns an echo middleware running requests through mw. Allowed
+// requests reach next with c.Request() carrying the request ID and Verdict in
+// its context.
+func Middleware(mw *argus.Middleware) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			res := c.Response()
+			mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.SetRequest(r)
+				c.SetResponse(echo.NewResponse(w, c.Echo()))
+				if err = next(c); err != nil {
+					// Written here, the error response goes through
+					// response inspection like any other.
+					c.Error(err)
+				}
+			})).ServeHTTP(res, c.Request())
+			c.SetResponse(res)
+			return err
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package argusecho_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/priyansh-dimri/argus/pkg/argus"
+	"github.com/priyansh-dimri/argus/pkg/argusecho"
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+// leakWAF blocks queries with "attack" in them and responses with "secret".
+type leakWAF struct{}
+
+func (leakWAF) Check(r *http.Request) (argus.WAFResult, error) {
+	return argus.WAFResult{Blocked: strings.Contains(r.URL.RawQuery, "attack")}, nil
+}
+
+func (w leakWAF) CheckRequest(r *http.Request) (argus.WAFResult, argus.ResponseCheck, error) {
+	result, err := w.Check(r)
+	return result, leakCheck{}, err
+}
+
+type leakCheck struct{}
+
+func (leakCheck) CheckResponse(status int, header http.Header, body []byte) (argus.WAFResult, error) {
+	if !bytes.Contains(body, []byte("secret")) {
+		return argus.WAFResult{}, nil
+	}
+	return argus.WAFResult{Blocked: true, Matches: []argus.RuleMatch{{RuleID: 950100}}}, nil
+}
+
+func (leakCheck) Close() error { return nil }
+
+type nopSender struct{}
+
+func (nopSender) SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	return protocol.AnalysisResponse{}, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	mw := argus.NewMiddleware(nopSender{}, leakWAF{}, argus.Config{
+		Mode:               argus.LatencyFirst,
+		InspectResponses:   true,
+		BlockResponseLeaks: true,
+	})
+	defer mw.Close(context.Background())
+
+	e := echo.New()
+	e.Use(argusecho.Middleware(mw))
+	e.GET("/users", func(c echo.Context) error {
+		if _, ok := argus.VerdictFromContext(c.Request().Context()); !ok {
+			t.Error("Expected the verdict in the request context")
+		}
+		return c.String(http.StatusOK, "users")
+	})
+	e.GET("/leak", func(c echo.Context) error {
+		return c.String(http.StatusOK, "the secret")
+	})
+	e.GET("/teapot", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusTeapot, "short and stout")
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		body   string
+	}{
+		{"Allowed", "/users", http.StatusOK, "users"},
+		{"Blocked", "/users?q=attack", http.StatusForbidden, "Blocked by Argus Shield"},
+		{"Leaking response", "/leak", http.StatusInternalServerError, "Response blocked by Argus Shield"},
+		{"Handler error", "/teapot", http.StatusTeapot, "short and stout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest("GET", tt.target, nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Errorf("Expected body with %q, got %q", tt.body, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Package argusfasthttp protects fasthttp handlers with an argus.Middleware.
+// Requests go through the WAF and the analysis as fasthttp holds them. They
+// are only converted to *http.Request for hooks.
+package argusfasthttp
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/priyansh-dimri/argus/pkg/argus"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// Protect returns next behind mw.
+func Protect(mw *argus.Middleware, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		Serve(mw, ctx, ctx, func(context.Context) { next(ctx) })
+	}
+}
+
+// Serve runs ctx through mw and calls next when the request is let through.
+// next gets parent with the request ID and Verdict added, the context Context
+// returns from then on. Adapters of frameworks built on fasthttp use it.
+func Serve(mw *argus.Middleware, ctx *fasthttp.RequestCtx, parent context.Context, next func(context.Context)) {
+	mw.ServeExchange(&exchange{ctx: ctx, parent: parent, next: next})
+}
+
+type contextKey struct{}
+
+// Context returns the context Argus gave the request, for
+// argus.VerdictFromContext and argus.RequestIDFromContext. It is ctx itself
+// outside of a protected handler.
+func Context(ctx *fasthttp.RequestCtx) context.Context {
+	if c, ok := ctx.UserValue(contextKey{}).(context.Context); ok {
+		return c
+	}
+	return ctx
+}
+
+type exchange struct {
+	ctx    *fasthttp.RequestCtx
+	parent context.Context
+	next   func(context.Context)
+}
+
+var _ argus.Exchange = (*exchange)(nil)
+
+func (x *exchange) Context() context.Context { return x.parent }
+func (x *exchange) Method() string           { return string(x.ctx.Method()) }
+func (x *exchange) RequestURI() string       { return string(x.ctx.RequestURI()) }
+func (x *exchange) Path() string             { return string(x.ctx.Path()) }
+func (x *exchange) Proto() string            { return string(x.ctx.Request.Header.Protocol()) }
+func (x *exchange) RemoteAddr() string       { return x.ctx.RemoteAddr().String() }
+func (x *exchange) Body() []byte             { return x.ctx.PostBody() }
+
+func (x *exchange) VisitHeaders(fn func(name, value string)) {
+	for name, value := range x.ctx.Request.Header.All() {
+		fn(string(name), string(value))
+	}
+}
+
+func (x *exchange) Next(ctx context.Context) {
+	x.ctx.SetUserValue(contextKey{}, ctx)
+	x.next(ctx)
+}
+
+func (x *exchange) Respond(status int, header http.Header, body []byte) {
+	for name, values := range header {
+		for i, value := range values {
+			if i == 0 {
+				x.ctx.Response.Header.Set(name, value)
+			} else {
+				x.ctx.Response.Header.Add(name, value)
+			}
+		}
+	}
+	x.ctx.SetStatusCode(status)
+	x.ctx.SetBody(body)
+}
+
+// Request is only valid while the request is served, the way fasthttpadaptor
+// converts it.
+func (x *exchange) Request(ctx context.Context) *http.Request {
+	r := new(http.Request)
+	if err := fasthttpadaptor.ConvertRequest(x.ctx, r, true); err != nil {
+		r = &http.Request{
+			Method:     x.Method(),
+			URL:        &url.URL{Path: x.Path()},
+			Header:     make(http.Header),
+			RemoteAddr: x.RemoteAddr(),
+			Body:       http.NoBody,
+		}
+	}
+	return r.WithContext(ctx)
+}
@@ -0,0 +1,31 @@
+package argusfasthttp_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/priyansh-dimri/argus/pkg/argus"
+	"github.com/priyansh-dimri/argus/pkg/argusfasthttp"
+	"github.com/valyala/fasthttp"
+)
+
+func BenchmarkProtect_Clean(b *testing.B) {
+	waf, err := argus.NewWAF(argus.Config{})
+	if err != nil {
+		b.Fatalf("Failed to create WAF: %v", err)
+	}
+	mw := argus.NewMiddleware(nopSender{}, waf, argus.Config{Mode: argus.LatencyFirst})
+	defer mw.Close(context.Background())
+
+	handler := argusfasthttp.Protect(mw, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	})
+	ctx := newRequestCtx("POST", "/api/users?page=2", `name=alice&email=alice@example.com`)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler(ctx)
+		ctx.Response.Reset()
+	}
+}
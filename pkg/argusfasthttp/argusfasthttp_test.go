@@ -0,0 +1,111 @@
+package argusfasthttp_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/priyansh-dimri/argus/pkg/argus"
+	"github.com/priyansh-dimri/argus/pkg/argusfasthttp"
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+	"github.com/valyala/fasthttp"
+)
+
+type nopSender struct{}
+
+func (nopSender) SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	return protocol.AnalysisResponse{}, nil
+}
+
+func newRequestCtx(method, uri, body string) *fasthttp.RequestCtx {
+	var req fasthttp.Request
+	req.Header.SetMethod(method)
+	req.SetRequestURI(uri)
+	req.Header.SetHost("example.com")
+	if body != "" {
+		req.Header.SetContentType("application/x-www-form-urlencoded")
+		req.SetBodyString(body)
+	}
+
+	ctx := new(fasthttp.RequestCtx)
+	ctx.Init(&req, nil, nil)
+	return ctx
+}
+
+func TestProtect(t *testing.T) {
+	waf, err := argus.NewWAF(argus.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create WAF: %v", err)
+	}
+	mw := argus.NewMiddleware(nopSender{}, waf, argus.Config{Mode: argus.LatencyFirst})
+	defer mw.Close(context.Background())
+
+	handler := argusfasthttp.Protect(mw, func(ctx *fasthttp.RequestCtx) {
+		v, ok := argus.VerdictFromContext(argusfasthttp.Context(ctx))
+		if !ok || v.Mode != argus.LatencyFirst {
+			t.Errorf("Expected the verdict in the argus context, got %+v", v)
+		}
+		ctx.WriteString("ok " + string(ctx.PostBody()))
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		uri    string
+		body   string
+		status int
+		resp   string
+	}{
+		{"Clean request", "GET", "/search?q=shoes", "", http.StatusOK, "ok "},
+		{"SQLi in the query", "GET", "/search?q=" + url.QueryEscape("1' OR '1'='1' --"), "", http.StatusForbidden, "Blocked by Argus Shield"},
+		{"XSS in the body", "POST", "/comments", "text=<script>alert(1)</script>", http.StatusForbidden, "Blocked by Argus Shield"},
+		{"Body kept for the handler", "POST", "/comments", "text=hello", http.StatusOK, "ok text=hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newRequestCtx(tt.method, tt.uri, tt.body)
+			handler(ctx)
+
+			if got := ctx.Response.StatusCode(); got != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, got)
+			}
+			if body := string(ctx.Response.Body()); !strings.Contains(body, tt.resp) {
+				t.Errorf("Expected body with %q, got %q", tt.resp, body)
+			}
+			if tt.status == http.StatusForbidden && len(ctx.Response.Header.Peek("X-Request-ID")) == 0 {
+				t.Error("Expected the request ID on the block response")
+			}
+		})
+	}
+
+	t.Run("No argus context outside the middleware", func(t *testing.T) {
+		ctx := newRequestCtx("GET", "/", "")
+		if _, ok := argus.VerdictFromContext(argusfasthttp.Context(ctx)); ok {
+			t.Error("Expected no verdict")
+		}
+	})
+}
+
+func TestHooksConvertTheRequest(t *testing.T) {
+	waf, err := argus.NewWAF(argus.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create WAF: %v", err)
+	}
+	mw := argus.NewMiddleware(nopSender{}, waf, argus.Config{Mode: argus.LatencyFirst})
+	defer mw.Close(context.Background())
+
+	var method, query string
+	mw.OnWAFMatch = func(r *http.Request, waf argus.WAFResult, analysis protocol.AnalysisResponse) {
+		method, query = r.Method, r.URL.Query().Get("q")
+	}
+
+	argusfasthttp.Protect(mw, func(ctx *fasthttp.RequestCtx) {})(
+		newRequestCtx("GET", "/search?q="+url.QueryEscape("<script>alert(1)</script>"), ""))
+
+	if method != "GET" || query != "<script>alert(1)</script>" {
+		t.Errorf("Expected the hook to get the request, got %s %q", method, query)
+	}
+}
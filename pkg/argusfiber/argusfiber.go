@@ -0,0 +1,24 @@
+// Package argusfiber protects fiber apps with an argus.Middleware, on the
+// fasthttp path of argusfasthttp.
+package argusfiber
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/priyansh-dimri/argus/pkg/argus"
+	"github.com/priyansh-dimri/argus/pkg/argusfasthttp"
+)
+
+// New returns a fiber handler running requests through mw. Allowed requests
+// go on with c.Next, their user context carrying the request ID and Verdict.
+func New(mw *argus.Middleware) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var err error
+		argusfasthttp.Serve(mw, c.Context(), c.UserContext(), func(ctx context.Context) {
+			c.SetUserContext(ctx)
+			err = c.Next()
+		})
+		return err
+	}
+}
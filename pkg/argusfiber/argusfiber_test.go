@@ -0,0 +1,73 @@
+package argusfiber_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/priyansh-dimri/argus/pkg/argus"
+	"github.com/priyansh-dimri/argus/pkg/argusfiber"
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+type queryWAF struct{}
+
+func (queryWAF) Check(r *http.Request) (argus.WAFResult, error) {
+	return argus.WAFResult{Blocked: strings.Contains(r.URL.RawQuery, "attack")}, nil
+}
+
+type nopSender struct{}
+
+func (nopSender) SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	return protocol.AnalysisResponse{}, nil
+}
+
+func TestNew(t *testing.T) {
+	mw := argus.NewMiddleware(nopSender{}, queryWAF{}, argus.Config{Mode: argus.LatencyFirst})
+	defer mw.Close(context.Background())
+
+	app := fiber.New()
+	app.Use(argusfiber.New(mw))
+	app.Get("/users/:id", func(c *fiber.Ctx) error {
+		if argus.RequestIDFromContext(c.UserContext()) == "" {
+			t.Error("Expected a request ID in the user context")
+		}
+		return c.SendString(c.Params("id"))
+	})
+	app.Get("/fail", func(c *fiber.Ctx) error {
+		return fiber.NewError(fiber.StatusTeapot, "short and stout")
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		body   string
+	}{
+		{"Allowed", "/users/42", http.StatusOK, "42"},
+		{"Blocked", "/users/42?q=attack", http.StatusForbidden, "Blocked by Argus Shield"},
+		{"Handler errors go through", "/fail", http.StatusTeapot, "short and stout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", tt.target, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, resp.StatusCode)
+			}
+			if !strings.Contains(string(body), tt.body) {
+				t.Errorf("Expected body with %q, got %q", tt.body, body)
+			}
+		})
+	}
+}
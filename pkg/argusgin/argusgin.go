@@ -0,0 +1,101 @@
+// Package argusgin protects gin engines with an argus.Middleware.
+package argusgin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/priyansh-dimri/argus/pkg/argus"
+)
+
+// Middleware returns a gin middleware running requests through mw. Blocked
+// requests are aborted, allowed ones go on with c.Request carrying the request
+// ID and Verdict in its context.
+func Middleware(mw *argus.Middleware) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		served := false
+		mw.Protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = true
+			c.Request = r
+			if w != http.ResponseWriter(c.Writer) {
+				// The middleware inspects the response
+				writer := c.Writer
+				rw := &responseWriter{ResponseWriter: writer, w: w}
+				c.Writer = rw
+				defer func() {
+					rw.WriteHeaderNow()
+					c.Writer = writer
+				}()
+			}
+			c.Next()
+		})).ServeHTTP(c.Writer, c.Request)
+
+		if !served {
+			c.Abort()
+		}
+	}
+}
+
+// responseWriter sends what gin handlers write through w, the response
+// inspector of the middleware.
+type responseWriter struct {
+	gin.ResponseWriter
+	w       http.ResponseWriter
+	status  int
+	size    int
+	written bool
+}
+
+func (rw *responseWriter) Header() http.Header {
+	return rw.w.Header()
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.written {
+		return
+	}
+	rw.status = code
+}
+
+func (rw *responseWriter) WriteHeaderNow() {
+	if !rw.written {
+		rw.written = true
+		rw.w.WriteHeader(rw.Status())
+	}
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.WriteHeaderNow()
+	n, err := rw.w.Write(b)
+	rw.size += n
+	return n, err
+}
+
+func (rw *responseWriter) WriteString(s string) (int, error) {
+	return rw.Write([]byte(s))
+}
+
+func (rw *responseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+func (rw *responseWriter) Size() int {
+	if !rw.written {
+		return -1
+	}
+	return rw.size
+}
+
+func (rw *responseWriter) Written() bool {
+	return rw.written
+}
+
+func (rw *responseWriter) Flush() {
+	rw.WriteHeaderNow()
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
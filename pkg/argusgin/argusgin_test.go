@@ -0,0 +1,98 @@
+package argusgin_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/priyansh-dimri/argus/pkg/argus"
+	"github.com/priyansh-dimri/argus/pkg/argusgin"
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+)
+
+// leakWAF blocks queries with "attack" in them and responses with "secret".
+type leakWAF struct{}
+
+func (leakWAF) Check(r *http.Request) (argus.WAFResult, error) {
+	return argus.WAFResult{Blocked: strings.Contains(r.URL.RawQuery, "attack")}, nil
+}
+
+func (w leakWAF) CheckRequest(r *http.Request) (argus.WAFResult, argus.ResponseCheck, error) {
+	result, err := w.Check(r)
+	return result, leakCheck{}, err
+}
+
+type leakCheck struct{}
+
+func (leakCheck) CheckResponse(status int, header http.Header, body []byte) (argus.WAFResult, error) {
+	if !bytes.Contains(body, []byte("secret")) {
+		return argus.WAFResult{}, nil
+	}
+	return argus.WAFResult{Blocked: true, Matches: []argus.RuleMatch{{RuleID: 950100}}}, nil
+}
+
+func (leakCheck) Close() error { return nil }
+
+type nopSender struct{}
+
+func (nopSender) SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	return protocol.AnalysisResponse{}, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mw := argus.NewMiddleware(nopSender{}, leakWAF{}, argus.Config{
+		Mode:               argus.LatencyFirst,
+		InspectResponses:   true,
+		BlockResponseLeaks: true,
+	})
+	defer mw.Close(context.Background())
+
+	router := gin.New()
+	router.Use(argusgin.Middleware(mw))
+	router.GET("/users", func(c *gin.Context) {
+		v, ok := argus.VerdictFromContext(c.Request.Context())
+		if !ok || v.Mode != argus.LatencyFirst {
+			t.Errorf("Expected the verdict in the request context, got %+v", v)
+		}
+		c.String(http.StatusOK, "users")
+	})
+	router.GET("/leak", func(c *gin.Context) {
+		c.String(http.StatusOK, "the secret")
+	})
+	router.DELETE("/users", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+		body   string
+	}{
+		{"Allowed", "GET", "/users", http.StatusOK, "users"},
+		{"Blocked", "GET", "/users?q=attack", http.StatusForbidden, "Blocked by Argus Shield"},
+		{"Status without body", "DELETE", "/users", http.StatusNoContent, ""},
+		{"Leaking response", "GET", "/leak", http.StatusInternalServerError, "Response blocked by Argus Shield"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+
+			if rec.Code != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), tt.body) {
+				t.Errorf("Expected body with %q, got %q", tt.body, rec.Body.String())
+			}
+		})
+	}
+}
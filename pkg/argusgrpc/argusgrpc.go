@@ -0,0 +1,197 @@
+// Package argusgrpc protects gRPC servers with an argus.Middleware. Incoming
+// messages are rendered to JSON for the WAF and the analysis, with the full
+// method name as the route. Blocked calls fail with codes.PermissionDenied.
+package argusgrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/priyansh-dimri/argus/pkg/argus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// UnaryServerInterceptor runs every request message through mw before the
+// handler sees it.
+func UnaryServerInterceptor(mw *argus.Middleware) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		body, err := render(req)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "argus: render message: %v", err)
+		}
+
+		var resp any
+		x := newExchange(ctx, info.FullMethod, body)
+		x.next = func(ctx context.Context) { resp, err = handler(ctx, req) }
+		mw.ServeExchange(x)
+		if x.err != nil {
+			return nil, x.err
+		}
+		if !x.served {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor runs every message the client streams through mw
+// as it is received. A blocked message fails RecvMsg, which ends the call.
+func StreamServerInterceptor(mw *argus.Middleware) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &serverStream{ServerStream: ss, mw: mw, method: info.FullMethod, ctx: ss.Context()})
+	}
+}
+
+// serverStream inspects received messages. Its context is the one Argus gave
+// the last message let through, so handlers find its request ID and Verdict.
+type serverStream struct {
+	grpc.ServerStream
+	mw     *argus.Middleware
+	method string
+	ctx    context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	body, err := render(m)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "argus: render message: %v", err)
+	}
+
+	x := newExchange(s.ServerStream.Context(), s.method, body)
+	x.next = func(ctx context.Context) { s.ctx = ctx }
+	s.mw.ServeExchange(x)
+	if x.err != nil {
+		return x.err
+	}
+	if !x.served {
+		return status.FromContextError(s.ServerStream.Context().Err()).Err()
+	}
+	return nil
+}
+
+// render turns a message into the JSON body the WAF inspects.
+func render(m any) ([]byte, error) {
+	if msg, ok := m.(proto.Message); ok {
+		return protojson.Marshal(msg)
+	}
+	return json.Marshal(m)
+}
+
+// exchange is one message of a call, presented to Argus as a POST of its JSON
+// to the full method name.
+type exchange struct {
+	ctx    context.Context
+	method string
+	md     metadata.MD
+	body   []byte
+	next   func(context.Context)
+
+	served bool
+	err    error
+}
+
+var _ argus.Exchange = (*exchange)(nil)
+
+func newExchange(ctx context.Context, method string, body []byte) *exchange {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return &exchange{ctx: ctx, method: method, md: md, body: body}
+}
+
+func (x *exchange) Context() context.Context { return x.ctx }
+func (x *exchange) Method() string           { return http.MethodPost }
+func (x *exchange) RequestURI() string       { return x.method }
+func (x *exchange) Path() string             { return x.method }
+func (x *exchange) Proto() string            { return "HTTP/2.0" }
+func (x *exchange) Body() []byte             { return x.body }
+
+func (x *exchange) RemoteAddr() string {
+	if p, ok := peer.FromContext(x.ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// VisitHeaders reports the metadata as headers, :authority as Host, and the
+// JSON rendering as the content type. Binary metadata is left out.
+func (x *exchange) VisitHeaders(fn func(name, value string)) {
+	if authority := x.md.Get(":authority"); len(authority) > 0 {
+		fn("Host", authority[0])
+	}
+	fn("Content-Type", "application/json")
+	for name, values := range x.md {
+		if strings.HasPrefix(name, ":") || strings.HasSuffix(name, "-bin") || name == "content-type" {
+			continue
+		}
+		for _, value := range values {
+			fn(name, value)
+		}
+	}
+}
+
+func (x *exchange) Next(ctx context.Context) {
+	x.served = true
+	x.next(ctx)
+}
+
+// Respond fails the call. The request ID goes out in the x-request-id header.
+func (x *exchange) Respond(status int, header http.Header, body []byte) {
+	if id := header.Get("X-Request-ID"); id != "" {
+		grpc.SetHeader(x.ctx, metadata.Pairs("x-request-id", id))
+	}
+	x.err = statusError(status, body)
+}
+
+func statusError(code int, body []byte) error {
+	message := strings.TrimSpace(string(body))
+	switch code {
+	case http.StatusServiceUnavailable:
+		return status.Error(codes.Unavailable, message)
+	case http.StatusRequestEntityTooLarge:
+		return status.Error(codes.ResourceExhausted, message)
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType:
+		return status.Error(codes.InvalidArgument, message)
+	default:
+		return status.Error(codes.PermissionDenied, message)
+	}
+}
+
+func (x *exchange) Request(ctx context.Context) *http.Request {
+	r := &http.Request{
+		Method:        http.MethodPost,
+		URL:           &url.URL{Path: x.method},
+		RequestURI:    x.method,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        make(http.Header),
+		ContentLength: int64(len(x.body)),
+		Body:          io.NopCloser(bytes.NewReader(x.body)),
+		RemoteAddr:    x.RemoteAddr(),
+	}
+	x.VisitHeaders(func(name, value string) {
+		if name == "Host" {
+			r.Host = value
+			return
+		}
+		r.Header.Add(name, value)
+	})
+	return r.WithContext(ctx)
+}
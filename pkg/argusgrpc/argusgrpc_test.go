@@ -0,0 +1,226 @@
+package argusgrpc_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/priyansh-dimri/argus/pkg/argus"
+	"github.com/priyansh-dimri/argus/pkg/argusgrpc"
+	"github.com/priyansh-dimri/argus/pkg/protocol"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const sqli = "1' OR '1'='1' --"
+
+// recordingSender answers sync analyses with a verdict and records what it
+// was sent.
+type recordingSender struct {
+	mu       sync.Mutex
+	isThreat bool
+	sent     []protocol.AnalysisRequest
+}
+
+func (s *recordingSender) SendAnalysis(req protocol.AnalysisRequest) (protocol.AnalysisResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, req)
+	return protocol.AnalysisResponse{IsThreat: &s.isThreat}, nil
+}
+
+func (s *recordingSender) requests() []protocol.AnalysisRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]protocol.AnalysisRequest(nil), s.sent...)
+}
+
+func newWAF(t *testing.T) *argus.WAFWrapper {
+	t.Helper()
+
+	waf, err := argus.NewWAF(argus.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create WAF: %v", err)
+	}
+	return waf
+}
+
+// dialHealth serves the health service behind the interceptors of mw over an
+// in-memory connection.
+func dialHealth(t *testing.T, mw *argus.Middleware) healthpb.HealthClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(argusgrpc.UnaryServerInterceptor(mw)),
+		grpc.StreamInterceptor(argusgrpc.StreamServerInterceptor(mw)),
+	)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	waf := newWAF(t)
+
+	tests := []struct {
+		name     string
+		mode     argus.SecurityMode
+		isThreat bool
+		service  string
+		code     codes.Code
+	}{
+		{"LatencyFirst allows clean calls", argus.LatencyFirst, false, "", codes.OK},
+		{"LatencyFirst blocks on the WAF", argus.LatencyFirst, false, sqli, codes.PermissionDenied},
+		// The health server answers NotFound for services it doesn't know
+		{"SmartShield lets the AI clear a WAF block", argus.SmartShield, false, sqli, codes.NotFound},
+		{"SmartShield blocks confirmed threats", argus.SmartShield, true, sqli, codes.PermissionDenied},
+		{"Paranoid blocks AI threats the WAF missed", argus.Paranoid, true, "", codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &recordingSender{isThreat: tt.isThreat}
+			mw := argus.NewMiddleware(sender, waf, argus.Config{Mode: tt.mode})
+			client := dialHealth(t, mw)
+
+			var header metadata.MD
+			_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: tt.service}, grpc.Header(&header))
+			mw.Close(context.Background())
+
+			if got := status.Code(err); got != tt.code {
+				t.Fatalf("Expected %s, got %s (%v)", tt.code, got, err)
+			}
+			if tt.code == codes.PermissionDenied && len(header.Get("x-request-id")) == 0 {
+				t.Error("Expected the request ID in the response header")
+			}
+
+			sent := sender.requests()
+			if len(sent) == 0 {
+				t.Fatal("Expected the call to be sent for analysis")
+			}
+			if sent[0].Route != "/grpc.health.v1.Health/Check" {
+				t.Errorf("Expected the full method as route, got %s", sent[0].Route)
+			}
+			if tt.service != "" && sent[0].Log != `{"service":"1' OR '1'='1' --"}` {
+				t.Errorf("Expected the JSON rendered message, got %s", sent[0].Log)
+			}
+		})
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	waf := newWAF(t)
+	mw := argus.NewMiddleware(&recordingSender{}, waf, argus.Config{Mode: argus.LatencyFirst})
+	defer mw.Close(context.Background())
+	client := dialHealth(t, mw)
+
+	t.Run("Blocked message ends the stream", func(t *testing.T) {
+		stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{Service: sqli})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Recv(); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Expected PermissionDenied, got %v", err)
+		}
+	})
+
+	t.Run("Clean message streams", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Expected a health update, got %v", err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("Expected SERVING, got %s", resp.Status)
+		}
+	})
+}
+
+// fakeStream hands out its messages, then io.EOF.
+type fakeStream struct {
+	grpc.ServerStream
+	messages []*structpb.Struct
+}
+
+func (s *fakeStream) Context() context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		":authority", "search.internal:443",
+		"user-agent", "grpc-go/1.81.1",
+	))
+}
+
+func (s *fakeStream) RecvMsg(m any) error {
+	if len(s.messages) == 0 {
+		return io.EOF
+	}
+	proto.Merge(m.(proto.Message), s.messages[0])
+	s.messages = s.messages[1:]
+	return nil
+}
+
+func TestStreamVerdictAndHooks(t *testing.T) {
+	waf := newWAF(t)
+	mw := argus.NewMiddleware(&recordingSender{}, waf, argus.Config{Mode: argus.LatencyFirst})
+	defer mw.Close(context.Background())
+
+	var hooked *http.Request
+	mw.OnBlock = func(r *http.Request, waf argus.WAFResult, analysis protocol.AnalysisResponse) {
+		hooked = r
+	}
+
+	clean, _ := structpb.NewStruct(map[string]any{"q": "shoes"})
+	attack, _ := structpb.NewStruct(map[string]any{"q": sqli})
+	ss := &fakeStream{messages: []*structpb.Struct{clean, attack}}
+
+	var received int
+	err := argusgrpc.StreamServerInterceptor(mw)(nil, ss, &grpc.StreamServerInfo{FullMethod: "/search.Search/Stream"},
+		func(srv any, stream grpc.ServerStream) error {
+			for {
+				var msg structpb.Struct
+				if err := stream.RecvMsg(&msg); err != nil {
+					return err
+				}
+				received++
+				if _, ok := argus.VerdictFromContext(stream.Context()); !ok {
+					t.Error("Expected the verdict in the stream context")
+				}
+			}
+		})
+
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Expected PermissionDenied, got %v", err)
+	}
+	if received != 1 {
+		t.Errorf("Expected the clean message only, got %d", received)
+	}
+	if hooked == nil || hooked.URL.Path != "/search.Search/Stream" || hooked.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected OnBlock with the call as a request, got %+v", hooked)
+	}
+}
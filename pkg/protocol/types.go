@@ -1,14 +1,111 @@
 package protocol
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"time"
+)
+
+// Versions of the AnalysisRequest schema. Version 1 has no method, query,
+// cookies, request ID, timestamp or content type, keeps only the first value
+// of each header and puts the method in Headers["Method"].
+const (
+	Version1 = 1
+	Version2 = 2
+)
+
+// ContentTypeV2 is the media type of a version 2 request body. The backend
+// takes a body without a version field as version 2 when it is sent with it.
+const ContentTypeV2 = "application/vnd.argus.v2+json"
+
+// WouldBlockIfThreat is the would_block metadata of a Shadow report whose
+// shadowed mode leaves the decision to the analysis. The backend replaces it
+// with the verdict of the analysis it runs on the report.
+const WouldBlockIfThreat = "if_threat"
 
 // Analysis Request is sent from client to Argus backend
 type AnalysisRequest struct {
-	Log      string            `json:"log"`
-	IP       string            `json:"ip"`
-	Headers  map[string]string `json:"headers"`
-	Route    string            `json:"route"`
-	MetaData map[string]string `json:"metadata"`
+	// Version is 0 in requests from version 1 clients.
+	Version     int               `json:"version,omitempty"`
+	RequestID   string            `json:"request_id,omitempty"`
+	Timestamp   time.Time         `json:"timestamp,omitzero"`
+	Method      string            `json:"method,omitempty"`
+	Route       string            `json:"route"`
+	Query       string            `json:"query,omitempty"`
+	IP          string            `json:"ip"`
+	Headers     Headers           `json:"headers"`
+	Cookies     map[string]string `json:"cookies,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Log         string            `json:"log"`
+	MetaData    map[string]string `json:"metadata"`
+}
+
+// Upgrade fills the version 2 fields of a version 1 request from what it
+// has, and takes the method out of its headers. The query, cookies and
+// timestamp can't be recovered and stay empty.
+func (r *AnalysisRequest) Upgrade() {
+	if r.Version >= Version2 {
+		return
+	}
+	r.Version = Version2
+
+	if _, ok := r.Headers["Method"]; ok {
+		if r.Method == "" {
+			r.Method = r.Headers.Get("Method")
+		}
+		// the headers may be shared with the caller's copy
+		r.Headers = maps.Clone(r.Headers)
+		delete(r.Headers, "Method")
+	}
+	if r.ContentType == "" {
+		r.ContentType = r.Headers.Get("Content-Type")
+	}
+	if r.RequestID == "" {
+		r.RequestID = r.MetaData["request_id"]
+	}
+}
+
+// Headers holds every value of each request header. It decodes the single
+// string values of version 1 requests too.
+type Headers map[string][]string
+
+// Get returns the first value of the header with exactly this name.
+func (h Headers) Get(name string) string {
+	if values := h[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (h *Headers) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*h = nil
+		return nil
+	}
+
+	headers := make(Headers, len(raw))
+	for name, value := range raw {
+		if trimmed := bytes.TrimSpace(value); len(trimmed) > 0 && trimmed[0] == '"' {
+			var single string
+			if err := json.Unmarshal(value, &single); err != nil {
+				return err
+			}
+			headers[name] = []string{single}
+			continue
+		}
+		var values []string
+		if err := json.Unmarshal(value, &values); err != nil {
+			return err
+		}
+		headers[name] = values
+	}
+	*h = headers
+	return nil
 }
 
 // Analysis Response is result sent by Argus backend
@@ -0,0 +1,143 @@
+// Package tracing sets up the OpenTelemetry tracer provider and W3C trace
+// context propagation shared by the Argus SDK, sidecar and API server.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Propagator carries W3C traceparent, tracestate and baggage headers. The SDK
+// client injects and the API server extracts with it whatever otel global
+// propagator is set, so traces connect across the hop out of the box.
+var Propagator propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Exporter is where finished spans go.
+type Exporter string
+
+const (
+	// ExporterNone records nothing. Trace context is still propagated.
+	ExporterNone Exporter = "none"
+	// ExporterStdout prints spans as JSON to stdout.
+	ExporterStdout Exporter = "stdout"
+	// ExporterFile appends spans as JSON to Config.File.
+	ExporterFile Exporter = "file"
+	// ExporterOTLP sends spans over OTLP/HTTP to Config.Endpoint, or to the
+	// standard OTEL_EXPORTER_OTLP_* endpoint when it is empty.
+	ExporterOTLP Exporter = "otlp"
+)
+
+type Config struct {
+	ServiceName string
+	// Exporter empty means ExporterNone.
+	Exporter Exporter
+	File     string
+	Endpoint string
+	// SampleRatio is the share of new traces recorded, from 0 to 1. Traces
+	// started upstream follow the sampling decision of their parent. Zero
+	// means 1.
+	SampleRatio float64
+}
+
+// ConfigFromEnv reads ARGUS_TRACE_EXPORTER, ARGUS_TRACE_FILE,
+// ARGUS_TRACE_ENDPOINT and ARGUS_TRACE_SAMPLE_RATIO. OTEL_SERVICE_NAME
+// overrides serviceName.
+func ConfigFromEnv(serviceName string) (Config, error) {
+	config := Config{
+		ServiceName: serviceName,
+		Exporter:    Exporter(os.Getenv("ARGUS_TRACE_EXPORTER")),
+		File:        os.Getenv("ARGUS_TRACE_FILE"),
+		Endpoint:    os.Getenv("ARGUS_TRACE_ENDPOINT"),
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		config.ServiceName = name
+	}
+	if v, ok := os.LookupEnv("ARGUS_TRACE_SAMPLE_RATIO"); ok {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return config, fmt.Errorf("invalid ARGUS_TRACE_SAMPLE_RATIO %q", v)
+		}
+		config.SampleRatio = ratio
+	}
+	return config, nil
+}
+
+// Setup installs the W3C trace context propagator and a tracer provider
+// exporting as config says, as the otel globals. The returned shutdown
+// flushes the spans still buffered.
+func Setup(ctx context.Context, config Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(Propagator)
+
+	var exporter sdktrace.SpanExporter
+	var file io.Closer
+	switch config.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New()
+	case ExporterFile:
+		if config.File == "" {
+			return nil, fmt.Errorf("trace exporter %s needs a file", config.Exporter)
+		}
+		f, openErr := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if openErr != nil {
+			return nil, fmt.Errorf("open trace file: %w", openErr)
+		}
+		file = f
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(config.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s trace exporter: %w", config.Exporter, err)
+	}
+
+	ratio := config.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(config.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
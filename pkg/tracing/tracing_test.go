@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Run("Reads the trace settings", func(t *testing.T) {
+		t.Setenv("ARGUS_TRACE_EXPORTER", "otlp")
+		t.Setenv("ARGUS_TRACE_ENDPOINT", "http://collector:4318/v1/traces")
+		t.Setenv("ARGUS_TRACE_SAMPLE_RATIO", "0.25")
+		t.Setenv("OTEL_SERVICE_NAME", "checkout-sidecar")
+
+		config, err := ConfigFromEnv("argus-sidecar")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if config.Exporter != ExporterOTLP {
+			t.Errorf("Expected exporter otlp, got %s", config.Exporter)
+		}
+		if config.Endpoint != "http://collector:4318/v1/traces" {
+			t.Errorf("Expected the endpoint, got %s", config.Endpoint)
+		}
+		if config.SampleRatio != 0.25 {
+			t.Errorf("Expected ratio 0.25, got %v", config.SampleRatio)
+		}
+		if config.ServiceName != "checkout-sidecar" {
+			t.Errorf("Expected OTEL_SERVICE_NAME to win, got %s", config.ServiceName)
+		}
+	})
+
+	t.Run("Rejects a bad sample ratio", func(t *testing.T) {
+		t.Setenv("ARGUS_TRACE_SAMPLE_RATIO", "2")
+
+		if _, err := ConfigFromEnv("argus-api"); err == nil {
+			t.Error("Expected an error for a ratio above 1")
+		}
+	})
+}
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	t.Run("File exporter writes finished spans", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "spans.json")
+		shutdown, err := Setup(context.Background(), Config{ServiceName: "argus-test", Exporter: ExporterFile, File: path})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		_, span := otel.Tracer("test").Start(context.Background(), "test.span")
+		span.End()
+		if err := shutdown(context.Background()); err != nil {
+			t.Fatalf("Expected a clean shutdown, got %v", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{`"Name":"test.span"`, `"argus-test"`} {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("Expected %s in the trace file, got %s", want, data)
+			}
+		}
+	})
+
+	t.Run("Invalid exporters", func(t *testing.T) {
+		for _, config := range []Config{{Exporter: "zipkin"}, {Exporter: ExporterFile}} {
+			if _, err := Setup(context.Background(), config); err == nil {
+				t.Errorf("Expected an error for %+v", config)
+			}
+		}
+	})
+
+	t.Run("No exporter", func(t *testing.T) {
+		shutdown, err := Setup(context.Background(), Config{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("Expected a no-op shutdown, got %v", err)
+		}
+	})
+}
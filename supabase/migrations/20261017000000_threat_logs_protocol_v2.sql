@@ -0,0 +1,9 @@
+-- Columns for the fields of analysis protocol version 2. Rows stored by a
+-- version 1 backend keep protocol_version 1 and empty cookies.
+ALTER TABLE threat_logs
+  ADD COLUMN IF NOT EXISTS protocol_version integer NOT NULL DEFAULT 1,
+  ADD COLUMN IF NOT EXISTS request_id text,
+  ADD COLUMN IF NOT EXISTS query text,
+  ADD COLUMN IF NOT EXISTS cookies jsonb NOT NULL DEFAULT '{}',
+  ADD COLUMN IF NOT EXISTS content_type text,
+  ADD COLUMN IF NOT EXISTS request_timestamp timestamptz;